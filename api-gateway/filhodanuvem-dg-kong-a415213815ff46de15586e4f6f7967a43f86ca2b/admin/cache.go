@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/devgymbr/kong/plugin"
+)
+
+// DELETE /proxy-cache
+func (s *Server) purgeAllCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	plugin.PurgeAllCacheStores()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DELETE /proxy-cache/{key}, onde key é o valor do header X-Cache-Key
+func (s *Server) purgeCacheKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		methodNotAllowed(w, http.MethodDelete)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/proxy-cache/")
+	if key == "" {
+		s.purgeAllCache(w, r)
+		return
+	}
+
+	if !plugin.PurgeCacheKey(key) {
+		writeError(w, http.StatusNotFound, "cache key not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
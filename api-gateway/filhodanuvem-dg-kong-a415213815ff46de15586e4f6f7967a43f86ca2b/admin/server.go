@@ -0,0 +1,113 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/devgymbr/kong"
+)
+
+// Server é a API administrativa do gateway. Ela deve ficar num listener separado
+// do tráfego dos clientes, que não pode alcançar essas rotas.
+//
+// Sem Token, só clientes em loopback chegam às rotas que leem ou alteram a
+// configuração. Com Token, elas exigem o header Authorization: Bearer <token>.
+// Um certificado de cliente validado pelo listener (mTLS) também é aceito.
+type Server struct {
+	// Token é o segredo exigido nas rotas administrativas.
+	Token string
+	// ConfigFile, quando preenchido, recebe a configuração a cada alteração feita pela API.
+	ConfigFile string
+	// Upstreams, quando preenchido, dá a saúde dos upstreams mostrada no /status.
+	Upstreams UpstreamHealthReporter
+
+	mux    *http.ServeMux
+	holder *kong.ConfigHolder
+	mu     sync.Mutex
+}
+
+func NewServer(holder *kong.ConfigHolder) *Server {
+	s := &Server{mux: http.NewServeMux(), holder: holder}
+
+	s.mux.HandleFunc("/services", s.services)
+	s.mux.HandleFunc("/services/", s.service)
+	s.mux.HandleFunc("/proxy-cache", s.purgeAllCache)
+	s.mux.HandleFunc("/proxy-cache/", s.purgeCacheKey)
+	s.mux.HandleFunc("/status", s.status)
+	s.mux.HandleFunc("/metrics", s.metrics)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /status e /metrics são usados por health checks e pelo scraper do Prometheus;
+	// sem autorização o /status só responde a versão da configuração
+	if r.URL.Path != "/status" && r.URL.Path != "/metrics" && !s.authorized(r) {
+		if s.Token != "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kong-admin"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid admin token")
+			return
+		}
+
+		writeError(w, http.StatusForbidden, "the Admin API only accepts local clients without an admin token")
+		return
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	if s.Token == "" {
+		return IsLoopback(r.RemoteAddr)
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// IsLoopback diz se o endereço (host:porta ou só o host) é da própria máquina.
+// Um host vazio, como em ":8001", escuta em todas as interfaces.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type errorResponse struct {
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("could not encode admin response", slog.String("error", err.Error()))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Message: message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
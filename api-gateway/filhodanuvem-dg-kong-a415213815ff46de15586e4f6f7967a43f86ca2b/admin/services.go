@@ -0,0 +1,450 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("already exists")
+	errInvalid  = errors.New("invalid config")
+)
+
+type listResponse[T any] struct {
+	Data []T `json:"data"`
+}
+
+type validationResponse struct {
+	Message string   `json:"message"`
+	Errors  []string `json:"errors"`
+}
+
+// update aplica change numa cópia da configuração ativa e, se ela continuar válida,
+// troca a ativa por ela de uma vez. As escritas são serializadas para uma não
+// sobrescrever a outra.
+func (s *Server) update(change func(c *kong.Config) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := s.holder.Load().Clone()
+	if err := change(next); err != nil {
+		return err
+	}
+
+	if err := config.Validate(next); err != nil {
+		return fmt.Errorf("%w: %w", errInvalid, err)
+	}
+
+	if err := next.Compile(); err != nil {
+		return fmt.Errorf("%w: %w", errInvalid, err)
+	}
+
+	if s.ConfigFile != "" {
+		if err := config.WriteFile(s.ConfigFile, next); err != nil {
+			return err
+		}
+	}
+
+	s.holder.Store(next)
+
+	return nil
+}
+
+func writeUpdateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errConflict):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errInvalid):
+		response := validationResponse{Message: errInvalid.Error()}
+		for _, e := range unwrapAll(err) {
+			response.Errors = append(response.Errors, e.Error())
+		}
+		writeJSON(w, http.StatusBadRequest, response)
+	default:
+		slog.Error("could not update config", slog.String("error", err.Error()))
+		writeError(w, http.StatusInternalServerError, "could not update config")
+	}
+}
+
+// unwrapAll devolve os erros juntados pelo config.Validate um a um.
+func unwrapAll(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		var errs []error
+		for _, e := range joined.Unwrap() {
+			if !errors.Is(e, errInvalid) {
+				errs = append(errs, unwrapAll(e)...)
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+	}
+
+	return []error{err}
+}
+
+// decodeBody aceita JSON, mas decodifica com o yaml (JSON também é YAML válido)
+// para os números do input dos plugins virarem int, como acontece no config.yaml.
+func decodeBody(r *http.Request, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err = yaml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: malformed body: %w", errInvalid, err)
+	}
+
+	return nil
+}
+
+// GET, POST /services
+func (s *Server) services(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, listResponse[kong.Service]{Data: s.holder.Load().Services})
+	case http.MethodPost:
+		var service kong.Service
+		if err := decodeBody(r, &service); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		err := s.update(func(c *kong.Config) error {
+			if findService(c, service.Name) != -1 {
+				return fmt.Errorf("service %q %w", service.Name, errConflict)
+			}
+			c.Services = append(c.Services, service)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, service)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+// /services/{service}[/routes[/{route}] | /plugins[/{plugin}]]
+func (s *Server) service(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/services/"), "/"), "/")
+	serviceName := parts[0]
+
+	switch {
+	case len(parts) == 1:
+		s.serviceEntity(w, r, serviceName)
+	case len(parts) == 2 && parts[1] == "routes":
+		s.routes(w, r, serviceName)
+	case len(parts) == 3 && parts[1] == "routes":
+		s.route(w, r, serviceName, parts[2])
+	case len(parts) == 2 && parts[1] == "plugins":
+		s.plugins(w, r, serviceName)
+	case len(parts) == 3 && parts[1] == "plugins":
+		s.plugin(w, r, serviceName, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (s *Server) serviceEntity(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := s.holder.Load()
+		i := findService(c, name)
+		if i == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service %q %s", name, errNotFound))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, c.Services[i])
+	case http.MethodPut:
+		var service kong.Service
+		if err := decodeBody(r, &service); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+		service.Name = name
+
+		created := false
+		err := s.update(func(c *kong.Config) error {
+			i := findService(c, name)
+			if i == -1 {
+				created = true
+				c.Services = append(c.Services, service)
+				return nil
+			}
+			c.Services[i] = service
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, upsertStatus(created), service)
+	case http.MethodDelete:
+		err := s.update(func(c *kong.Config) error {
+			i := findService(c, name)
+			if i == -1 {
+				return fmt.Errorf("service %q %w", name, errNotFound)
+			}
+			c.Services = slices.Delete(c.Services, i, i+1)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+func (s *Server) routes(w http.ResponseWriter, r *http.Request, serviceName string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := s.holder.Load()
+		i := findService(c, serviceName)
+		if i == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service %q %s", serviceName, errNotFound))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse[kong.Route]{Data: c.Services[i].Routes})
+	case http.MethodPost:
+		var route kong.Route
+		if err := decodeBody(r, &route); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			if route.Name == "" {
+				return fmt.Errorf("%w: route name is required", errInvalid)
+			}
+
+			if findRoute(service, route.Name) != -1 {
+				return fmt.Errorf("route %q %w", route.Name, errConflict)
+			}
+			service.Routes = append(service.Routes, route)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, route)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, serviceName, routeName string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := s.holder.Load()
+		i := findService(c, serviceName)
+		if i == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service %q %s", serviceName, errNotFound))
+			return
+		}
+
+		j := findRoute(&c.Services[i], routeName)
+		if j == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("route %q %s", routeName, errNotFound))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, c.Services[i].Routes[j])
+	case http.MethodPut:
+		var route kong.Route
+		if err := decodeBody(r, &route); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+		route.Name = routeName
+
+		created := false
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			j := findRoute(service, routeName)
+			if j == -1 {
+				created = true
+				service.Routes = append(service.Routes, route)
+				return nil
+			}
+			service.Routes[j] = route
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, upsertStatus(created), route)
+	case http.MethodDelete:
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			j := findRoute(service, routeName)
+			if j == -1 {
+				return fmt.Errorf("route %q %w", routeName, errNotFound)
+			}
+			service.Routes = slices.Delete(service.Routes, j, j+1)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+func (s *Server) plugins(w http.ResponseWriter, r *http.Request, serviceName string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := s.holder.Load()
+		i := findService(c, serviceName)
+		if i == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service %q %s", serviceName, errNotFound))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, listResponse[kong.Plugin]{Data: c.Services[i].Plugins})
+	case http.MethodPost:
+		var p kong.Plugin
+		if err := decodeBody(r, &p); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			if findPlugin(service, p.Name) != -1 {
+				return fmt.Errorf("plugin %q %w", p.Name, errConflict)
+			}
+			service.Plugins = append(service.Plugins, p)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, p)
+	default:
+		methodNotAllowed(w, "GET, POST")
+	}
+}
+
+func (s *Server) plugin(w http.ResponseWriter, r *http.Request, serviceName, pluginName string) {
+	switch r.Method {
+	case http.MethodGet:
+		c := s.holder.Load()
+		i := findService(c, serviceName)
+		if i == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("service %q %s", serviceName, errNotFound))
+			return
+		}
+
+		j := findPlugin(&c.Services[i], pluginName)
+		if j == -1 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("plugin %q %s", pluginName, errNotFound))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, c.Services[i].Plugins[j])
+	case http.MethodPut:
+		var p kong.Plugin
+		if err := decodeBody(r, &p); err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+		p.Name = pluginName
+
+		created := false
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			j := findPlugin(service, pluginName)
+			if j == -1 {
+				created = true
+				service.Plugins = append(service.Plugins, p)
+				return nil
+			}
+			service.Plugins[j] = p
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		writeJSON(w, upsertStatus(created), p)
+	case http.MethodDelete:
+		err := s.updateService(serviceName, func(service *kong.Service) error {
+			j := findPlugin(service, pluginName)
+			if j == -1 {
+				return fmt.Errorf("plugin %q %w", pluginName, errNotFound)
+			}
+			service.Plugins = slices.Delete(service.Plugins, j, j+1)
+			return nil
+		})
+		if err != nil {
+			writeUpdateError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		methodNotAllowed(w, "GET, PUT, DELETE")
+	}
+}
+
+func (s *Server) updateService(name string, change func(service *kong.Service) error) error {
+	return s.update(func(c *kong.Config) error {
+		i := findService(c, name)
+		if i == -1 {
+			return fmt.Errorf("service %q %w", name, errNotFound)
+		}
+
+		return change(&c.Services[i])
+	})
+}
+
+func upsertStatus(created bool) int {
+	if created {
+		return http.StatusCreated
+	}
+
+	return http.StatusOK
+}
+
+func findService(c *kong.Config, name string) int {
+	return slices.IndexFunc(c.Services, func(s kong.Service) bool { return s.Name == name })
+}
+
+func findRoute(service *kong.Service, name string) int {
+	return slices.IndexFunc(service.Routes, func(r kong.Route) bool { return r.Name == name })
+}
+
+func findPlugin(service *kong.Service, name string) int {
+	return slices.IndexFunc(service.Plugins, func(p kong.Plugin) bool { return p.Name == name })
+}
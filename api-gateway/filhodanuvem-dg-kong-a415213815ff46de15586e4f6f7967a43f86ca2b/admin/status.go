@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
+)
+
+// UpstreamHealthReporter informa a saúde dos upstreams, como o ForwardClient do gateway.
+type UpstreamHealthReporter interface {
+	UpstreamHealth() map[string]kong.UpstreamHealth
+}
+
+// statusResponse tem só a versão da configuração para clientes sem autorização, como
+// os health checks de fora da máquina. Os upstreams têm hosts internos e os erros
+// de cada um, então só vão para quem pode usar o resto da Admin API.
+type statusResponse struct {
+	Config    configStatus     `json:"config"`
+	Upstreams []upstreamStatus `json:"upstreams,omitempty"`
+}
+
+type configStatus struct {
+	Version  uint64    `json:"version"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+type upstreamStatus struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+	// Health é healthy, unhealthy ou unknown quando o serviço ainda não recebeu requests
+	Health string               `json:"health"`
+	Detail *kong.UpstreamHealth `json:"detail,omitempty"`
+}
+
+// GET /status
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	version := s.holder.Version()
+	response := statusResponse{
+		Config: configStatus{Version: version.Version, LoadedAt: version.LoadedAt},
+	}
+
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	var health map[string]kong.UpstreamHealth
+	if s.Upstreams != nil {
+		health = s.Upstreams.UpstreamHealth()
+	}
+
+	for _, service := range s.holder.Load().Services {
+		status := upstreamStatus{Service: service.Name, URL: withoutUserinfo(service.URL), Health: "unknown"}
+		if state, ok := health[service.Name]; ok {
+			status.Health = "unhealthy"
+			if state.Healthy {
+				status.Health = "healthy"
+			}
+			status.Detail = &state
+		}
+
+		response.Upstreams = append(response.Upstreams, status)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// withoutUserinfo tira usuário e senha da url do serviço, que não saem da configuração.
+func withoutUserinfo(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+
+	u.User = nil
+	return u.String()
+}
+
+// GET /metrics, no formato texto do Prometheus
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := plugin.WriteMetrics(w); err != nil {
+		slog.Error("could not write metrics", slog.String("error", err.Error()))
+	}
+}
@@ -1,36 +1,298 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/admin"
 	"github.com/devgymbr/kong/config"
 	internalhttp "github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/openapi"
 	"github.com/devgymbr/kong/plugin"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import-openapi" {
+		os.Exit(importOpenAPI(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", "config.yaml", "config file (YAML or JSON) or directory of config fragments")
+	addr := flag.String("addr", ":8080", "address of the proxy listener")
+	adminAddr := flag.String("admin-addr", "127.0.0.1:8001", "address of the Admin API listener")
+	adminToken := flag.String("admin-token", os.Getenv("KONG_ADMIN_TOKEN"), "bearer token required by the Admin API (defaults to $KONG_ADMIN_TOKEN)")
+	adminCert := flag.String("admin-tls-cert", "", "certificate of the Admin API listener, enables HTTPS")
+	adminKey := flag.String("admin-tls-key", "", "private key of the Admin API listener")
+	adminClientCA := flag.String("admin-client-ca", "", "CA bundle used to require client certificates (mTLS) on the Admin API")
+	writeBack := flag.Bool("admin-write-back", false, "save changes made through the Admin API to the config file")
+	httpsAddr := flag.String("https-addr", "", "address of the HTTPS listener, like :8443; disabled when empty")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long SIGTERM waits for the requests in progress")
+	flag.Parse()
+
 	l := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	slog.SetDefault(l)
 
-	plugin.Register("http_log", plugin.Log)
-	plugin.Register("add_header", plugin.AddHeader)
-	plugin.Register("jwt_auth", plugin.JWTAuth)
-	plugin.Register("request_size_limiting", plugin.RequestSizeLimit)
+	registerPlugins()
 
-	c := &kong.Config{}
-	if err := config.Loader(c, "config.yaml", 5*time.Second); err != nil {
+	holder := kong.NewConfigHolder(&kong.Config{})
+	reloader, err := config.Loader(holder, *configPath)
+	if err != nil {
 		panic(err)
 	}
 
-	server := internalhttp.NewServer(c)
+	if holder.Load().TLS.RedirectHTTP && *httpsAddr == "" {
+		slog.Warn("tls.redirect_http is enabled but -https-addr is empty, there is no HTTPS listener to redirect to")
+	}
+
+	// kill -HUP <pid> força a leitura da configuração
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				slog.Error("could not reload config, keeping the last valid one", slog.String("error", err.Error()))
+			}
+		}
+	}()
+
+	server := internalhttp.NewServerFromHolder(holder)
+
+	adminServer := admin.NewServer(holder)
+	adminServer.Upstreams = server.Client
+	adminServer.Token = *adminToken
+	if *writeBack {
+		if info, err := os.Stat(*configPath); err == nil && info.IsDir() {
+			panic("admin-write-back needs a single config file, not a directory")
+		}
+		adminServer.ConfigFile = *configPath
+	}
+
+	adminListener, err := newAdminListener(*adminAddr, adminServer, *adminCert, *adminKey, *adminClientCA)
+	if err != nil {
+		panic(err)
+	}
+
+	servers := []*http.Server{adminListener.server}
+	go func() {
+		fmt.Printf("Admin API listening on %s...\n", *adminAddr)
+		if err := adminListener.serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+
+	if *httpsAddr != "" {
+		// os certificados vêm da seção tls da configuração
+		tlsServer := &http.Server{Addr: *httpsAddr, Handler: server, TLSConfig: server.TLSConfig()}
+		servers = append(servers, tlsServer)
+		go func() {
+			fmt.Printf("Server listening with TLS on %s...\n", *httpsAddr)
+			if err := tlsServer.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}()
+	}
+
+	proxyServer := &http.Server{Addr: *addr, Handler: server, Protocols: internalhttp.ListenerProtocols()}
+	servers = append(servers, proxyServer)
+	go func() {
+		fmt.Printf("Server listening on %s...\n", *addr)
+		if err := proxyServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
 
-	fmt.Println("Server listening on port 8080...")
+	// kill -TERM ou Ctrl+C espera as requests em andamento e envia o que ainda está
+	// nas filas dos plugins de log
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	slog.Info("shutting down, waiting for the requests in progress")
+	shutdown(servers, *shutdownTimeout)
+	plugin.FlushAccessLogs()
+}
+
+// shutdown para de aceitar conexões e espera as requests em andamento, no máximo
+// até timeout.
+func shutdown(servers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("could not shut down listener", slog.String("addr", server.Addr), slog.String("error", err.Error()))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type adminListener struct {
+	server *http.Server
+	cert   string
+	key    string
+}
+
+func (l adminListener) serve() error {
+	if l.cert != "" {
+		return l.server.ListenAndServeTLS(l.cert, l.key)
+	}
+
+	return l.server.ListenAndServe()
+}
+
+// newAdminListener monta o listener da Admin API. Ela só escuta fora do loopback
+// com um token ou com mTLS, já que as rotas alteram a configuração do gateway.
+func newAdminListener(addr string, handler *admin.Server, cert, key, clientCA string) (adminListener, error) {
+	if (cert == "") != (key == "") {
+		return adminListener{}, errors.New("admin-tls-cert and admin-tls-key must be set together")
+	}
+
+	if clientCA != "" && cert == "" {
+		return adminListener{}, errors.New("admin-client-ca needs admin-tls-cert and admin-tls-key")
+	}
 
-	http.Handle("/", server)
-	http.ListenAndServe(":8080", nil)
+	if !admin.IsLoopback(addr) && handler.Token == "" && clientCA == "" {
+		return adminListener{}, fmt.Errorf("the Admin API on %s is reachable from other hosts, set admin-token or admin-client-ca", addr)
+	}
+
+	server := &http.Server{Addr: addr, Handler: handler}
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return adminListener{}, fmt.Errorf("could not read admin client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return adminListener{}, fmt.Errorf("no certificate found in %s", clientCA)
+		}
+
+		server.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	}
+
+	return adminListener{server: server, cert: cert, key: key}, nil
+}
+
+func registerPlugins() {
+	plugin.RegisterPlugin("cors", plugin.CORSPlugin)
+	plugin.RegisterPlugin("http_log", plugin.HTTPLogPlugin)
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+	plugin.RegisterPlugin("tcp_log", plugin.TCPLogPlugin)
+	plugin.RegisterPlugin("prometheus", plugin.PrometheusPlugin)
+	plugin.RegisterPlugin("add_header", plugin.AddHeaderPlugin)
+	plugin.RegisterPlugin("jwt_auth", plugin.JWTAuthPlugin)
+	plugin.RegisterPlugin("key_auth", plugin.KeyAuthPlugin)
+	plugin.RegisterPlugin("basic_auth", plugin.BasicAuthPlugin)
+	plugin.RegisterPlugin("acl", plugin.ACLPlugin)
+	plugin.RegisterPlugin("ip_restriction", plugin.IPRestrictionPlugin)
+	plugin.RegisterPlugin("bot_detection", plugin.BotDetectionPlugin)
+	plugin.RegisterPlugin("request_size_limiting", plugin.RequestSizeLimitPlugin)
+	plugin.RegisterPlugin("request_validator", plugin.RequestValidatorPlugin)
+	plugin.RegisterPlugin("rate_limiting", plugin.RateLimitPlugin)
+	plugin.RegisterPlugin("proxy_cache", plugin.ProxyCachePlugin)
+	plugin.RegisterPlugin("request_mirror", plugin.RequestMirrorPlugin)
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+	plugin.RegisterPlugin("request_transformer", plugin.RequestTransformerPlugin)
+	plugin.RegisterPlugin("response_transformer", plugin.ResponseTransformerPlugin)
+}
+
+// validate é o subcomando kong validate: carrega a configuração como o servidor
+// faria e lista os erros, sem subir nenhum listener.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "config file (YAML or JSON) or directory of config fragments")
+	flags.Parse(args)
+
+	registerPlugins()
+
+	c, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("%s is valid: %d services, %d consumers, %d global plugins\n", *configPath, len(c.Services), len(c.Consumers), len(c.Plugins))
+	return 0
+}
+
+// importOpenAPI é o subcomando kong import-openapi: gera um serviço com as rotas do
+// documento OpenAPI. A saída é um fragmento de configuração, que pode ir direto
+// para o diretório passado em -config.
+func importOpenAPI(args []string) int {
+	flags := flag.NewFlagSet("import-openapi", flag.ExitOnError)
+	name := flags.String("name", "", "service name, defaults to the document info.title")
+	upstreamURL := flags.String("url", "", "upstream url, defaults to the first of the document servers")
+	validation := flags.Bool("validation", false, "add a request_validator to each route with the operation schemas")
+	output := flags.String("output", "", "file to write the service to (YAML or JSON), defaults to stdout")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: kong import-openapi [flags] <openapi.yaml>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	service, err := openapi.Import(data, openapi.Options{Name: *name, URL: *upstreamURL, Validation: *validation})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	registerPlugins()
+
+	// o serviço gerado passa pela mesma validação que o servidor faria ao carregar
+	if errs := config.ValidateService(service); len(errs) > 0 {
+		fmt.Fprintln(os.Stderr, errors.Join(errs...))
+		return 1
+	}
+
+	c := &kong.Config{Services: []kong.Service{service}}
+	if *output != "" {
+		err = config.WriteFile(*output, c)
+	} else {
+		var out []byte
+		if out, err = yaml.Marshal(c); err == nil {
+			_, err = os.Stdout.Write(out)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *output != "" {
+		fmt.Printf("%s: service %q with %d routes\n", *output, service.Name, len(service.Routes))
+	}
+	return 0
 }
@@ -1,8 +1,8 @@
 package kong
 
 import (
+	"fmt"
 	"net/http"
-	"slices"
 	"time"
 
 	"github.com/devgymbr/kong/routes"
@@ -10,8 +10,15 @@ import (
 )
 
 type Config struct {
-	Services             []Service `yaml:"services"`
+	// Plugins globais valem para todos os serviços
+	Plugins              []Plugin   `yaml:"plugins,omitempty" json:"plugins,omitempty"`
+	Services             []Service  `yaml:"services" json:"services"`
+	Consumers            []Consumer `yaml:"consumers,omitempty" json:"consumers,omitempty"`
+	TLS                  TLSConfig  `yaml:"tls,omitempty" json:"tls,omitempty"`
 	lastModificationTime time.Time
+	router               *routes.Router[routeEntry]
+	consumers            *consumerIndex
+	certificates         *certificateIndex
 }
 
 func (c *Config) ModifiedSince(t time.Time) bool {
@@ -25,33 +32,123 @@ func (c *Config) Refresh(data []byte, modTime time.Time) error {
 	}
 	c.lastModificationTime = modTime
 
+	return c.Compile()
+}
+
+// Compile monta o router usado pelo Match com todos os paths de todas as rotas,
+// o índice das credenciais dos consumers e carrega os certificados TLS, do
+// listener e dos upstreams.
+func (c *Config) Compile() error {
+	router := routes.NewRouter[routeEntry]()
+	order := 0
+
+	var err error
 	for i := range c.Services {
 		service := &c.Services[i]
+		if service.TLS.loaded, err = service.TLS.Config(); err != nil {
+			return fmt.Errorf("service %q: tls: %w", service.Name, err)
+		}
+
 		for j := range c.Services[i].Routes {
-			service.Routes[j].PathRegexp, err = routes.Parse(service.Routes[j].Paths[0])
+			route := &service.Routes[j]
+			if len(route.Paths) == 0 {
+				continue
+			}
+
+			route.PathRegexp, err = routes.Parse(route.Paths[0])
 			if err != nil {
 				return err
 			}
+
+			for _, path := range route.Paths {
+				entry := routeEntry{
+					service:        i,
+					route:          j,
+					staticSegments: routes.StaticSegments(path),
+					order:          order,
+				}
+				order++
+
+				if err = router.Add(path, route.Match == MatchPrefix, entry); err != nil {
+					return err
+				}
+			}
 		}
 	}
+	certificates, err := loadCertificates(c.TLS.Certificates)
+	if err != nil {
+		return err
+	}
+
+	c.router = router
+	c.consumers = newConsumerIndex(c.Consumers)
+	c.certificates = certificates
 
 	return nil
 }
 
-func (c *Config) FindServiceRoute(r *http.Request) (*Service, *Route) {
-	for _, service := range c.Services {
-		for _, route := range service.Routes {
-			if route.PathRegexp == nil {
+// Clone devolve uma cópia que pode ser alterada sem afetar quem ainda usa a original.
+func (c *Config) Clone() *Config {
+	clone := &Config{
+		Plugins:              clonePlugins(c.Plugins),
+		Services:             make([]Service, len(c.Services)),
+		TLS:                  c.TLS.Clone(),
+		lastModificationTime: c.lastModificationTime,
+	}
+
+	for i, service := range c.Services {
+		clone.Services[i] = service.Clone()
+	}
+
+	if c.Consumers != nil {
+		clone.Consumers = make([]Consumer, len(c.Consumers))
+		for i, consumer := range c.Consumers {
+			clone.Consumers[i] = consumer.Clone()
+		}
+	}
+
+	return clone
+}
+
+// PluginsFor junta os plugins globais, os do serviço e os da rota da request.
+// Um plugin declarado num nível mais específico substitui os de mesmo nome dos
+// níveis acima: o da rota vale mais que o do serviço, que vale mais que o global.
+func (c *Config) PluginsFor(match *RouteMatch) []Plugin {
+	levels := [][]Plugin{c.Plugins, match.Service.Plugins}
+	if match.Route != nil {
+		levels = append(levels, match.Route.Plugins)
+	}
+
+	var plugins []Plugin
+	for i, level := range levels {
+		for _, p := range level {
+			if overridden(p.Name, levels[i+1:]) {
 				continue
 			}
+			plugins = append(plugins, p)
+		}
+	}
 
-			if slices.Index[[]string, string](route.Methods, r.Method) != -1 &&
-				route.PathRegexp.MatchString(r.URL.Path) {
+	return plugins
+}
 
-				return &service, &route
+func overridden(name string, levels [][]Plugin) bool {
+	for _, level := range levels {
+		for _, p := range level {
+			if p.Name == name {
+				return true
 			}
 		}
 	}
 
-	return nil, nil
+	return false
+}
+
+func (c *Config) FindServiceRoute(r *http.Request) (*Service, *Route) {
+	match := c.Match(r)
+	if match == nil {
+		return nil, nil
+	}
+
+	return match.Service, match.Route
 }
@@ -1,46 +1,71 @@
 package config
 
 import (
-	"io"
+	"fmt"
 	"log/slog"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/devgymbr/kong"
 )
 
-func Loader(config *kong.Config, fileName string, tickerDuration time.Duration) error {
-	fp, err := openFile(fileName)
-	if err != nil {
-		return err
+// debounceInterval agrupa as várias notificações que um editor gera ao salvar o arquivo.
+const debounceInterval = 50 * time.Millisecond
+
+// Reloader lê o arquivo (ou o diretório de fragmentos) de configuração e troca a configuração ativa do holder.
+// Um arquivo inválido nunca chega ao holder: a última configuração boa continua ativa.
+type Reloader struct {
+	holder   *kong.ConfigHolder
+	fileName string
+	mu       sync.Mutex
+	watcher  *fileWatcher
+}
+
+func NewReloader(holder *kong.ConfigHolder, fileName string) *Reloader {
+	return &Reloader{holder: holder, fileName: fileName}
+}
+
+// Loader carrega fileName no holder e passa a recarregar sempre que o arquivo muda.
+// fileName pode ser um diretório, e aí qualquer fragmento alterado recarrega tudo.
+func Loader(holder *kong.ConfigHolder, fileName string) (*Reloader, error) {
+	r := NewReloader(holder, fileName)
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	if err := r.Watch(); err != nil {
+		return nil, err
 	}
 
-	if err = refreshConfig(config, fp); err != nil {
+	return r, nil
+}
+
+// Reload lê o arquivo mesmo que ele não tenha mudado, é o que o SIGHUP usa.
+func (r *Reloader) Reload() error {
+	return r.refresh(true)
+}
+
+// Watch observa o arquivo até o Close ser chamado.
+func (r *Reloader) Watch() error {
+	watcher, err := newFileWatcher(r.fileName)
+	if err != nil {
 		return err
 	}
-	fp.Close()
+	r.watcher = watcher
 
 	go func() {
-		defer fp.Close()
-		t := time.NewTicker(tickerDuration)
-		defer t.Stop()
-
-		for {
-			select {
-			case <-t.C:
-				slog.Debug("checking config file for changes")
-				fp, err = openFile(fileName)
-				if err != nil {
-					slog.Error("could not open config file: %s", err)
-					continue
-				}
-
-				if err = refreshConfig(config, fp); err != nil {
-					slog.Error("could not refresh config: %s", err)
-					fp.Close()
-					continue
-				}
-				fp.Close()
+		for range watcher.events {
+			// espera o arquivo terminar de ser escrito antes de ler
+			time.Sleep(debounceInterval)
+			watcher.drain()
+
+			slog.Debug("config file changed", slog.String("file", r.fileName))
+			if err := r.refresh(false); err != nil {
+				slog.Error("could not refresh config, keeping the last valid one",
+					slog.String("file", r.fileName),
+					slog.String("error", err.Error()),
+				)
 			}
 		}
 	}()
@@ -48,31 +73,54 @@ func Loader(config *kong.Config, fileName string, tickerDuration time.Duration)
 	return nil
 }
 
-func openFile(fileName string) (*os.File, error) {
-	return os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0755)
+func (r *Reloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+
+	return r.watcher.close()
 }
 
-func refreshConfig(config *kong.Config, fp *os.File) error {
-	data, err := io.ReadAll(fp)
-	if err != nil {
-		return err
-	}
+func (r *Reloader) refresh(force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	info, err := fp.Stat()
+	fragments, modTime, err := readFragments(r.fileName, true)
 	if err != nil {
 		return err
 	}
 
-	modTime := info.ModTime()
-	if config.ModifiedSince(modTime) {
+	if !force && r.holder.Load().ModifiedSince(modTime) {
 		return nil
 	}
 
 	slog.Debug("updating config based on changes")
 
-	err = config.Refresh(data, modTime)
+	config, err := parseFragments(fragments, modTime)
+	if err != nil {
+		return err
+	}
+	r.holder.Store(config)
+
+	slog.Info("config updated", slog.String("file", r.fileName))
+
+	return nil
+}
 
-	slog.Info("config updated")
+// Parse monta uma configuração nova a partir de data e só devolve ela se for válida.
+func Parse(data []byte, modTime time.Time) (*kong.Config, error) {
+	config := &kong.Config{}
+	if err := config.Refresh(data, modTime); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	if err := Validate(config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
-	return err
+	return config, nil
+}
+
+func openFile(fileName string) (*os.File, error) {
+	return os.OpenFile(fileName, os.O_RDONLY|os.O_CREATE, 0755)
 }
@@ -1,10 +1,21 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
 )
 
 func TestEmptyYaml(t *testing.T) {
@@ -54,3 +65,272 @@ services:
 		t.Errorf("expected service name to be payments got %v", c.Services[0].Name)
 	}
 }
+
+func TestParseRejectsInvalidConfig(t *testing.T) {
+	yaml := []byte(
+		`
+services:
+- name: payments
+  url: localhost
+  routes:
+  - paths:
+    - /payments
+`)
+
+	c, err := Parse(yaml, time.Now())
+
+	if err == nil {
+		t.Fatalf("expected error got config %v", c)
+	}
+
+	for _, expected := range []string{"invalid url", "at least one method is required"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
+
+func TestParseRejectsInvalidPluginInput(t *testing.T) {
+	plugin.RegisterPlugin("rate_limiting", plugin.RateLimitPlugin)
+	plugin.RegisterPlugin("add_header", plugin.AddHeaderPlugin)
+	plugin.RegisterPlugin("request_size_limiting", plugin.RequestSizeLimitPlugin)
+
+	yaml := []byte(
+		`
+services:
+- name: payments
+  url: http://localhost:8081
+  plugins:
+  - name: rate_limiting
+    input:
+      minute: "sixty"
+      policy: sliding_window
+      limt_by: ip
+  - name: add_header
+    input:
+      X-Retries: 3
+  - name: request_size_limiting
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	c, err := Parse(yaml, time.Now())
+
+	if err == nil {
+		t.Fatalf("expected error got config %v", c)
+	}
+
+	for _, expected := range []string{
+		`plugin "rate_limiting": invalid input: input.minute must be an int, got string "sixty"`,
+		`plugin "rate_limiting": invalid input: input.policy must be one of fixed_window, token_bucket, got "sliding_window"`,
+		`plugin "rate_limiting": invalid input: input.limt_by is not a known field`,
+		`plugin "add_header": invalid input: input.X-Retries must be a string, got int 3`,
+		`plugin "request_size_limiting": invalid input: input.allowed_payload_size is required`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
+
+func TestParseDecodesPluginSettingsWithDefaults(t *testing.T) {
+	plugin.RegisterPlugin("rate_limiting", plugin.RateLimitPlugin)
+
+	yaml := []byte(
+		`
+services:
+- name: payments
+  url: http://localhost:8081
+  plugins:
+  - name: rate_limiting
+    input:
+      minute: 60
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	c, err := Parse(yaml, time.Now())
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	settings, ok := c.Services[0].Plugins[0].Settings.(*plugin.RateLimitConfig)
+	if !ok {
+		t.Fatalf("expected settings to be decoded got %T", c.Services[0].Plugins[0].Settings)
+	}
+
+	if settings.Minute != 60 || settings.Policy != plugin.PolicyFixedWindow || settings.Store != "memory" || settings.LimitBy != "ip" {
+		t.Errorf("expected settings with defaults got %+v", settings)
+	}
+}
+
+func TestParseFragmentsMergesInOrder(t *testing.T) {
+	plugin.RegisterPlugin("add_header", plugin.AddHeaderPlugin)
+	certFile, keyFile := writeCertificate(t)
+
+	fragments := []fragment{
+		{name: "10-payments.yaml", data: []byte(
+			`
+plugins:
+- name: add_header
+  input:
+    X-Gateway: kong
+services:
+- name: payments
+  url: http://localhost:8081
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+tls:
+  redirect_http: true
+  https_port: 443
+`)},
+		{name: "20-shippings.json", data: []byte(
+			`{
+	"services": [
+		{"name": "shippings", "url": "http://localhost:8082", "routes": [{"paths": ["/shippings"], "methods": ["GET"]}]}
+	],
+	"tls": {"https_port": 8443, "certificates": [{"cert_file": "` + certFile + `", "key_file": "` + keyFile + `"}]}
+}`)},
+	}
+
+	c, err := parseFragments(fragments, time.Now())
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if len(c.Services) != 2 || c.Services[0].Name != "payments" || c.Services[1].Name != "shippings" {
+		t.Errorf("expected services payments and shippings in order got %v", c.Services)
+	}
+
+	if len(c.Plugins) != 1 {
+		t.Errorf("expected 1 global plugin got %v", c.Plugins)
+	}
+
+	if !c.TLS.RedirectHTTP || c.TLS.HTTPSPort != 8443 || len(c.TLS.Certificates) != 1 {
+		t.Errorf("expected tls from both fragments with the last port got %+v", c.TLS)
+	}
+}
+
+func TestParseFragmentsRejectsDuplicatedService(t *testing.T) {
+	service := []byte(
+		`
+services:
+- name: payments
+  url: http://localhost:8081
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	_, err := parseFragments([]fragment{{name: "a.yaml", data: service}, {name: "b.yaml", data: service}}, time.Now())
+
+	if err == nil || !strings.Contains(err.Error(), `service "payments": duplicated name`) {
+		t.Errorf("expected duplicated service error got %v", err)
+	}
+}
+
+func TestParseFragmentsExpandsEnv(t *testing.T) {
+	t.Setenv("PAYMENTS_URL", "http://payments:8081")
+	t.Setenv("PAYMENTS_RETRIES", "2")
+
+	data := []byte(
+		`
+services:
+- name: payments
+  url: ${PAYMENTS_URL}
+  retries:
+    attempts: ${PAYMENTS_RETRIES}
+  timeouts:
+    read: ${PAYMENTS_READ_TIMEOUT:-1500}
+  routes:
+  - name: $${literal}
+    paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	c, err := parseFragments([]fragment{{name: "config.yaml", data: data}}, time.Now())
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	service := c.Services[0]
+	if service.URL != "http://payments:8081" {
+		t.Errorf("expected url from env got %v", service.URL)
+	}
+
+	if service.Retries.Attempts != 2 || service.Timeouts.Read != 1500 {
+		t.Errorf("expected numbers from env and default got %+v %+v", service.Retries, service.Timeouts)
+	}
+
+	if service.Routes[0].Name != "${literal}" {
+		t.Errorf("expected escaped reference to be kept got %v", service.Routes[0].Name)
+	}
+}
+
+func TestParseFragmentsRejectsMissingEnv(t *testing.T) {
+	data := []byte(
+		`
+services:
+- name: payments
+  url: ${KONG_TEST_MISSING_URL}
+`)
+
+	_, err := parseFragments([]fragment{{name: "config.yaml", data: data}}, time.Now())
+
+	expected := "config.yaml: line 4: environment variable KONG_TEST_MISSING_URL is not set"
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected error to contain %q got %v", expected, err)
+	}
+}
+
+// writeCertificate grava um certificado autoassinado, já que o redirect_http exige
+// certificados para o listener HTTPS.
+func writeCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("could not write certificate: %v", err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
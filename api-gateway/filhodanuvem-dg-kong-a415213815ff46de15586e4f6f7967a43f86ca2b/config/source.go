@@ -0,0 +1,248 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// fragmentExtensions são os arquivos lidos quando a configuração é um diretório.
+// JSON também é YAML válido, então os dois formatos passam pelo mesmo decoder.
+var fragmentExtensions = []string{".yaml", ".yml", ".json"}
+
+// envPattern encontra ${NOME} e ${NOME:-padrão}. $${NOME} escapa a referência e
+// vira o texto ${NOME}.
+var envPattern = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+type fragment struct {
+	name string
+	data []byte
+}
+
+// Load lê a configuração de path, que pode ser um arquivo YAML ou JSON ou um
+// diretório de fragmentos, e só devolve ela se for válida. É o que o validate usa.
+func Load(path string) (*kong.Config, error) {
+	fragments, modTime, err := readFragments(path, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseFragments(fragments, modTime)
+}
+
+// readFragments lê path. Num diretório os arquivos com uma das fragmentExtensions
+// são lidos em ordem alfabética e o modTime é o mais recente entre eles e o do
+// próprio diretório, que muda quando um arquivo é apagado. Com create, um arquivo
+// que não existe é criado vazio.
+func readFragments(path string, create bool) ([]fragment, time.Time, error) {
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		return readDir(path, info.ModTime())
+	}
+
+	var fp *os.File
+	if create {
+		fp, err = openFile(path)
+	} else {
+		fp, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer fp.Close()
+
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if info, err = fp.Stat(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return []fragment{{name: filepath.Base(path), data: data}}, info.ModTime(), nil
+}
+
+func readDir(dir string, modTime time.Time) ([]fragment, time.Time, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var fragments []fragment
+	for _, entry := range entries {
+		if entry.IsDir() || !isFragment(entry.Name()) {
+			continue
+		}
+
+		fileName := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+
+		fragments = append(fragments, fragment{name: entry.Name(), data: data})
+	}
+
+	sort.Slice(fragments, func(i, j int) bool {
+		return fragments[i].name < fragments[j].name
+	})
+
+	return fragments, modTime, nil
+}
+
+// isFragment ignora arquivos ocultos, que costumam ser temporários de editores.
+func isFragment(name string) bool {
+	return !strings.HasPrefix(name, ".") && slices.Contains(fragmentExtensions, strings.ToLower(filepath.Ext(name)))
+}
+
+// parseFragments substitui as variáveis de ambiente e junta os fragmentos na ordem
+// em que vieram: mapas são unidos, listas são concatenadas e valores simples do
+// último fragmento vencem. Serviços ou consumers repetidos em fragmentos diferentes
+// caem na validação de nomes duplicados.
+func parseFragments(fragments []fragment, modTime time.Time) (*kong.Config, error) {
+	var merged *yaml.Node
+	var errs []error
+
+	for _, f := range fragments {
+		var document yaml.Node
+		if err := yaml.Unmarshal(f.data, &document); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+			continue
+		}
+
+		// arquivo vazio ou só com comentários
+		if len(document.Content) == 0 {
+			continue
+		}
+
+		root := document.Content[0]
+		if root.Kind != yaml.MappingNode {
+			errs = append(errs, fmt.Errorf("%s: expected a mapping at the top level", f.name))
+			continue
+		}
+
+		for _, err := range expandEnv(root) {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+		}
+
+		if merged == nil {
+			merged = root
+		} else {
+			mergeNodes(merged, root)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+
+	var data []byte
+	if merged != nil {
+		var err error
+		if data, err = yaml.Marshal(merged); err != nil {
+			return nil, err
+		}
+	}
+
+	return Parse(data, modTime)
+}
+
+// expandEnv troca as referências a variáveis de ambiente nos valores do documento.
+// Uma variável que não existe e não tem padrão é um erro, para um secret esquecido
+// não virar uma string vazia.
+func expandEnv(node *yaml.Node) []error {
+	var errs []error
+
+	if node.Kind == yaml.ScalarNode {
+		expanded, missing := expandString(node.Value)
+		for _, name := range missing {
+			errs = append(errs, fmt.Errorf("line %d: environment variable %s is not set", node.Line, name))
+		}
+
+		if expanded != node.Value {
+			node.Value = expanded
+			// sem aspas o tipo é resolvido de novo, então ${PORT} pode virar um número
+			if node.Style == 0 {
+				node.Tag = ""
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		errs = append(errs, expandEnv(child)...)
+	}
+
+	return errs
+}
+
+// expandString troca as referências de value e devolve as variáveis que faltam.
+func expandString(value string) (string, []string) {
+	var missing []string
+
+	expanded := envPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := envPattern.FindStringSubmatch(ref)
+		if match[1] != "" {
+			return ref[1:]
+		}
+
+		if value, ok := os.LookupEnv(match[2]); ok && (value != "" || !strings.Contains(ref, ":-")) {
+			return value
+		}
+
+		if strings.Contains(ref, ":-") {
+			return match[3]
+		}
+
+		missing = append(missing, match[2])
+		return ""
+	})
+
+	return expanded, missing
+}
+
+func mergeNodes(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		existing := mappingValue(dst, key.Value)
+		switch {
+		case existing == nil:
+			dst.Content = append(dst.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeNodes(existing, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			*existing = *value
+		}
+	}
+}
+
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	return nil
+}
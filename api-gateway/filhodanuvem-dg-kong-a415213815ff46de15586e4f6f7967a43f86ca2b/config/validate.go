@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
+	"github.com/devgymbr/kong/routes"
+)
+
+// Validate confere a configuração inteira e devolve todos os problemas encontrados
+// de uma vez, para quem edita não precisar corrigir um erro por vez. O input dos
+// plugins válidos já fica convertido em Settings.
+func Validate(c *kong.Config) error {
+	var errs []error
+
+	for i := range c.Plugins {
+		for _, err := range ValidatePlugin(&c.Plugins[i]) {
+			errs = append(errs, fmt.Errorf("global %w", err))
+		}
+	}
+
+	serviceNames := map[string]bool{}
+	for i, service := range c.Services {
+		if service.Name == "" {
+			errs = append(errs, fmt.Errorf("services[%d]: name is required", i))
+		} else if serviceNames[service.Name] {
+			errs = append(errs, fmt.Errorf("service %q: duplicated name", service.Name))
+		}
+		serviceNames[service.Name] = true
+
+		errs = append(errs, ValidateService(service)...)
+
+		plugins := slices.Clone(service.Plugins)
+		for _, route := range service.Routes {
+			plugins = append(plugins, route.Plugins...)
+		}
+
+		for _, route := range service.Routes {
+			for _, destination := range route.Split.Destinations {
+				if destination.Service != "" && !slices.ContainsFunc(c.Services, func(s kong.Service) bool { return s.Name == destination.Service }) {
+					errs = append(errs, fmt.Errorf("service %q: route %q: split: service %q not found", service.Name, route.Name, destination.Service))
+				}
+			}
+		}
+
+		for _, p := range plugins {
+			for _, name := range p.Consumers {
+				if _, ok := c.FindConsumer(name); !ok {
+					errs = append(errs, fmt.Errorf("service %q: plugin %q: consumer %q not found", service.Name, p.Name, name))
+				}
+			}
+		}
+	}
+
+	errs = append(errs, ValidateConsumers(c.Consumers)...)
+
+	// sem certificado não há listener HTTPS para onde redirecionar
+	if c.TLS.RedirectHTTP && len(c.TLS.Certificates) == 0 {
+		errs = append(errs, errors.New("tls: redirect_http requires certificates for the HTTPS listener"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateConsumers confere os nomes e garante que uma credencial não identifica
+// mais de um consumer.
+func ValidateConsumers(consumers []kong.Consumer) []error {
+	var errs []error
+
+	names := map[string]bool{}
+	keys := map[string]string{}
+	usernames := map[string]string{}
+	for i, consumer := range consumers {
+		if consumer.Name == "" {
+			errs = append(errs, fmt.Errorf("consumers[%d]: name is required", i))
+		} else if names[consumer.Name] {
+			errs = append(errs, fmt.Errorf("consumer %q: duplicated name", consumer.Name))
+		}
+		names[consumer.Name] = true
+
+		for _, key := range consumer.Credentials.KeyAuth {
+			if key == "" {
+				errs = append(errs, fmt.Errorf("consumer %q: key_auth key can not be empty", consumer.Name))
+			} else if owner, ok := keys[key]; ok {
+				errs = append(errs, fmt.Errorf("consumer %q: key_auth key already used by consumer %q", consumer.Name, owner))
+			}
+			keys[key] = consumer.Name
+		}
+
+		for _, credential := range consumer.Credentials.BasicAuth {
+			if credential.Username == "" || credential.Password == "" {
+				errs = append(errs, fmt.Errorf("consumer %q: basic_auth username and password are required", consumer.Name))
+			} else if owner, ok := usernames[credential.Username]; ok {
+				errs = append(errs, fmt.Errorf("consumer %q: basic_auth username %q already used by consumer %q", consumer.Name, credential.Username, owner))
+			}
+			usernames[credential.Username] = consumer.Name
+		}
+	}
+
+	return errs
+}
+
+func ValidateService(service kong.Service) []error {
+	var errs []error
+
+	if u, err := url.Parse(service.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("service %q: invalid url %q", service.Name, service.URL))
+	}
+
+	for _, err := range validateUpstream(service) {
+		errs = append(errs, fmt.Errorf("service %q: %w", service.Name, err))
+	}
+
+	for i := range service.Plugins {
+		for _, err := range ValidatePlugin(&service.Plugins[i]) {
+			errs = append(errs, fmt.Errorf("service %q: %w", service.Name, err))
+		}
+	}
+
+	routeNames := map[string]bool{}
+	for _, route := range service.Routes {
+		if route.Name != "" && routeNames[route.Name] {
+			errs = append(errs, fmt.Errorf("service %q: route %q: duplicated name", service.Name, route.Name))
+		}
+		routeNames[route.Name] = true
+
+		for _, err := range ValidateRoute(route) {
+			errs = append(errs, fmt.Errorf("service %q: %w", service.Name, err))
+		}
+	}
+
+	return errs
+}
+
+func validateUpstream(service kong.Service) []error {
+	var errs []error
+
+	options := []struct {
+		name  string
+		value int
+	}{
+		{"timeouts.connect", service.Timeouts.Connect},
+		{"timeouts.read", service.Timeouts.Read},
+		{"timeouts.write", service.Timeouts.Write},
+		{"retries.attempts", service.Retries.Attempts},
+		{"retries.backoff", service.Retries.Backoff},
+		{"retries.max_backoff", service.Retries.MaxBackoff},
+		{"connection_pool.max_idle_conns", service.ConnectionPool.MaxIdleConns},
+		{"connection_pool.max_idle_conns_per_host", service.ConnectionPool.MaxIdleConnsPerHost},
+		{"connection_pool.max_conns_per_host", service.ConnectionPool.MaxConnsPerHost},
+		{"connection_pool.idle_conn_timeout", service.ConnectionPool.IdleConnTimeout},
+	}
+	for _, option := range options {
+		if option.value < 0 {
+			errs = append(errs, fmt.Errorf("%s can not be negative, got %d", option.name, option.value))
+		}
+	}
+
+	for _, status := range service.Retries.StatusCodes {
+		if status < 100 || status > 599 {
+			errs = append(errs, fmt.Errorf("retries.status_codes: invalid status code %d", status))
+		}
+	}
+
+	if len(service.Retries.StatusCodes) > 0 && service.Retries.Attempts == 0 {
+		errs = append(errs, errors.New("retries.status_codes requires retries.attempts"))
+	}
+
+	if _, err := service.TLS.Config(); err != nil {
+		errs = append(errs, fmt.Errorf("tls: %w", err))
+	}
+
+	if service.Protocol != "" && service.Protocol != kong.ProtocolHTTP1 && service.Protocol != kong.ProtocolHTTP2 {
+		errs = append(errs, fmt.Errorf("invalid protocol %q, expected %s or %s", service.Protocol, kong.ProtocolHTTP1, kong.ProtocolHTTP2))
+	}
+
+	return errs
+}
+
+// ValidatePlugin confere o input do plugin com o schema dele e guarda em Settings
+// a configuração já convertida, para o plugin não precisar fazer isso a cada request.
+func ValidatePlugin(p *kong.Plugin) []error {
+	settings, err := plugin.Decode(*p)
+	if err == nil {
+		p.Settings = settings
+		return nil
+	}
+
+	// um erro por campo do input, cada um com o nome do plugin
+	causes := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		causes = joined.Unwrap()
+	}
+
+	errs := make([]error, 0, len(causes))
+	for _, cause := range causes {
+		errs = append(errs, fmt.Errorf("plugin %q: %w", p.Name, cause))
+	}
+
+	return errs
+}
+
+func ValidateRoute(route kong.Route) []error {
+	var errs []error
+
+	if len(route.Paths) == 0 {
+		errs = append(errs, fmt.Errorf("route %q: at least one path is required", route.Name))
+	}
+
+	for _, path := range route.Paths {
+		if _, err := routes.Parse(path); err != nil {
+			errs = append(errs, fmt.Errorf("route %q: invalid path %q: %w", route.Name, path, err))
+		}
+	}
+
+	for i := range route.Plugins {
+		for _, err := range ValidatePlugin(&route.Plugins[i]) {
+			errs = append(errs, fmt.Errorf("route %q: %w", route.Name, err))
+		}
+	}
+
+	if len(route.Methods) == 0 {
+		errs = append(errs, fmt.Errorf("route %q: at least one method is required", route.Name))
+	}
+
+	if route.Match != "" && route.Match != kong.MatchExact && route.Match != kong.MatchPrefix {
+		errs = append(errs, fmt.Errorf("route %q: match must be %q or %q, got %q", route.Name, kong.MatchExact, kong.MatchPrefix, route.Match))
+	}
+
+	for _, err := range validateSplit(route.Split) {
+		errs = append(errs, fmt.Errorf("route %q: split: %w", route.Name, err))
+	}
+
+	// todo parâmetro usado no rewrite_path precisa existir em todos os paths da rota
+	for _, name := range routes.ParamNames(route.RewritePath) {
+		for _, path := range route.Paths {
+			if !slices.Contains(routes.ParamNames(path), name) {
+				errs = append(errs, fmt.Errorf("route %q: rewrite_path uses param %q that is not in path %q", route.Name, name, path))
+			}
+		}
+	}
+
+	return errs
+}
+
+func validateSplit(split kong.TrafficSplit) []error {
+	var errs []error
+
+	total := 0
+	for i, destination := range split.Destinations {
+		if destination.Service == "" {
+			errs = append(errs, fmt.Errorf("destinations[%d]: service is required", i))
+		}
+
+		switch {
+		case destination.Weight < 0 || destination.Weight > 100:
+			errs = append(errs, fmt.Errorf("destinations[%d]: weight must be between 0 and 100, got %d", i, destination.Weight))
+		case destination.Matched() && destination.Weight > 0:
+			errs = append(errs, fmt.Errorf("destinations[%d]: weight can not be used with headers or cookies", i))
+		}
+		total += destination.Weight
+	}
+
+	if total > 100 {
+		errs = append(errs, fmt.Errorf("the sum of the weights can not be greater than 100, got %d", total))
+	}
+
+	if split.StickyCookie != "" {
+		if err := (&http.Cookie{Name: split.StickyCookie, Value: "x"}).Valid(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid sticky_cookie %q", split.StickyCookie))
+		}
+	}
+
+	return errs
+}
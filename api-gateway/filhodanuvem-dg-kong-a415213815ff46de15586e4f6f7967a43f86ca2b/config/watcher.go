@@ -0,0 +1,23 @@
+package config
+
+// fileWatcher avisa em events quando o arquivo observado pode ter mudado.
+// Cada sistema operacional tem a sua implementação de newFileWatcher.
+type fileWatcher struct {
+	events chan struct{}
+	close  func() error
+}
+
+func (w *fileWatcher) notify() {
+	select {
+	case w.events <- struct{}{}:
+	default:
+		// já existe um aviso pendente, ele cobre esse também
+	}
+}
+
+func (w *fileWatcher) drain() {
+	select {
+	case <-w.events:
+	default:
+	}
+}
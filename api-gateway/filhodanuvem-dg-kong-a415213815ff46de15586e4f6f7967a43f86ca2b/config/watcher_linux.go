@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// newFileWatcher usa o inotify. O diretório é observado e não o arquivo, porque
+// editores (e o WriteFile) salvam num arquivo novo e renomeiam por cima do antigo.
+// Quando fileName já é um diretório de fragmentos, qualquer fragmento criado,
+// alterado ou apagado nele avisa.
+func newFileWatcher(fileName string) (*fileWatcher, error) {
+	dir, base := filepath.Dir(fileName), filepath.Base(fileName)
+	mask := uint32(syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_MOVED_TO)
+	watches := func(name string) bool { return name == base }
+
+	if info, err := os.Stat(fileName); err == nil && info.IsDir() {
+		dir = fileName
+		mask |= syscall.IN_DELETE | syscall.IN_MOVED_FROM
+		watches = isFragment
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	if _, err = syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	// com o fd não bloqueante o os.File usa o poller do runtime,
+	// então o Close consegue interromper o Read que está esperando
+	file := os.NewFile(uintptr(fd), "inotify")
+	w := &fileWatcher{events: make(chan struct{}, 1), close: file.Close}
+
+	go func() {
+		defer close(w.events)
+
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				// struct inotify_event { int wd; uint32 mask; uint32 cookie; uint32 len; char name[]; }
+				nameLen := int(binary.NativeEndian.Uint32(buf[offset+12 : offset+16]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+nameLen]), "\x00")
+				offset = nameStart + nameLen
+
+				if watches(name) {
+					w.notify()
+				}
+			}
+		}
+	}()
+
+	return w, nil
+}
@@ -0,0 +1,83 @@
+//go:build !linux
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// newFileWatcher compara o stat do arquivo periodicamente onde não temos inotify.
+// Num diretório de fragmentos a comparação é feita com o stat de cada um.
+func newFileWatcher(fileName string) (*fileWatcher, error) {
+	done := make(chan struct{})
+	w := &fileWatcher{
+		events: make(chan struct{}, 1),
+		close: func() error {
+			close(done)
+			return nil
+		},
+	}
+
+	last := snapshot(fileName)
+
+	go func() {
+		defer close(w.events)
+
+		t := time.NewTicker(pollInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				current := snapshot(fileName)
+				if current == "" {
+					continue
+				}
+
+				if current != last {
+					w.notify()
+				}
+				last = current
+			}
+		}
+	}()
+
+	return w, nil
+}
+
+// snapshot resume nome, data de modificação e tamanho do arquivo, ou dos fragmentos
+// do diretório. Vazio quando não foi possível ler.
+func snapshot(fileName string) string {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return ""
+	}
+
+	if !info.IsDir() {
+		return fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+	}
+
+	entries, err := os.ReadDir(fileName)
+	if err != nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || !isFragment(entry.Name()) {
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", entry.Name(), info.ModTime().UnixNano(), info.Size())
+	}
+
+	// um diretório sem fragmentos ainda é diferente de um que não pôde ser lido
+	return "dir\n" + b.String()
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/devgymbr/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// WriteFile grava a configuração em fileName. O arquivo é escrito ao lado e depois
+// renomeado, então o Loader nunca lê um arquivo pela metade.
+// Comentários do arquivo original não são preservados. Os campos que vieram de uma
+// variável ${NOME} e não mudaram continuam com a referência, assim os segredos do
+// ambiente não são gravados no arquivo. Um arquivo .json é escrito em JSON.
+func WriteFile(fileName string, c *kong.Config) error {
+	asJSON := strings.EqualFold(filepath.Ext(fileName), ".json")
+
+	var node yaml.Node
+	if asJSON {
+		// passa pelo JSON para o arquivo ter os mesmos campos de antes
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		var doc yaml.Node
+		if err = yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		node = *doc.Content[0]
+	} else if err := node.Encode(c); err != nil {
+		return err
+	}
+
+	mode := fs.FileMode(0644)
+	original, err := os.ReadFile(fileName)
+	switch {
+	case err == nil:
+		if err = keepEnvReferences(original, &node); err != nil {
+			return fmt.Errorf("could not read the env references of %s: %w", fileName, err)
+		}
+
+		if info, err := os.Stat(fileName); err == nil {
+			mode = info.Mode().Perm()
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	var data []byte
+	if asJSON {
+		var compact, indented bytes.Buffer
+		writeJSONNode(&compact, &node)
+		if err = json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+			return err
+		}
+		data = indented.Bytes()
+	} else if data, err = yaml.Marshal(&node); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fileName)
+}
+
+// keepEnvReferences volta para a referência do arquivo original os valores de node
+// que continuam iguais ao que a referência resolve hoje. Um valor alterado pela
+// Admin API é gravado como está.
+func keepEnvReferences(original []byte, node *yaml.Node) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return err
+	}
+
+	references := map[string]*yaml.Node{}
+	walkScalars(&doc, "", func(path string, n *yaml.Node) {
+		if envPattern.MatchString(n.Value) {
+			references[path] = n
+		}
+	})
+
+	walkScalars(node, "", func(path string, n *yaml.Node) {
+		reference, ok := references[path]
+		if !ok {
+			return
+		}
+
+		if expanded, missing := expandString(reference.Value); len(missing) == 0 && expanded == n.Value {
+			n.Value, n.Tag, n.Style = reference.Value, reference.Tag, reference.Style
+		}
+	})
+
+	return nil
+}
+
+// walkScalars chama fn com o caminho de cada valor, como services[0].url.
+func walkScalars(node *yaml.Node, path string, fn func(path string, n *yaml.Node)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkScalars(child, path, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkScalars(node.Content[i+1], path+"."+node.Content[i].Value, fn)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			walkScalars(child, fmt.Sprintf("%s[%d]", path, i), fn)
+		}
+	case yaml.ScalarNode:
+		fn(path, node)
+	}
+}
+
+// writeJSONNode escreve o node em JSON compacto, na ordem dos campos do node.
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(node.Content[i].Value)
+			buf.Write(key)
+			buf.WriteByte(':')
+			writeJSONNode(buf, node.Content[i+1])
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, child := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSONNode(buf, child)
+		}
+		buf.WriteByte(']')
+	default:
+		switch node.ShortTag() {
+		case "!!int", "!!float", "!!bool":
+			buf.WriteString(node.Value)
+		case "!!null":
+			buf.WriteString("null")
+		default:
+			value, _ := json.Marshal(node.Value)
+			buf.Write(value)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package kong
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// Consumer é quem consome a API. Os plugins de autenticação (key_auth e basic_auth)
+// descobrem o consumer pelas credenciais e os outros plugins podem ser limitados a ele.
+type Consumer struct {
+	Name        string      `yaml:"name" json:"name"`
+	CustomID    string      `yaml:"custom_id,omitempty" json:"custom_id,omitempty"`
+	Groups      []string    `yaml:"groups,omitempty" json:"groups,omitempty"`
+	Credentials Credentials `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+}
+
+type Credentials struct {
+	KeyAuth   []string              `yaml:"key_auth,omitempty" json:"key_auth,omitempty"`
+	BasicAuth []BasicAuthCredential `yaml:"basic_auth,omitempty" json:"basic_auth,omitempty"`
+}
+
+type BasicAuthCredential struct {
+	Username string `yaml:"username" json:"username"`
+	// Password pode ser o texto puro ou o hash no formato sha256:<hex>
+	Password string `yaml:"password" json:"password"`
+}
+
+func (c Consumer) Clone() Consumer {
+	clone := c
+	clone.Groups = slices.Clone(c.Groups)
+	clone.Credentials.KeyAuth = slices.Clone(c.Credentials.KeyAuth)
+	clone.Credentials.BasicAuth = slices.Clone(c.Credentials.BasicAuth)
+
+	return clone
+}
+
+// InGroup diz se o consumer faz parte de algum dos groups.
+func (c *Consumer) InGroup(groups ...string) bool {
+	for _, group := range groups {
+		if slices.Contains(c.Groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PasswordMatches compara a senha em tempo constante.
+func (b BasicAuthCredential) PasswordMatches(password string) bool {
+	if hash, ok := strings.CutPrefix(b.Password, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(hash)), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+
+	return subtle.ConstantTimeCompare([]byte(b.Password), []byte(password)) == 1
+}
+
+type consumerCredential struct {
+	consumer int
+	basic    int
+}
+
+type consumerIndex struct {
+	keys  map[string]int
+	users map[string]consumerCredential
+}
+
+func newConsumerIndex(consumers []Consumer) *consumerIndex {
+	index := &consumerIndex{keys: map[string]int{}, users: map[string]consumerCredential{}}
+	for i, consumer := range consumers {
+		for _, key := range consumer.Credentials.KeyAuth {
+			index.keys[key] = i
+		}
+
+		for j, credential := range consumer.Credentials.BasicAuth {
+			index.users[credential.Username] = consumerCredential{consumer: i, basic: j}
+		}
+	}
+
+	return index
+}
+
+func (c *Config) FindConsumer(name string) (*Consumer, bool) {
+	for i := range c.Consumers {
+		if c.Consumers[i].Name == name {
+			return &c.Consumers[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// FindConsumerByKey procura o consumer dono da chave do key_auth.
+func (c *Config) FindConsumerByKey(key string) (*Consumer, bool) {
+	index := c.consumers
+	if index == nil {
+		index = newConsumerIndex(c.Consumers)
+	}
+
+	i, ok := index.keys[key]
+	if !ok {
+		return nil, false
+	}
+
+	return &c.Consumers[i], true
+}
+
+// FindConsumerByBasicAuth procura o consumer com esse usuário e senha do basic_auth.
+func (c *Config) FindConsumerByBasicAuth(username, password string) (*Consumer, bool) {
+	index := c.consumers
+	if index == nil {
+		index = newConsumerIndex(c.Consumers)
+	}
+
+	credential, ok := index.users[username]
+	if !ok {
+		return nil, false
+	}
+
+	consumer := &c.Consumers[credential.consumer]
+	if !consumer.Credentials.BasicAuth[credential.basic].PasswordMatches(password) {
+		return nil, false
+	}
+
+	return consumer, true
+}
@@ -0,0 +1,90 @@
+package kong
+
+import (
+	"context"
+	"net/http"
+)
+
+type contextKey int
+
+const (
+	routeMatchKey contextKey = iota
+	configKey
+	consumerKey
+	claimsKey
+)
+
+// WithRouteMatch guarda no contexto a rota que o Server encontrou para a request,
+// assim os plugins sabem qual serviço, rota e parâmetros estão atendendo.
+func WithRouteMatch(ctx context.Context, match *RouteMatch) context.Context {
+	return context.WithValue(ctx, routeMatchKey, match)
+}
+
+func RouteMatchFromContext(ctx context.Context) (*RouteMatch, bool) {
+	match, ok := ctx.Value(routeMatchKey).(*RouteMatch)
+	return match, ok && match != nil
+}
+
+func ServiceFromContext(ctx context.Context) (*Service, bool) {
+	match, ok := RouteMatchFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	return match.Service, true
+}
+
+func RouteFromContext(ctx context.Context) (*Route, bool) {
+	match, ok := RouteMatchFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+
+	return match.Route, true
+}
+
+// PathParams devolve os parâmetros capturados do path, como o id de /payments/{id}.
+func PathParams(ctx context.Context) map[string]string {
+	match, ok := RouteMatchFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return match.Params
+}
+
+// PathParam é um atalho para um parâmetro só da request.
+func PathParam(r *http.Request, name string) string {
+	return PathParams(r.Context())[name]
+}
+
+// WithConfig guarda no contexto a configuração usada para atender a request, que
+// continua a mesma até o fim dela mesmo se um reload acontecer no meio.
+func WithConfig(ctx context.Context, config *Config) context.Context {
+	return context.WithValue(ctx, configKey, config)
+}
+
+func ConfigFromContext(ctx context.Context) (*Config, bool) {
+	config, ok := ctx.Value(configKey).(*Config)
+	return config, ok && config != nil
+}
+
+// WithConsumer guarda o consumer identificado por um plugin de autenticação.
+func WithConsumer(ctx context.Context, consumer *Consumer) context.Context {
+	return context.WithValue(ctx, consumerKey, consumer)
+}
+
+func ConsumerFromContext(ctx context.Context) (*Consumer, bool) {
+	consumer, ok := ctx.Value(consumerKey).(*Consumer)
+	return consumer, ok && consumer != nil
+}
+
+// WithClaims guarda as claims do token que o jwt_auth validou.
+func WithClaims(ctx context.Context, claims map[string]any) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+func ClaimsFromContext(ctx context.Context) (map[string]any, bool) {
+	claims, ok := ctx.Value(claimsKey).(map[string]any)
+	return claims, ok && claims != nil
+}
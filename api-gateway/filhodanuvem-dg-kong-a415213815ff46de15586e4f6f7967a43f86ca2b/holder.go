@@ -0,0 +1,47 @@
+package kong
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ConfigHolder guarda a configuração ativa do gateway. Quem lê sempre recebe uma
+// configuração completa: mudanças são feitas numa cópia e trocadas de uma vez com Store.
+type ConfigHolder struct {
+	current atomic.Pointer[configVersion]
+	version atomic.Uint64
+}
+
+// ConfigVersion identifica a configuração ativa. Version aumenta a cada Store,
+// seja pelo loader ou pela Admin API.
+type ConfigVersion struct {
+	Version  uint64
+	LoadedAt time.Time
+}
+
+type configVersion struct {
+	config *Config
+	ConfigVersion
+}
+
+func NewConfigHolder(config *Config) *ConfigHolder {
+	h := &ConfigHolder{}
+	h.Store(config)
+
+	return h
+}
+
+func (h *ConfigHolder) Load() *Config {
+	return h.current.Load().config
+}
+
+func (h *ConfigHolder) Version() ConfigVersion {
+	return h.current.Load().ConfigVersion
+}
+
+func (h *ConfigHolder) Store(config *Config) {
+	h.current.Store(&configVersion{
+		config:        config,
+		ConfigVersion: ConfigVersion{Version: h.version.Add(1), LoadedAt: time.Now()},
+	})
+}
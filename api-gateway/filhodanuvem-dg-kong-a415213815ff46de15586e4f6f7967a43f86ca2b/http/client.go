@@ -1,48 +1,341 @@
 package http
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/devgymbr/kong"
 )
 
+// ErrResponseAborted indica que a resposta do upstream já começou a ser enviada
+// para o cliente quando o erro aconteceu, então não dá mais para trocar o status.
+var ErrResponseAborted = errors.New("response aborted")
+
+// headers hop-by-hop valem só para uma conexão e não devem ser repassados
+// leia: https://www.rfc-editor.org/rfc/rfc9110#section-7.6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// ForwardClient envia as requests para os upstreams. Cada combinação de timeouts e
+// pool de conexões dos serviços ganha o seu próprio transport.
 type ForwardClient struct {
-	Client *http.Client
-	Config *kong.Config
+	transports transports
+	health     health
 }
 
-func (c *ForwardClient) ForwardRequest(urlBase string, w http.ResponseWriter, r *http.Request) error {
-	forwardURL := urlBase + r.URL.Path
+func NewForwardClient() *ForwardClient {
+	return &ForwardClient{}
+}
+
+// ReleaseUnused fecha os pools de conexões que os serviços de config não usam mais.
+func (c *ForwardClient) ReleaseUnused(config *kong.Config) {
+	c.transports.release(config)
+}
 
-	var err error
-	r.URL, err = url.Parse(forwardURL)
+func (c *ForwardClient) ForwardRequest(service *kong.Service, w http.ResponseWriter, r *http.Request) error {
+	forwardURL, err := url.Parse(service.URL + r.URL.Path)
 	if err != nil {
 		return err
 	}
+	forwardURL.RawQuery = r.URL.RawQuery
 
 	// vamos trocar o destino da request
 	// e pra isso precisamos limpar o requestURI.
 	// Leia https://stackoverflow.com/questions/19595860/http-request-requesturi-field-when-making-request-in-go
-	r.RequestURI = ""
-	resp, err := c.Client.Do(r)
+	outReq := r.Clone(r.Context())
+	outReq.URL = forwardURL
+	outReq.Host = ""
+	outReq.RequestURI = ""
+	if r.ContentLength == 0 {
+		outReq.Body = nil
+	}
+
+	upgrade := upgradeType(r.Header)
+	acceptsTrailers := acceptsTrailers(r.Header)
+	removeHopByHopHeaders(outReq.Header)
+	if upgrade != "" {
+		outReq.Header.Set("Connection", "Upgrade")
+		outReq.Header.Set("Upgrade", upgrade)
+	}
+	// o gRPC exige "TE: trailers" na request; o gateway repassa os trailers, então
+	// pode aceitar em nome do cliente
+	if acceptsTrailers {
+		outReq.Header.Set("Te", "trailers")
+	}
+	// os trailers da request só são conhecidos depois do body, e o Clone copiou o map
+	// ainda vazio
+	outReq.Trailer = r.Trailer
+	setForwardedHeaders(outReq, r)
+
+	transport, err := c.transports.get(service)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	for k := range resp.Header {
-		w.Header().Set(k, resp.Header.Get(k))
+	client := &http.Client{
+		Transport: transport,
+		// o redirect é a resposta do upstream e vai como está para o cliente
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	retries := 0
+	if upgrade == "" && service.Retries.Retryable(outReq) {
+		retries = service.Retries.Attempts
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := c.do(client, outReq, service, retries)
 	if err != nil {
 		return err
 	}
 
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return handleUpgradeResponse(w, resp, upgrade)
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	copyHeader(w.Header(), resp.Header)
+
 	w.WriteHeader(resp.StatusCode)
-	w.Write(body)
+
+	if err = copyBody(w, resp.Body); err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseAborted, err)
+	}
+
+	// os trailers chegam depois do body, como o grpc-status. Com o prefixo, o net/http
+	// envia mesmo os que não foram anunciados no header Trailer
+	for k, values := range resp.Trailer {
+		for _, v := range values {
+			w.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
+
+	return nil
+}
+
+// do envia a request e repete enquanto houver tentativas e o upstream falhar na
+// conexão ou responder um dos status configurados.
+func (c *ForwardClient) do(client *http.Client, r *http.Request, service *kong.Service, retries int) (*http.Response, error) {
+	policy := service.Retries
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if err := sleep(r.Context(), policy.Delay(attempt)); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := client.Do(r)
+		if r.Context().Err() == nil {
+			// o cliente que desistiu não diz nada sobre a saúde do upstream
+			c.health.record(service.Name, statusOf(resp), err)
+		}
+
+		if attempt == retries || r.Context().Err() != nil {
+			return resp, err
+		}
+
+		if err != nil {
+			slog.Warn("retrying request to upstream", slog.Int("attempt", attempt+1), slog.String("error", err.Error()))
+			continue
+		}
+
+		if !slices.Contains(policy.StatusCodes, resp.StatusCode) {
+			return resp, nil
+		}
+
+		slog.Warn("retrying request to upstream", slog.Int("attempt", attempt+1), slog.Int("status", resp.StatusCode))
+		// o body precisa ser lido até o fim para a conexão voltar para o pool
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+	}
+}
+
+// UpstreamHealth devolve a saúde de cada serviço que já recebeu requests, pelo nome.
+func (c *ForwardClient) UpstreamHealth() map[string]kong.UpstreamHealth {
+	return c.health.snapshot()
+}
+
+func statusOf(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+
+	return resp.StatusCode
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// copyBody repassa o body conforme ele chega do upstream, sem guardar tudo em memória.
+// O flush a cada leitura é o que permite server-sent events e downloads grandes.
+func copyBody(w http.ResponseWriter, body io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleUpgradeResponse assume a conexão do cliente e liga ela à conexão do upstream,
+// é assim que WebSockets passam pelo gateway.
+func handleUpgradeResponse(w http.ResponseWriter, resp *http.Response, requestedUpgrade string) error {
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return errors.New("upstream switched protocols without a writable body")
+	}
+	defer backConn.Close()
+
+	if upgrade := upgradeType(resp.Header); !strings.EqualFold(upgrade, requestedUpgrade) {
+		return fmt.Errorf("upstream switched to protocol %q but client requested %q", upgrade, requestedUpgrade)
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("response writer does not support hijacking")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	copyHeader(w.Header(), resp.Header)
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err = resp.Write(brw); err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseAborted, err)
+	}
+
+	if err = brw.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrResponseAborted, err)
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(conn, backConn)
+		errc <- err
+	}()
+	go func() {
+		// o que o cliente já mandou pode estar no buffer do hijack
+		_, err := io.Copy(backConn, brw)
+		errc <- err
+	}()
+
+	// quando um dos lados fecha, os defers fecham o outro
+	<-errc
 
 	return nil
 }
+
+func setForwardedHeaders(outReq, r *http.Request) {
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		outReq.Header.Set("X-Forwarded-For", ip)
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	outReq.Header.Set("X-Forwarded-Proto", proto)
+	outReq.Header.Set("X-Forwarded-Host", r.Host)
+}
+
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "Upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+
+	return ""
+}
+
+func acceptsTrailers(h http.Header) bool {
+	for _, value := range h.Values("Te") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "trailers") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func removeHopByHopHeaders(h http.Header) {
+	// headers listados no Connection também são hop-by-hop
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, values := range src {
+		for _, v := range values {
+			dst.Add(k, v)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+// health acompanha o resultado das requests de cada serviço.
+type health struct {
+	mu       sync.Mutex
+	services map[string]kong.UpstreamHealth
+}
+
+// record guarda o resultado de uma tentativa de request ao upstream.
+func (h *health) record(service string, status int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.services == nil {
+		h.services = map[string]kong.UpstreamHealth{}
+	}
+
+	state := h.services[service]
+	state.LastSeen = time.Now()
+
+	switch {
+	case err != nil:
+		state.ConsecutiveFailures++
+		state.LastError = err.Error()
+	case status >= 500:
+		state.ConsecutiveFailures++
+		state.LastError = fmt.Sprintf("upstream responded %d", status)
+	default:
+		state.ConsecutiveFailures = 0
+		state.LastError = ""
+	}
+	state.Healthy = state.ConsecutiveFailures < kong.UnhealthyAfter
+
+	h.services[service] = state
+}
+
+func (h *health) snapshot() map[string]kong.UpstreamHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snapshot := make(map[string]kong.UpstreamHealth, len(h.services))
+	for name, state := range h.services {
+		snapshot[name] = state
+	}
+
+	return snapshot
+}
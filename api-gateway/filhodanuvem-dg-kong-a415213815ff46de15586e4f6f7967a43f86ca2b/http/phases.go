@@ -0,0 +1,265 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
+)
+
+// activePlugin é um plugin da request junto com a definição registrada dele.
+type activePlugin struct {
+	config     kong.Plugin
+	definition plugin.Definition
+}
+
+// phaseWriter roda as fases header_filter, body_filter e log em volta do
+// ResponseWriter do cliente.
+type phaseWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	plugins     []activePlugin
+	startedAt   time.Time
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+	body        io.Writer
+	filters     []io.WriteCloser
+
+	// quando a request foi enviada para o upstream e quanto ele demorou para responder
+	upstreamAt      time.Time
+	upstreamLatency time.Duration
+	requestBody     *countingReader
+}
+
+func newPhaseWriter(w http.ResponseWriter, r *http.Request, plugins []activePlugin) *phaseWriter {
+	pw := &phaseWriter{ResponseWriter: w, r: r, plugins: plugins, startedAt: time.Now()}
+
+	if r.Body != nil && r.Body != http.NoBody {
+		pw.requestBody = &countingReader{ReadCloser: r.Body}
+		r.Body = pw.requestBody
+	}
+
+	return pw
+}
+
+// needsPhaseWriter diz se algum plugin tem fases depois do access.
+func needsPhaseWriter(plugins []activePlugin) bool {
+	for _, p := range plugins {
+		if p.definition.HeaderFilter != nil || p.definition.BodyFilter != nil || p.definition.Log != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *phaseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if !w.upstreamAt.IsZero() {
+		w.upstreamLatency = time.Since(w.upstreamAt)
+	}
+
+	res := &plugin.ResponseHead{StatusCode: status, Header: w.Header()}
+	for _, p := range w.plugins {
+		if p.definition.HeaderFilter != nil && w.applies(p) {
+			p.definition.HeaderFilter(p.config, w.r, res)
+		}
+	}
+
+	// o body passa pelos filtros na ordem de prioridade antes de chegar no cliente.
+	// Eles são criados nessa mesma ordem, assim cada um vê os headers como os
+	// anteriores deixaram (o compression, por exemplo, só troca o Content-Encoding
+	// depois do response_transformer decidir se lê o body), e o destino de cada
+	// filtro é ligado depois
+	var outs []*chainWriter
+	for _, p := range w.plugins {
+		if p.definition.BodyFilter == nil || !w.applies(p) {
+			continue
+		}
+
+		out := &chainWriter{}
+		filter := p.definition.BodyFilter(p.config, w.r, res, out)
+		if filter == nil {
+			continue
+		}
+		w.filters = append(w.filters, filter)
+		outs = append(outs, out)
+	}
+
+	for i, out := range outs {
+		if i+1 < len(w.filters) {
+			out.Writer = w.filters[i+1]
+		} else {
+			out.Writer = countingWriter{w}
+		}
+	}
+
+	if len(w.filters) > 0 {
+		// o tamanho do body muda depois dos filtros
+		w.Header().Del("Content-Length")
+		w.body = w.filters[0]
+	}
+
+	w.status = res.StatusCode
+	w.ResponseWriter.WriteHeader(res.StatusCode)
+}
+
+func (w *phaseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.body != nil {
+		return w.body.Write(b)
+	}
+
+	return countingWriter{w}.Write(b)
+}
+
+func (w *phaseWriter) Flush() {
+	for _, filter := range w.filters {
+		if flusher, ok := filter.(interface{ Flush() error }); ok {
+			flusher.Flush()
+		}
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *phaseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	w.hijacked = true
+	w.status = http.StatusSwitchingProtocols
+
+	return hijacker.Hijack()
+}
+
+// finish fecha os filtros de body e roda a fase log.
+func (w *phaseWriter) finish() {
+	if !w.hijacked {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+
+		for _, filter := range w.filters {
+			if err := filter.Close(); err != nil {
+				slog.Error("could not finish body filter", slog.String("error", err.Error()))
+			}
+		}
+	}
+
+	entry := plugin.LogEntry{
+		StartedAt:       w.startedAt,
+		Latency:         time.Since(w.startedAt),
+		UpstreamLatency: w.upstreamLatency,
+		Status:          w.status,
+		Bytes:           w.bytes,
+	}
+	entry.ResponseHeader, entry.ResponseTrailer = splitTrailers(w.Header())
+	if w.requestBody != nil {
+		entry.RequestBytes = w.requestBody.n
+	}
+
+	for _, p := range w.plugins {
+		if p.definition.Log != nil && w.applies(p) {
+			p.definition.Log(p.config, w.r, entry)
+		}
+	}
+}
+
+// splitTrailers separa os trailers, que ficam nos headers com o http.TrailerPrefix.
+func splitTrailers(h http.Header) (http.Header, http.Header) {
+	var header, trailer http.Header
+
+	for k, values := range h {
+		name, ok := strings.CutPrefix(k, http.TrailerPrefix)
+		if !ok {
+			continue
+		}
+
+		if trailer == nil {
+			header, trailer = h.Clone(), http.Header{}
+		}
+		delete(header, k)
+		trailer[http.CanonicalHeaderKey(name)] = values
+	}
+
+	if trailer == nil {
+		return h, nil
+	}
+
+	return header, trailer
+}
+
+// track guarda a request que chegou em cada etapa da fase access. Os plugins
+// de autenticação trocam a request por uma com o consumer no contexto, e as
+// outras fases precisam enxergar essa última versão.
+func (w *phaseWriter) track(f http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		w.r = r
+		f(rw, r)
+	}
+}
+
+// upstream marca o momento em que a request sai para o upstream; a latência dele
+// termina quando os headers da resposta chegam no WriteHeader.
+func (w *phaseWriter) upstream(f http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		w.upstreamAt = time.Now()
+		w.track(f)(rw, r)
+	}
+}
+
+// applies confere o escopo do plugin com o consumer que a fase access identificou.
+func (w *phaseWriter) applies(p activePlugin) bool {
+	consumer, _ := kong.ConsumerFromContext(w.r.Context())
+	return p.config.AppliesTo(consumer)
+}
+
+// chainWriter é o destino de um filtro de body, ligado ao próximo filtro depois
+// que todos foram criados.
+type chainWriter struct {
+	io.Writer
+}
+
+type countingWriter struct {
+	w *phaseWriter
+}
+
+func (c countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.ResponseWriter.Write(b)
+	c.w.bytes += int64(n)
+
+	return n, err
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -1,56 +1,227 @@
 package http
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/devgymbr/kong"
 	"github.com/devgymbr/kong/plugin"
 )
 
 type Server struct {
-	Config *kong.Config
-	Client ForwardClient
+	Config *kong.ConfigHolder
+	Client *ForwardClient
+
+	// released é a última configuração em que os recursos dos plugins que ela não
+	// usa foram liberados
+	released atomic.Pointer[kong.Config]
 }
 
 func NewServer(config *kong.Config) *Server {
+	return NewServerFromHolder(kong.NewConfigHolder(config))
+}
+
+// NewServerFromHolder cria um servidor que sempre usa a configuração ativa do holder,
+// assim o loader e a Admin API podem trocar a configuração sem reiniciar o gateway.
+func NewServerFromHolder(holder *kong.ConfigHolder) *Server {
 	return &Server{
-		Config: config,
-		Client: ForwardClient{Client: http.DefaultClient, Config: config},
+		Config: holder,
+		Client: NewForwardClient(),
 	}
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	service, route := s.Config.FindServiceRoute(r)
-	if service == nil || route == nil {
+	config := s.Config.Load()
+	if s.released.Load() != config && s.released.Swap(config) != config {
+		// a troca de configuração pode ter deixado filas de plugins e pools de
+		// conexões sem uso
+		go plugin.ReleaseUnused(config)
+		go s.Client.ReleaseUnused(config)
+	}
+
+	if r.TLS == nil && config.TLS.RedirectHTTP {
+		redirectToHTTPS(w, r, config.TLS.HTTPSPort)
+		return
+	}
+
+	match := config.Match(r)
+	preflight := false
+	if match == nil {
+		match = config.MatchPreflight(r)
+		preflight = match != nil
+	}
+
+	if match == nil {
 		slog.Debug("no service found", slog.String("method", r.Method), slog.String("url", r.URL.Path))
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	// a configuração fica presa na request, então um reload no meio dela não muda o destino
+	service, cookie := config.Upstream(match, r)
+	if cookie != nil {
+		http.SetCookie(w, cookie)
+	}
+	match.Upstream = service
+	r = r.WithContext(kong.WithRouteMatch(kong.WithConfig(r.Context(), config), match))
 
 	f := func(w http.ResponseWriter, r *http.Request) {
-		err := s.Client.ForwardRequest(service.URL, w, r)
+		err := s.Client.ForwardRequest(service, w, upstreamRequest(r, match.UpstreamPath))
+
+		if errors.Is(err, ErrResponseAborted) {
+			slog.Error("response from upstream interrupted", slog.String("error", err.Error()))
+			return
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			slog.Error("upstream timed out", slog.String("service", service.Name), slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
 
 		if err != nil {
-			slog.Error("could not forward request", slog.String("error", err.Error()))
-			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("could not forward request", slog.String("service", service.Name), slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusBadGateway)
 			return
 		}
 	}
 
-	for j := range service.Plugins {
-		p := service.Plugins[j]
-		middleware, err := plugin.FindMiddleware(p.Name)
+	plugins, err := activePlugins(config.PluginsFor(match))
+	if err != nil {
+		slog.Error("unable to find plugin", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// o preflight de uma rota sem OPTIONS só existe se um plugin for responder ele
+	if preflight && !slices.ContainsFunc(plugins, func(p activePlugin) bool { return p.definition.Preflight }) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var pw *phaseWriter
+	if needsPhaseWriter(plugins) {
+		pw = newPhaseWriter(w, r, plugins)
+		w = pw
+		f = pw.upstream(f)
+		defer pw.finish()
+	}
+
+	// a cadeia é montada de dentro para fora, então o plugin de maior prioridade fica por último
+	for j := len(plugins) - 1; j >= 0; j-- {
+		p := plugins[j]
+		if p.definition.Access == nil {
+			continue
+		}
+
+		f = scopedMiddleware(p.config, p.definition.Access(p.config, f), f)
+		if pw != nil {
+			f = pw.track(f)
+		}
+	}
+
+	f(w, r)
+}
+
+// ListenerProtocols são os protocolos do listener sem TLS: além do HTTP/1.1, ele
+// aceita HTTP/2 sem TLS (h2c), que é como os clientes gRPC falam com um gateway
+// sem certificado. No listener HTTPS o HTTP/2 é negociado no handshake.
+func ListenerProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return protocols
+}
+
+// TLSConfig é a configuração do listener HTTPS. O certificado é escolhido pelo SNI
+// na configuração ativa, então certificados novos valem sem reiniciar o listener.
+func (s *Server) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.Config.Load().Certificate(hello)
+		},
+	}
+}
+
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request, port int) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if port != 0 && port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+
+	u := *r.URL
+	u.Scheme = "https"
+	u.Host = host
+
+	// 308 mantém o método e o body, ao contrário do 301
+	http.Redirect(w, r, u.String(), http.StatusPermanentRedirect)
+}
+
+// activePlugins busca a definição de cada plugin e ordena pela prioridade.
+// Com a mesma prioridade vale a ordem de declaração.
+func activePlugins(configs []kong.Plugin) ([]activePlugin, error) {
+	plugins := make([]activePlugin, 0, len(configs))
+	for _, p := range configs {
+		definition, err := plugin.FindPlugin(p.Name)
 		if err != nil {
-			slog.Error("unable to find middleware for plugin",
-				slog.String("plugin", p.Name),
-				slog.String("error", err.Error()),
-			)
-			w.WriteHeader(http.StatusInternalServerError)
+			return nil, fmt.Errorf("plugin %q: %w", p.Name, err)
+		}
+		plugins = append(plugins, activePlugin{config: p, definition: definition})
+	}
+
+	sort.SliceStable(plugins, func(i, j int) bool {
+		return plugins[i].definition.Priority > plugins[j].definition.Priority
+	})
+
+	return plugins, nil
+}
+
+// scopedMiddleware faz o plugin limitado a consumers ou grupos ser pulado quando
+// o consumer da request não é um deles. O consumer só é conhecido depois que o
+// plugin de autenticação rodou, então a decisão é feita durante a request.
+func scopedMiddleware(p kong.Plugin, wrapped, next http.HandlerFunc) http.HandlerFunc {
+	if !p.Scoped() {
+		return wrapped
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		consumer, _ := kong.ConsumerFromContext(r.Context())
+		if p.AppliesTo(consumer) {
+			wrapped(w, r)
 			return
 		}
-		f = middleware(p, f)
+
+		next(w, r)
 	}
+}
 
-	f(w, r)
+// upstreamRequest troca o path pelo que vai para o upstream (strip_path/rewrite_path)
+// sem alterar a request que os plugins receberam.
+func upstreamRequest(r *http.Request, path string) *http.Request {
+	if path == r.URL.Path {
+		return r
+	}
+
+	u := *r.URL
+	u.Path = path
+	u.RawPath = ""
+
+	upstream := r.WithContext(r.Context())
+	upstream.URL = &u
+
+	return upstream
 }
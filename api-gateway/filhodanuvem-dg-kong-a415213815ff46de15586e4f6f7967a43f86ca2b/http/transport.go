@@ -0,0 +1,144 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+// transportKey junta tudo que muda a forma de conectar no upstream. Serviços com
+// as mesmas opções dividem o mesmo pool de conexões. O tls tem os certificados
+// lidos no Compile, então um reload que relê os arquivos ganha um transport novo.
+type transportKey struct {
+	timeouts kong.Timeouts
+	pool     kong.ConnectionPool
+	tls      kong.UpstreamTLS
+	protocol string
+}
+
+type transports struct {
+	mu    sync.Mutex
+	cache map[transportKey]*http.Transport
+}
+
+func newTransportKey(service *kong.Service) transportKey {
+	return transportKey{timeouts: service.Timeouts, pool: service.ConnectionPool, tls: service.TLS, protocol: service.Protocol}
+}
+
+// get devolve o transport das opções do serviço, criando na primeira vez.
+func (t *transports) get(service *kong.Service) (*http.Transport, error) {
+	key := newTransportKey(service)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if transport, ok := t.cache[key]; ok {
+		return transport, nil
+	}
+
+	if t.cache == nil {
+		t.cache = map[transportKey]*http.Transport{}
+	}
+
+	tlsConfig, err := key.tls.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("service %q: upstream tls: %w", service.Name, err)
+	}
+
+	transport := newTransport(key.timeouts, key.pool)
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if key.protocol == kong.ProtocolHTTP2 {
+		// sem HTTP/1.1 na lista, upstreams http recebem HTTP/2 direto (h2c, sem
+		// upgrade) e upstreams https que não negociam h2 dão erro
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+	t.cache[key] = transport
+
+	return transport, nil
+}
+
+// release fecha os transports que nenhum serviço da configuração usa mais, como os
+// de certificados lidos antes de um reload.
+func (t *transports) release(config *kong.Config) {
+	used := map[transportKey]bool{}
+	for i := range config.Services {
+		used[newTransportKey(&config.Services[i])] = true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, transport := range t.cache {
+		if !used[key] {
+			transport.CloseIdleConnections()
+			delete(t.cache, key)
+		}
+	}
+}
+
+func newTransport(timeouts kong.Timeouts, pool kong.ConnectionPool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   timeouts.ConnectTimeout(),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return &deadlineConn{Conn: conn, read: timeouts.ReadTimeout(), write: timeouts.WriteTimeout()}, nil
+	}
+	transport.TLSHandshakeTimeout = timeouts.ConnectTimeout()
+
+	if pool.MaxIdleConns > 0 {
+		transport.MaxIdleConns = pool.MaxIdleConns
+	}
+	if pool.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	}
+	if pool.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	}
+	if pool.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(pool.IdleConnTimeout) * time.Millisecond
+	}
+
+	return transport
+}
+
+// deadlineConn renova o prazo a cada leitura e escrita, então o timeout vale para
+// o tempo parado entre dois pacotes e não para a request inteira.
+type deadlineConn struct {
+	net.Conn
+	read  time.Duration
+	write time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.read)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.write)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,371 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAccessLogSinks(t *testing.T) {
+	plugin.RegisterPlugin("http_log", plugin.HTTPLogPlugin)
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+	plugin.RegisterPlugin("tcp_log", plugin.TCPLogPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Write([]byte("hello"))
+	}))
+	defer api.Close()
+
+	var mu sync.Mutex
+	var batches [][]plugin.AccessLogRecord
+	collector := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var batch []plugin.AccessLogRecord
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("expected error to be nil got %v", err)
+		}
+
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer listener.Close()
+
+	tcpRecords := make(chan plugin.AccessLogRecord, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				var record plugin.AccessLogRecord
+				json.Unmarshal(scanner.Bytes(), &record)
+				tcpRecords <- record
+			}
+			conn.Close()
+		}
+	}()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	c := routingConfig(t, `
+plugins:
+- name: http_log
+  input:
+    http_endpoint: `+collector.URL+`
+    batch_size: 2
+    flush_timeout: 60000
+- name: file_log
+  input:
+    path: `+logFile+`
+    redact_headers:
+    - X-Api-Key
+- name: tcp_log
+  input:
+    host: 127.0.0.1
+    port: `+strconv.Itoa(port)+`
+services:
+- name: orders
+  url: `+api.URL+`
+  routes:
+  - name: list-orders
+    paths:
+    - /orders
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(nethttp.MethodGet, "/orders?page="+strconv.Itoa(i), nil)
+		r.Header.Set("Authorization", "Bearer token")
+		r.Header.Set("X-Api-Key", "key")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	plugin.FlushAccessLogs()
+
+	mu.Lock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Errorf("expected batches of 2 and 1 records got %v", batches)
+	}
+	mu.Unlock()
+
+	record := batches[0][0]
+	if record.Service != "orders" || record.Route != "list-orders" || record.Response.Status != nethttp.StatusOK || record.Response.Size != 5 {
+		t.Errorf("expected record of orders/list-orders with status 200 and 5 bytes got %+v", record)
+	}
+
+	if record.Upstream != api.URL+"/orders?page=0" || record.ClientIP != "192.0.2.1" {
+		t.Errorf("expected upstream %v and client ip 192.0.2.1 got %v and %v", api.URL+"/orders?page=0", record.Upstream, record.ClientIP)
+	}
+
+	// os headers sensíveis padrão são escondidos
+	if record.Request.Headers.Get("Authorization") != "[REDACTED]" || record.Response.Headers.Get("Set-Cookie") != "[REDACTED]" {
+		t.Errorf("expected Authorization and Set-Cookie to be redacted got %v and %v", record.Request.Headers, record.Response.Headers)
+	}
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	var lines []plugin.AccessLogRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var line plugin.AccessLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines in file log got %v", len(lines))
+	}
+
+	// redact_headers troca a lista padrão
+	if lines[0].Request.Headers.Get("X-Api-Key") != "[REDACTED]" || lines[0].Request.Headers.Get("Authorization") != "Bearer token" {
+		t.Errorf("expected only X-Api-Key to be redacted got %v", lines[0].Request.Headers)
+	}
+
+	for i := 0; i < 3; i++ {
+		if record := <-tcpRecords; record.Request.Method != nethttp.MethodGet {
+			t.Errorf("expected tcp record for GET got %+v", record)
+		}
+	}
+}
+
+func TestAccessLogRedactsAPIKeys(t *testing.T) {
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+	plugin.RegisterPlugin("key_auth", plugin.KeyAuthPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer api.Close()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+
+	c := routingConfig(t, `
+plugins:
+- name: file_log
+  input:
+    path: `+logFile+`
+services:
+- name: orders
+  url: `+api.URL+`
+  plugins:
+  - name: key_auth
+    input:
+      key_names:
+      - apikey
+      - x-token
+  routes:
+  - name: list-orders
+    paths:
+    - /orders
+    methods:
+    - GET
+consumers:
+- name: acme
+  credentials:
+    key_auth:
+    - acme-key
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	r := httptest.NewRequest(nethttp.MethodGet, "/orders?page=2&apikey=acme-key&x-token=acme-key&sort=id", nil)
+	r.Header.Set("X-Token", "acme-key")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != nethttp.StatusOK {
+		t.Fatalf("expected status code 200 got %v", w.Code)
+	}
+
+	plugin.FlushAccessLogs()
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	var record plugin.AccessLogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if bytes.Contains(data, []byte("acme-key")) {
+		t.Errorf("expected the api key to be redacted got %s", data)
+	}
+
+	query := "page=2&apikey=[REDACTED]&x-token=[REDACTED]&sort=id"
+	if record.Request.URI != "/orders?"+query || record.Upstream != api.URL+"/orders?"+query {
+		t.Errorf("expected query %q got %q and %q", query, record.Request.URI, record.Upstream)
+	}
+
+	// x-token não está no redact_headers padrão, vem das key_names do key_auth
+	if record.Request.Headers.Get("X-Token") != "[REDACTED]" {
+		t.Errorf("expected X-Token to be redacted got %v", record.Request.Headers)
+	}
+}
+
+func TestAccessLogRedactsJWTInQuery(t *testing.T) {
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+	plugin.RegisterPlugin("jwt_auth", plugin.JWTAuthPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer api.Close()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+
+	c := routingConfig(t, `
+services:
+- name: orders
+  url: `+api.URL+`
+  plugins:
+  - name: file_log
+    input:
+      path: `+logFile+`
+  routes:
+  - name: list-orders
+    paths:
+    - /orders
+    methods:
+    - GET
+    plugins:
+    - name: jwt_auth
+      input:
+        key_in_query: true
+        key_name: access_token
+        secret: secret
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "acme"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("could not sign jwt token: %s", err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/orders?access_token="+token+"&page=2", nil))
+	if w.Code != nethttp.StatusOK {
+		t.Fatalf("expected status code 200 got %v", w.Code)
+	}
+
+	plugin.FlushAccessLogs()
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	var record plugin.AccessLogRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if bytes.Contains(data, []byte(token)) {
+		t.Errorf("expected the jwt to be redacted got %s", data)
+	}
+
+	query := "access_token=[REDACTED]&page=2"
+	if record.Request.URI != "/orders?"+query || record.Upstream != api.URL+"/orders?"+query {
+		t.Errorf("expected query %q got %q and %q", query, record.Request.URI, record.Upstream)
+	}
+}
+
+func TestAccessLogClosesQueuesAfterReload(t *testing.T) {
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer api.Close()
+
+	dir := t.TempDir()
+	fileLogConfig := func(i int) *kong.Config {
+		c := routingConfig(t, `
+plugins:
+- name: file_log
+  input:
+    path: `+filepath.Join(dir, strconv.Itoa(i)+".log")+`
+    flush_timeout: 60000
+services:
+- name: orders
+  url: `+api.URL+`
+  routes:
+  - name: list-orders
+    paths:
+    - /orders
+    methods:
+    - GET
+`)
+		if err := config.Validate(c); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		return c
+	}
+
+	holder := kong.NewConfigHolder(fileLogConfig(0))
+	s := http.NewServerFromHolder(holder)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodGet, "/orders", nil))
+	time.Sleep(50 * time.Millisecond)
+
+	goroutines := runtime.NumGoroutine()
+	for i := 1; i <= 20; i++ {
+		holder.Store(fileLogConfig(i))
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodGet, "/orders", nil))
+	}
+
+	// a fila da configuração anterior envia o que tinha ao ser fechada, sem esperar o flush_timeout
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(filepath.Join(dir, "0.log"))
+		if bytes.Count(data, []byte("\n")) == 1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the old queue to write its record when closed got %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n > goroutines+2 {
+		t.Errorf("expected the old queues to stop, %d goroutines before the reloads got %d", goroutines, n)
+	}
+}
@@ -0,0 +1,209 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func registerAuthPlugins() {
+	plugin.RegisterPlugin("key_auth", plugin.Definition{Priority: plugin.PriorityKeyAuth, Access: plugin.KeyAuth})
+	plugin.RegisterPlugin("basic_auth", plugin.Definition{Priority: plugin.PriorityBasicAuth, Access: plugin.BasicAuth})
+	plugin.RegisterPlugin("acl", plugin.Definition{Priority: plugin.PriorityACL, Access: plugin.ACL})
+	plugin.RegisterPlugin("add_header", plugin.Definition{Priority: plugin.PriorityAddHeader, Access: plugin.AddHeader})
+}
+
+func authConfig(t *testing.T, upstream string) *kong.Config {
+	t.Helper()
+	registerAuthPlugins()
+
+	c := routingConfig(t, `
+services:
+- name: orders
+  url: `+upstream+`
+  plugins:
+  - name: add_header
+    groups:
+    - partners
+    input:
+      X-Partner: "true"
+  - name: acl
+    input:
+      deny:
+      - blocked
+  - name: key_auth
+    input:
+      hide_credentials: true
+  routes:
+  - name: orders
+    paths:
+    - /orders
+    methods:
+    - GET
+- name: reports
+  url: `+upstream+`
+  plugins:
+  - name: basic_auth
+  routes:
+  - name: reports
+    paths:
+    - /reports
+    methods:
+    - GET
+consumers:
+- name: acme
+  custom_id: "42"
+  groups:
+  - partners
+  credentials:
+    key_auth:
+    - acme-key
+    basic_auth:
+    - username: acme
+      password: sha256:5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+- name: trial
+  credentials:
+    key_auth:
+    - trial-key
+- name: spammer
+  groups:
+  - blocked
+  credentials:
+    key_auth:
+    - spammer-key
+`)
+
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return c
+}
+
+func TestKeyAuthIdentifiesConsumer(t *testing.T) {
+	var received nethttp.Header
+	var query string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		received = r.Header
+		query = r.URL.RawQuery
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	s := http.NewServer(authConfig(t, api.URL))
+
+	tests := []struct {
+		name    string
+		target  string
+		key     string
+		status  int
+		partner string
+	}{
+		{"missing key", "/orders", "", nethttp.StatusUnauthorized, ""},
+		{"invalid key", "/orders", "wrong", nethttp.StatusUnauthorized, ""},
+		{"partner", "/orders", "acme-key", nethttp.StatusOK, "true"},
+		{"key in query", "/orders?apikey=trial-key&page=2", "", nethttp.StatusOK, ""},
+		{"denied group", "/orders", "spammer-key", nethttp.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		received = nil
+		r := httptest.NewRequest(nethttp.MethodGet, test.target, nil)
+		r.Header.Set(plugin.HeaderConsumerUsername, "spoofed")
+		if test.key != "" {
+			r.Header.Set("apikey", test.key)
+		}
+		w := httptest.NewRecorder()
+
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: expected status code %d got %v", test.name, test.status, w.Code)
+		}
+
+		if test.status != nethttp.StatusOK {
+			continue
+		}
+
+		if received.Get("apikey") != "" || strings.Contains(query, "apikey") {
+			t.Errorf("%s: expected key to be hidden from upstream got header %q and query %q", test.name, received.Get("apikey"), query)
+		}
+
+		if received.Get("X-Partner") != test.partner {
+			t.Errorf("%s: expected X-Partner to be %q got %q", test.name, test.partner, received.Get("X-Partner"))
+		}
+	}
+
+	r := httptest.NewRequest(nethttp.MethodGet, "/orders", nil)
+	r.Header.Set("apikey", "acme-key")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	expected := map[string]string{
+		plugin.HeaderConsumerUsername: "acme",
+		plugin.HeaderConsumerCustomID: "42",
+		plugin.HeaderConsumerGroups:   "partners",
+	}
+	for header, value := range expected {
+		if received.Get(header) != value {
+			t.Errorf("expected %s to be %v got %v", header, value, received.Get(header))
+		}
+	}
+}
+
+func TestBasicAuthIdentifiesConsumer(t *testing.T) {
+	var username string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		username = r.Header.Get(plugin.HeaderConsumerUsername)
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	s := http.NewServer(authConfig(t, api.URL))
+
+	r := httptest.NewRequest(nethttp.MethodGet, "/reports", nil)
+	r.SetBasicAuth("acme", "password")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != nethttp.StatusOK || username != "acme" {
+		t.Errorf("expected status code 200 for consumer acme got %v for %q", w.Code, username)
+	}
+
+	r = httptest.NewRequest(nethttp.MethodGet, "/reports", nil)
+	r.SetBasicAuth("acme", "wrong")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != nethttp.StatusUnauthorized {
+		t.Errorf("expected status code 401 got %v", w.Code)
+	}
+
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected WWW-Authenticate to be set")
+	}
+}
+
+func TestValidateRejectsDuplicatedCredentials(t *testing.T) {
+	c := routingConfig(t, `
+consumers:
+- name: a
+  credentials:
+    key_auth:
+    - same-key
+- name: b
+  credentials:
+    key_auth:
+    - same-key
+`)
+
+	err := config.Validate(c)
+	if err == nil || !strings.Contains(err.Error(), `already used by consumer "a"`) {
+		t.Errorf("expected duplicated key error got %v", err)
+	}
+}
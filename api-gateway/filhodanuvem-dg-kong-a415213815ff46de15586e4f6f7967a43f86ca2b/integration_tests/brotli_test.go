@@ -0,0 +1,413 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
+)
+
+var updateBrotli = flag.Bool("update-brotli", false, "regrava os streams de testdata/brotli")
+
+// Os arquivos .br de testdata/brotli foram conferidos com o decoder de referência
+// (o zlib do node, que usa a biblioteca do google), então o brotliDecode abaixo não
+// é a única prova de que o encoder gera streams válidos. Se o encoder mudar, rode
+// com -update-brotli e confira de novo cada stream antes de commitar:
+//
+//	node -e 'const fs = require("fs"), zlib = require("zlib");
+//	  for (const f of process.argv.slice(1)) if (!zlib.brotliDecompressSync(fs.readFileSync(f)).equals(fs.readFileSync(f.replace(/(\.flushed)?\.br$/, "")))) throw f' \
+//	  integration_tests/testdata/brotli/*.br
+func TestCompressionBrotliMatchesReferenceStreams(t *testing.T) {
+	cases := []struct {
+		input string
+		// flush é o tamanho dos pedaços escritos entre um Flush e outro, 0 escreve tudo de uma vez
+		flush  int
+		golden string
+	}{
+		{input: "empty.txt", golden: "empty.txt.br"},
+		{input: "random.bin", golden: "random.bin.br"},
+		{input: "gateway.yaml", golden: "gateway.yaml.br"},
+		{input: "payments.json", golden: "payments.json.br"},
+		{input: "payments.json", flush: 30_000, golden: "payments.json.flushed.br"},
+	}
+
+	p := kong.Plugin{Name: "compression", Input: map[string]any{"encodings": []any{"br"}}}
+
+	for _, c := range cases {
+		t.Run(c.golden, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", "brotli", c.input))
+			if err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+
+			size := max(len(input), 1)
+			if c.flush > 0 {
+				size = c.flush
+			}
+
+			var compressed bytes.Buffer
+			w := brotliBody(t, p, &compressed)
+			for chunk := range slices.Chunk(input, size) {
+				w.Write(chunk)
+				if c.flush > 0 {
+					w.(interface{ Flush() error }).Flush()
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+
+			golden := filepath.Join("testdata", "brotli", c.golden)
+			if *updateBrotli {
+				os.WriteFile(golden, compressed.Bytes(), 0o644)
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+
+			if !bytes.Equal(compressed.Bytes(), expected) {
+				t.Errorf("expected the stream checked by the reference decoder (%d bytes) got %d bytes", len(expected), compressed.Len())
+			}
+		})
+	}
+}
+
+// brotliBody monta o writer do body_filter do compression para uma request que só aceita br.
+func brotliBody(t *testing.T, p kong.Plugin, w io.Writer) io.WriteCloser {
+	t.Helper()
+
+	var ctx context.Context
+	r := httptest.NewRequest(nethttp.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	plugin.Compression(p, func(_ nethttp.ResponseWriter, r *nethttp.Request) {
+		ctx = r.Context()
+	})(httptest.NewRecorder(), r)
+
+	res := &plugin.ResponseHead{StatusCode: nethttp.StatusOK, Header: nethttp.Header{"Content-Type": {"text/plain"}}}
+	body := plugin.CompressionBody(p, r.WithContext(ctx), res, w)
+	if body == nil {
+		t.Fatalf("expected the response to be compressed")
+	}
+
+	return body
+}
+
+// brotliDecode descomprime o que o compression gera. Não há decoder de brotli na
+// biblioteca padrão, então este cobre o formato sem o dicionário estático, sem
+// troca de tipos de bloco e sem mapas de contexto, que o gateway não usa.
+// leia: https://www.rfc-editor.org/rfc/rfc7932
+func brotliDecode(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &brotliReader{data: data}
+	wbits := 16
+	if in.bits(1) == 1 {
+		if n := in.bits(3); n != 0 {
+			wbits = 17 + n
+		} else if n = in.bits(3); n != 0 {
+			wbits = 8 + n
+		} else {
+			wbits = 17
+		}
+	}
+	window := 1<<wbits - 16
+
+	var out []byte
+	for {
+		last := in.bits(1) == 1
+		if last && in.bits(1) == 1 {
+			return out, in.err
+		}
+
+		nibbles := in.bits(2) + 4
+		if nibbles == 7 {
+			// metadados: bit reservado, tamanho e os bytes ignorados
+			in.bits(1)
+			skip := 0
+			if n := in.bits(2); n > 0 {
+				skip = in.bits(8*n) + 1
+			}
+			in.align()
+			in.pos += skip
+			if last {
+				return out, in.err
+			}
+			continue
+		}
+
+		length := in.bits(4*nibbles) + 1
+		if !last && in.bits(1) == 1 {
+			in.align()
+			if in.pos+length > len(in.data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			out = append(out, in.data[in.pos:in.pos+length]...)
+			in.pos += length
+			continue
+		}
+
+		if in.bits(1) != 0 || in.bits(1) != 0 || in.bits(1) != 0 {
+			return nil, errors.New("block types are not supported")
+		}
+		if in.bits(2) != 0 || in.bits(4) != 0 {
+			return nil, errors.New("NPOSTFIX and NDIRECT are not supported")
+		}
+		in.bits(2)
+		if in.bits(1) != 0 || in.bits(1) != 0 {
+			return nil, errors.New("context maps are not supported")
+		}
+
+		literals := in.prefixCode(256)
+		commands := in.prefixCode(704)
+		distances := in.prefixCode(64)
+
+		end := len(out) + length
+		for len(out) < end && in.err == nil {
+			command := commands.decode(in)
+			if command < 128 {
+				return nil, errors.New("implicit distances are not supported")
+			}
+
+			insertCode, copyCode := brotliCommandCodes(command)
+			insert := brotliInsertBase[insertCode] + in.bits(brotliInsertExtra[insertCode])
+			copyLength := brotliCopyBase[copyCode] + in.bits(brotliCopyExtra[copyCode])
+
+			for range insert {
+				out = append(out, byte(literals.decode(in)))
+			}
+			if len(out) >= end {
+				break
+			}
+
+			code := distances.decode(in) - 16
+			if code < 0 {
+				return nil, errors.New("distance ring buffer is not supported")
+			}
+			bits := 1 + code>>1
+			distance := (2+code&1)<<bits - 4 + in.bits(bits) + 1
+			if distance > len(out) || distance > window {
+				return nil, fmt.Errorf("invalid distance %d at %d", distance, len(out))
+			}
+
+			for range copyLength {
+				out = append(out, out[len(out)-distance])
+			}
+		}
+
+		if in.err != nil {
+			return nil, in.err
+		}
+		if len(out) != end {
+			return nil, fmt.Errorf("meta-block has %d bytes, expected %d", len(out)-end+length, length)
+		}
+	}
+}
+
+var (
+	brotliInsertBase  = []int{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	brotliInsertExtra = []int{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	brotliCopyBase    = []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	brotliCopyExtra   = []int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+)
+
+func brotliCommandCodes(command int) (int, int) {
+	insertBase := []int{0, 0, 0, 0, 8, 8, 0, 16, 8, 16, 16}
+	copyBase := []int{0, 8, 0, 8, 0, 8, 16, 0, 16, 8, 16}
+	cell := command >> 6
+
+	return insertBase[cell] + command>>3&7, copyBase[cell] + command&7
+}
+
+type brotliReader struct {
+	data []byte
+	pos  int
+	bit  int
+	err  error
+}
+
+func (r *brotliReader) bits(n int) int {
+	v := 0
+	for i := range n {
+		if r.pos >= len(r.data) {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+
+		v |= int(r.data[r.pos]>>r.bit&1) << i
+		if r.bit++; r.bit == 8 {
+			r.pos, r.bit = r.pos+1, 0
+		}
+	}
+
+	return v
+}
+
+func (r *brotliReader) align() {
+	if r.bit > 0 {
+		r.pos, r.bit = r.pos+1, 0
+	}
+}
+
+// brotliCode é um código de Huffman canônico, lido bit a bit.
+type brotliCode map[[2]int]int
+
+func newBrotliCode(lengths []int) brotliCode {
+	code := brotliCode{}
+	next := 0
+	for length := 1; length <= 15; length++ {
+		for symbol, l := range lengths {
+			if l == length {
+				code[[2]int{length, next}] = symbol
+				next++
+			}
+		}
+		next <<= 1
+	}
+
+	return code
+}
+
+func (c brotliCode) decode(r *brotliReader) int {
+	if symbol, ok := c[[2]int{0, 0}]; ok {
+		return symbol
+	}
+
+	v := 0
+	for length := 1; length <= 15 && r.err == nil; length++ {
+		v = v<<1 | r.bits(1)
+		if symbol, ok := c[[2]int{length, v}]; ok {
+			return symbol
+		}
+	}
+
+	r.err = errors.New("invalid prefix code")
+	return 0
+}
+
+func (r *brotliReader) prefixCode(alphabet int) brotliCode {
+	alphabetBits := 0
+	for 1<<alphabetBits < alphabet {
+		alphabetBits++
+	}
+
+	skip := r.bits(2)
+	if skip == 1 {
+		symbols := make([]int, r.bits(2)+1)
+		for i := range symbols {
+			symbols[i] = r.bits(alphabetBits)
+		}
+
+		lengths := make([]int, alphabet)
+		switch len(symbols) {
+		case 1:
+			return brotliCode{{0, 0}: symbols[0]}
+		case 2:
+			lengths[symbols[0]], lengths[symbols[1]] = 1, 1
+		case 3:
+			lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]] = 1, 2, 2
+		case 4:
+			if r.bits(1) == 0 {
+				lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]], lengths[symbols[3]] = 2, 2, 2, 2
+			} else {
+				lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]], lengths[symbols[3]] = 1, 2, 3, 3
+			}
+		}
+		return newBrotliCode(lengths)
+	}
+
+	order := []int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	codeLengths := make([]int, 18)
+	space, used := 32, 0
+	for _, symbol := range order[skip:] {
+		// código fixo dos tamanhos: 00=0, 0111=1, 011=2, 10=3, 01=4, 1111=5, lido do
+		// bit menos significativo
+		var v int
+		switch r.bits(2) {
+		case 0:
+			v = 0
+		case 1:
+			v = 4
+		case 2:
+			v = 3
+		default:
+			if r.bits(1) == 0 {
+				v = 2
+			} else if r.bits(1) == 0 {
+				v = 1
+			} else {
+				v = 5
+			}
+		}
+
+		codeLengths[symbol] = v
+		if v != 0 {
+			space -= 32 >> v
+			used++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+
+	var lengthCode brotliCode
+	if used == 1 {
+		lengthCode = brotliCode{{0, 0}: slices.IndexFunc(codeLengths, func(l int) bool { return l != 0 })}
+	} else {
+		lengthCode = newBrotliCode(codeLengths)
+	}
+
+	lengths := make([]int, 0, alphabet)
+	space = 32768
+	previous, repeat, repeatCode := 8, 0, 0
+	for len(lengths) < alphabet && space > 0 && r.err == nil {
+		symbol := lengthCode.decode(r)
+		if symbol < 16 {
+			lengths = append(lengths, symbol)
+			repeat = 0
+			if symbol != 0 {
+				previous = symbol
+				space -= 32768 >> symbol
+			}
+			continue
+		}
+
+		value, extraBits := 0, 3
+		if symbol == 16 {
+			value, extraBits = previous, 2
+		}
+		if repeatCode != symbol {
+			repeat = 0
+		}
+		repeatCode = symbol
+
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extraBits
+		}
+		repeat += r.bits(extraBits) + 3
+		for range repeat - old {
+			lengths = append(lengths, value)
+			if value != 0 {
+				space -= 32768 >> value
+			}
+		}
+	}
+
+	return newBrotliCode(append(lengths, make([]int, alphabet-len(lengths))...))
+}
@@ -0,0 +1,333 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/admin"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func serveCached(f http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	f(w, r)
+	return w
+}
+
+func TestPluginProxyCacheHitAfterMiss(t *testing.T) {
+	counter := 0
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "hit_after_miss_test",
+			"ttl":        60,
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		counter++
+		w.Header().Add("Link", "</payments/2>; rel=next")
+		w.Header().Add("Link", "</payments>; rel=collection")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("payment 1"))
+	}
+
+	f = plugin.ProxyCache(p, f)
+
+	w := serveCached(f, httptest.NewRequest(http.MethodGet, "/payments/1", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss {
+		t.Errorf("expected first request to be a MISS got %v", w.Header().Get("X-Cache-Status"))
+	}
+
+	w = serveCached(f, httptest.NewRequest(http.MethodGet, "/payments/1", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusHit {
+		t.Errorf("expected second request to be a HIT got %v", w.Header().Get("X-Cache-Status"))
+	}
+
+	if w.Body.String() != "payment 1" {
+		t.Errorf("expected cached body to be payment 1 got %v", w.Body.String())
+	}
+
+	if len(w.Header().Values("Link")) != 2 {
+		t.Errorf("expected cached headers to keep all values got %v", w.Header().Values("Link"))
+	}
+
+	if counter != 1 {
+		t.Errorf("expected upstream to be called once got %d", counter)
+	}
+
+	// POST não é cacheado
+	w = serveCached(f, httptest.NewRequest(http.MethodPost, "/payments/1", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusBypass {
+		t.Errorf("expected POST to BYPASS the cache got %v", w.Header().Get("X-Cache-Status"))
+	}
+}
+
+func TestPluginProxyCacheKeepsHeadersOfTheCurrentRequest(t *testing.T) {
+	plugin.RegisterRateLimitStore("cache_headers_test", plugin.NewMemoryRateLimitStore())
+
+	rateLimit := kong.Plugin{
+		Name:  "rate_limiting",
+		Input: map[string]any{"minute": 100, "store": "cache_headers_test"},
+	}
+	cache := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			// um cache novo a cada execução, o store de cache é global
+			"cache_name": "cache_headers_test_" + strconv.FormatInt(time.Now().UnixNano(), 10),
+			"ttl":        60,
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Upstream", "payments")
+		w.Write([]byte("payment 1"))
+	}
+
+	// o rate_limiting tem prioridade maior e coloca os headers antes do cache
+	f = plugin.RateLimit(rateLimit, plugin.ProxyCache(cache, f))
+
+	expected := []struct {
+		status    string
+		remaining string
+	}{
+		{plugin.CacheStatusMiss, "99"},
+		{plugin.CacheStatusHit, "98"},
+		{plugin.CacheStatusHit, "97"},
+	}
+
+	for i, e := range expected {
+		w := serveCached(f, httptest.NewRequest(http.MethodGet, "/payments/1", nil))
+
+		if w.Header().Get("X-Cache-Status") != e.status || w.Header().Get("X-RateLimit-Remaining") != e.remaining {
+			t.Errorf("request %d: expected %s with %s remaining got %s with %s", i, e.status, e.remaining, w.Header().Get("X-Cache-Status"), w.Header().Get("X-RateLimit-Remaining"))
+		}
+
+		if w.Header().Get("X-Upstream") != "payments" {
+			t.Errorf("request %d: expected the upstream headers to be kept got %v", i, w.Header())
+		}
+	}
+}
+
+func TestPluginProxyCacheHonorsCacheControl(t *testing.T) {
+	counter := 0
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "cache_control_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		counter++
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	f = plugin.ProxyCache(p, f)
+
+	serveCached(f, httptest.NewRequest(http.MethodGet, "/private", nil))
+	w := serveCached(f, httptest.NewRequest(http.MethodGet, "/private", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss || counter != 2 {
+		t.Errorf("expected private responses to not be cached, got %v after %d upstream calls", w.Header().Get("X-Cache-Status"), counter)
+	}
+
+	serveCached(f, httptest.NewRequest(http.MethodGet, "/public", nil))
+
+	r := httptest.NewRequest(http.MethodGet, "/public", nil)
+	r.Header.Set("Cache-Control", "no-cache")
+	w = serveCached(f, r)
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusBypass {
+		t.Errorf("expected request with no-cache to BYPASS got %v", w.Header().Get("X-Cache-Status"))
+	}
+}
+
+func TestPluginProxyCacheVary(t *testing.T) {
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "vary_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}
+
+	f = plugin.ProxyCache(p, f)
+
+	for _, language := range []string{"pt-BR", "en"} {
+		r := httptest.NewRequest(http.MethodGet, "/greeting", nil)
+		r.Header.Set("Accept-Language", language)
+		serveCached(f, r)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/greeting", nil)
+	r.Header.Set("Accept-Language", "pt-BR")
+	w := serveCached(f, r)
+
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusHit || w.Body.String() != "pt-BR" {
+		t.Errorf("expected a HIT for pt-BR got %v with body %v", w.Header().Get("X-Cache-Status"), w.Body.String())
+	}
+}
+
+func TestPluginProxyCacheSeparatesClients(t *testing.T) {
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "separates_clients_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/session" {
+			w.Header().Set("Set-Cookie", "session=s3cr3t")
+		}
+		consumer, _ := kong.ConsumerFromContext(r.Context())
+		if consumer != nil {
+			w.Write([]byte(consumer.Name))
+		}
+		w.Write([]byte(r.Host))
+	}
+
+	cached := plugin.ProxyCache(p, f)
+	withConsumer := func(name, host string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.Host = host
+		if name != "" {
+			r = r.WithContext(kong.WithConsumer(r.Context(), &kong.Consumer{Name: name}))
+		}
+		return r
+	}
+
+	serveCached(cached, withConsumer("alice", "a.example.com"))
+	for _, test := range []struct {
+		consumer string
+		host     string
+		status   string
+		body     string
+	}{
+		{"alice", "a.example.com", plugin.CacheStatusHit, "alicea.example.com"},
+		{"bob", "a.example.com", plugin.CacheStatusMiss, "boba.example.com"},
+		{"alice", "b.example.com", plugin.CacheStatusMiss, "aliceb.example.com"},
+		{"", "a.example.com", plugin.CacheStatusMiss, "a.example.com"},
+	} {
+		w := serveCached(cached, withConsumer(test.consumer, test.host))
+		if w.Header().Get("X-Cache-Status") != test.status || w.Body.String() != test.body {
+			t.Errorf("expected %q on %s to be a %s with body %q got %v %q", test.consumer, test.host, test.status, test.body, w.Header().Get("X-Cache-Status"), w.Body.String())
+		}
+	}
+
+	// respostas com cookie são de um cliente só
+	serveCached(cached, httptest.NewRequest(http.MethodGet, "/session", nil))
+	w := serveCached(cached, httptest.NewRequest(http.MethodGet, "/session", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss {
+		t.Errorf("expected responses with Set-Cookie to not be cached got %v", w.Header().Get("X-Cache-Status"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w = serveCached(cached, r)
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusBypass {
+		t.Errorf("expected request with Authorization to BYPASS got %v", w.Header().Get("X-Cache-Status"))
+	}
+
+	p.Input["cache_authorized"] = true
+	cached = plugin.ProxyCache(p, f)
+	for _, token := range []string{"Bearer a", "Bearer a", "Bearer b"} {
+		r := httptest.NewRequest(http.MethodGet, "/authorized", nil)
+		r.Header.Set("Authorization", token)
+		w = serveCached(cached, r)
+	}
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss {
+		t.Errorf("expected another token to be a MISS got %v", w.Header().Get("X-Cache-Status"))
+	}
+}
+
+func TestCacheStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "lru_test",
+			// cabe só uma resposta de cada vez
+			"memory_size": 200,
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(make([]byte, 100))
+	}
+
+	f = plugin.ProxyCache(p, f)
+
+	serveCached(f, httptest.NewRequest(http.MethodGet, "/a", nil))
+	serveCached(f, httptest.NewRequest(http.MethodGet, "/b", nil))
+
+	if n := plugin.FindCacheStore("lru_test", 200).Len(); n != 1 {
+		t.Errorf("expected cache to have 1 entry got %d", n)
+	}
+
+	w := serveCached(f, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss {
+		t.Errorf("expected /a to be evicted got %v", w.Header().Get("X-Cache-Status"))
+	}
+}
+
+func TestAdminPurgesCacheKey(t *testing.T) {
+	p := kong.Plugin{
+		Name: "proxy_cache",
+		Input: map[string]any{
+			"cache_name": "purge_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	f = plugin.ProxyCache(p, f)
+
+	w := serveCached(f, httptest.NewRequest(http.MethodGet, "/purge", nil))
+	key := w.Header().Get("X-Cache-Key")
+
+	a := httptest.NewServer(admin.NewServer(kong.NewConfigHolder(&kong.Config{})))
+	defer a.Close()
+
+	req, _ := http.NewRequest(http.MethodDelete, a.URL+"/proxy-cache/"+key, nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status code 204 got %v", res.StatusCode)
+	}
+
+	w = serveCached(f, httptest.NewRequest(http.MethodGet, "/purge", nil))
+	if w.Header().Get("X-Cache-Status") != plugin.CacheStatusMiss {
+		t.Errorf("expected purged key to be a MISS got %v", w.Header().Get("X-Cache-Status"))
+	}
+
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	res.Body.Close()
+
+	// depois do MISS a chave voltou para o cache, então o purge funciona de novo
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected status code 204 got %v", res.StatusCode)
+	}
+}
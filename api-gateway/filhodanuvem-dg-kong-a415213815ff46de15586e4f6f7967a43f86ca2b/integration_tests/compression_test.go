@@ -0,0 +1,365 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	nethttp "net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func TestCompressionNegotiatesEncoding(t *testing.T) {
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+
+	large := strings.Repeat(`{"id":1,"status":"paid"},`, 100)
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":1}`))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte(large))
+		}
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: compression
+    input:
+      min_size: 100
+  routes:
+  - name: all
+    match: prefix
+    paths:
+    - /
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{"/payments", "gzip, deflate, br", "br"},
+		{"/payments", "gzip, deflate", "gzip"},
+		{"/payments", "gzip;q=0.5, deflate", "deflate"},
+		{"/payments", "br;q=0.5, gzip", "gzip"},
+		{"/payments", "zstd, *;q=0.1", "br"},
+		{"/payments", "gzip;q=0, deflate;q=0", ""},
+		{"/payments", "", ""},
+		{"/small", "gzip", ""},
+		{"/image", "gzip", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(nethttp.MethodGet, test.path, nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if encoding := w.Header().Get("Content-Encoding"); encoding != test.encoding {
+			t.Errorf("expected %s with %q to have content encoding %q got %q", test.path, test.acceptEncoding, test.encoding, encoding)
+			continue
+		}
+
+		var body io.Reader = w.Body
+		switch test.encoding {
+		case "gzip":
+			reader, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			body = reader
+		case "deflate":
+			body = flate.NewReader(w.Body)
+		case "br":
+			decoded, err := brotliDecode(w.Body)
+			if err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+			body = bytes.NewReader(decoded)
+		}
+
+		decoded, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("expected error to be nil got %v", err)
+		}
+
+		if test.path == "/payments" && string(decoded) != large {
+			t.Errorf("expected %s with %q to return the original body got %q", test.path, test.acceptEncoding, decoded)
+		}
+
+		if test.encoding != "" && (w.Header().Get("ETag") != `W/"v1"` || w.Header().Get("Content-Length") != "") {
+			t.Errorf("expected weak etag and no content length got %v", w.Header())
+		}
+
+		if test.path == "/payments" && w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("expected vary Accept-Encoding got %q", w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestCompressionStreamsResponse(t *testing.T) {
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+
+	release := make(chan struct{})
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(nethttp.Flusher).Flush()
+
+		<-release
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: events
+  url: `+api.URL+`
+  plugins:
+  - name: compression
+  routes:
+  - name: stream
+    paths:
+    - /events
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	gateway := httptest.NewServer(http.NewServer(c))
+	defer gateway.Close()
+
+	req, _ := nethttp.NewRequest(nethttp.MethodGet, gateway.URL+"/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := nethttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected content encoding gzip got %q", res.Header.Get("Content-Encoding"))
+	}
+
+	reader, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	// a primeira mensagem chega enquanto o upstream ainda não terminou a resposta
+	lines := bufio.NewReader(reader)
+	if line, err := lines.ReadString('\n'); err != nil || line != "data: first\n" {
+		t.Errorf("expected first event before the upstream finished got %q %v", line, err)
+	}
+
+	close(release)
+
+	rest, err := io.ReadAll(lines)
+	if err != nil || string(rest) != "\ndata: second\n\n" {
+		t.Errorf("expected second event got %q %v", rest, err)
+	}
+}
+
+func TestCompressionBrotliLargeResponse(t *testing.T) {
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+
+	// texto repetitivo e bytes aleatórios, em vários meta-blocks e com flushes no meio
+	random := make([]byte, 100_000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var large bytes.Buffer
+	for i := range 5_000 {
+		fmt.Fprintf(&large, `{"id":%d,"status":"paid","amount":%d},`, i, i*37%1000)
+	}
+	large.Write(random)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for chunk := range slices.Chunk(large.Bytes(), 30_000) {
+			w.Write(chunk)
+			w.(nethttp.Flusher).Flush()
+		}
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: compression
+  routes:
+  - name: export
+    paths:
+    - /payments/export
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	gateway := httptest.NewServer(http.NewServer(c))
+	defer gateway.Close()
+
+	req, _ := nethttp.NewRequest(nethttp.MethodGet, gateway.URL+"/payments/export", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	res, err := nethttp.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "br" {
+		t.Fatalf("expected content encoding br got %q", res.Header.Get("Content-Encoding"))
+	}
+
+	compressed, _ := io.ReadAll(res.Body)
+	decoded, err := brotliDecode(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if !bytes.Equal(decoded, large.Bytes()) {
+		t.Fatalf("expected the original body of %d bytes got %d bytes", large.Len(), len(decoded))
+	}
+
+	if len(compressed) >= large.Len()-len(random)/2 {
+		t.Errorf("expected the json part to be compressed, %d bytes became %d", large.Len(), len(compressed))
+	}
+}
+
+func TestCompressionDecompressesUpstreamForTransformer(t *testing.T) {
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+	plugin.RegisterPlugin("response_transformer", plugin.ResponseTransformerPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body := `{"id":"` + strings.Repeat("a", 2048) + `","secret":"s3cr3t"}`
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write([]byte(body))
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		writer.Write([]byte(body))
+		writer.Close()
+	}))
+	defer api.Close()
+
+	for _, decompress := range []bool{false, true} {
+		c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: compression
+    input:
+      decompress_upstream: `+strconv.FormatBool(decompress)+`
+  - name: response_transformer
+    input:
+      remove:
+        json:
+        - secret
+  routes:
+  - name: get-payment
+    paths:
+    - /payments/1
+    methods:
+    - GET
+`)
+		if err := config.Validate(c); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		gateway := httptest.NewServer(http.NewServer(c))
+
+		req, _ := nethttp.NewRequest(nethttp.MethodGet, gateway.URL+"/payments/1", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		res, err := nethttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		reader, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		var payment map[string]any
+		if err := json.NewDecoder(reader).Decode(&payment); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		res.Body.Close()
+		gateway.Close()
+
+		// sem decompress_upstream o body chega comprimido e o transformer não mexe nele
+		if _, ok := payment["secret"]; ok == decompress {
+			t.Errorf("expected secret to be removed only with decompress_upstream, decompress %v got %v", decompress, payment["secret"])
+		}
+	}
+}
+
+func TestValidateRejectsInvalidCompressionOptions(t *testing.T) {
+	plugin.RegisterPlugin("compression", plugin.CompressionPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:3000
+  plugins:
+  - name: compression
+    input:
+      encodings:
+      - zstd
+      level: 12
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+
+	err := config.Validate(c)
+	for _, expected := range []string{`unsupported encoding "zstd"`, "level must be between 1 and 9"} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func TestServerExposesRouteMatchToPlugins(t *testing.T) {
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	plugin.Register("context_echo", func(p kong.Plugin, f nethttp.HandlerFunc) nethttp.HandlerFunc {
+		return func(w nethttp.ResponseWriter, r *nethttp.Request) {
+			service, _ := kong.ServiceFromContext(r.Context())
+			route, _ := kong.RouteFromContext(r.Context())
+
+			w.Header().Set("X-Service", service.Name)
+			w.Header().Set("X-Route", route.Name)
+			w.Header().Set("X-Payment-Id", kong.PathParam(r, "id"))
+			f(w, r)
+		}
+	})
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: context_echo
+  routes:
+  - name: get-payment
+    paths:
+    - /payments/{id:int}
+    methods:
+    - GET
+`)
+
+	w := httptest.NewRecorder()
+	http.NewServer(c).ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/payments/42", nil))
+
+	expected := map[string]string{
+		"X-Service":    "payments",
+		"X-Route":      "get-payment",
+		"X-Payment-Id": "42",
+	}
+
+	for header, value := range expected {
+		if w.Header().Get(header) != value {
+			t.Errorf("expected %s to be %v got %v", header, value, w.Header().Get(header))
+		}
+	}
+}
+
+func TestPluginRateLimitByPathParam(t *testing.T) {
+	plugin.RegisterRateLimitStore("path_param_test", plugin.NewMemoryRateLimitStore())
+
+	p := kong.Plugin{
+		Name: "rate_limiting",
+		Input: map[string]any{
+			"minute":     1,
+			"limit_by":   "path_param",
+			"param_name": "id",
+			"store":      "path_param_test",
+		},
+	}
+
+	f := func(w nethttp.ResponseWriter, _ *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusOK)
+	}
+
+	f = plugin.RateLimit(p, f)
+
+	service := &kong.Service{Name: "payments"}
+	request := func(id string) *nethttp.Request {
+		r := httptest.NewRequest(nethttp.MethodGet, "/payments/"+id, nil)
+		match := &kong.RouteMatch{Service: service, Params: map[string]string{"id": id}}
+		return r.WithContext(kong.WithRouteMatch(r.Context(), match))
+	}
+
+	expected := []struct {
+		id     string
+		status int
+	}{
+		{"1", nethttp.StatusOK},
+		{"2", nethttp.StatusOK},
+		{"1", nethttp.StatusTooManyRequests},
+	}
+
+	for i, e := range expected {
+		w := httptest.NewRecorder()
+		f(w, request(e.id))
+
+		if w.Code != e.status {
+			t.Errorf("request %d: expected status code %d got %v", i, e.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func TestCORSPreflightAndActualRequests(t *testing.T) {
+	plugin.RegisterPlugin("cors", plugin.CORSPlugin)
+	plugin.RegisterPlugin("key_auth", plugin.KeyAuthPlugin)
+
+	upstreamCalls := 0
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		upstreamCalls++
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example.com")
+		w.Header().Set("X-Request-Id", "42")
+		w.WriteHeader(nethttp.StatusCreated)
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: key_auth
+  - name: cors
+    input:
+      origins:
+      - https://app.example.com
+      - https://*.example.org
+      methods:
+      - POST
+      headers:
+      - Content-Type
+      exposed_headers:
+      - X-Request-Id
+      credentials: true
+      max_age: 600
+  routes:
+  - name: create-payment
+    paths:
+    - /payments
+    methods:
+    - POST
+    - PUT
+- name: shippings
+  url: `+api.URL+`
+  routes:
+  - name: create-shipping
+    paths:
+    - /shippings
+    methods:
+    - POST
+consumers:
+- name: acme
+  credentials:
+    key_auth:
+    - acme-key
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	preflight := func(path, origin, method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(nethttp.MethodOptions, path, nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", method)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	// o preflight não tem credenciais, então o cors precisa responder antes do key_auth
+	w := preflight("/payments", "https://app.example.com", nethttp.MethodPost)
+	if w.Code != nethttp.StatusNoContent {
+		t.Fatalf("expected status code 204 got %v", w.Code)
+	}
+
+	expected := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "POST",
+		"Access-Control-Allow-Headers":     "Content-Type",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+		"Vary":                             "Origin",
+	}
+	for header, value := range expected {
+		if w.Header().Get(header) != value {
+			t.Errorf("expected %s to be %v got %v", header, value, w.Header().Get(header))
+		}
+	}
+
+	if upstreamCalls != 0 {
+		t.Errorf("expected preflight to not reach upstream got %v calls", upstreamCalls)
+	}
+
+	if w := preflight("/payments", "https://api.example.org", nethttp.MethodPost); w.Header().Get("Access-Control-Allow-Origin") != "https://api.example.org" {
+		t.Errorf("expected wildcard subdomain to be allowed got %v", w.Header())
+	}
+
+	for _, origin := range []string{"https://evil.com", "https://example.org", "http://api.example.org"} {
+		if w := preflight("/payments", origin, nethttp.MethodPost); w.Code != nethttp.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: expected status code 403 without CORS headers got %v %v", origin, w.Code, w.Header())
+		}
+	}
+
+	if w := preflight("/payments", "https://app.example.com", nethttp.MethodPut); w.Code != nethttp.StatusForbidden {
+		t.Errorf("expected method outside methods to get status code 403 got %v", w.Code)
+	}
+
+	// sem o plugin cors, OPTIONS continua sem rota
+	if w := preflight("/shippings", "https://app.example.com", nethttp.MethodPost); w.Code != nethttp.StatusNotFound {
+		t.Errorf("expected status code 404 got %v", w.Code)
+	}
+
+	r := httptest.NewRequest(nethttp.MethodPost, "/payments", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("apikey", "acme-key")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	if w.Code != nethttp.StatusCreated {
+		t.Fatalf("expected status code 201 got %v", w.Code)
+	}
+
+	if values := w.Header().Values("Access-Control-Allow-Origin"); len(values) != 1 || values[0] != "https://app.example.com" {
+		t.Errorf("expected Access-Control-Allow-Origin to be replaced got %v", values)
+	}
+
+	if w.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+		t.Errorf("expected Access-Control-Expose-Headers to be X-Request-Id got %v", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestValidateRejectsInvalidCORSOrigin(t *testing.T) {
+	plugin.RegisterPlugin("cors", plugin.CORSPlugin)
+
+	for _, origin := range []string{"app.example.com", "https://api.*.example.com", "https://app.example.com/path"} {
+		c := routingConfig(t, `
+plugins:
+- name: cors
+  input:
+    origins:
+    - `+origin+`
+`)
+
+		if err := config.Validate(c); err == nil {
+			t.Errorf("%s: expected invalid origin error", origin)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+// grpcFrame monta uma mensagem no formato do gRPC: 1 byte de compressão, 4 de
+// tamanho e a mensagem. O gateway não lê o protobuf, então qualquer conteúdo serve.
+func grpcFrame(message string) []byte {
+	frame := make([]byte, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(message)))
+	copy(frame[5:], message)
+
+	return frame
+}
+
+func readGRPCFrame(r io.Reader) (string, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+
+	message := make([]byte, binary.BigEndian.Uint32(header[1:5]))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return "", err
+	}
+
+	return string(message), nil
+}
+
+// newGRPCServer sobe um servidor que, como um servidor gRPC, só fala HTTP/2 sem TLS
+// e devolve o status da chamada nos trailers.
+func newGRPCServer(t *testing.T) *httptest.Server {
+	api := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Te") != "trailers" {
+			t.Errorf("expected HTTP/2 request with te trailers got %v te %q", r.Proto, r.Header.Get("Te"))
+		}
+
+		message, err := readGRPCFrame(r.Body)
+		if err != nil {
+			t.Errorf("expected error to be nil got %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		switch r.URL.Path {
+		case "/payments.v1.Payments/Authorize":
+			w.Write(grpcFrame("authorized " + message))
+			w.Header().Set("Grpc-Status", "0")
+		case "/payments.v1.Payments/Refund":
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "payment%20not%20found")
+		default:
+			w.Header().Set("Grpc-Status", "12")
+		}
+	}))
+	api.Config.Protocols = new(nethttp.Protocols)
+	api.Config.Protocols.SetUnencryptedHTTP2(true)
+	api.Start()
+
+	return api
+}
+
+func newGRPCClient() *nethttp.Client {
+	protocols := new(nethttp.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &nethttp.Client{Transport: &nethttp.Transport{Protocols: protocols}}
+}
+
+func callGRPC(t *testing.T, client *nethttp.Client, url, message string) (*nethttp.Response, string) {
+	t.Helper()
+
+	req, _ := nethttp.NewRequest(nethttp.MethodPost, url, bytes.NewReader(grpcFrame(message)))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	// os trailers só estão em res.Trailer depois que o body foi lido até o fim
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	reply := ""
+	if len(body) > 0 {
+		if reply, err = readGRPCFrame(bytes.NewReader(body)); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+	}
+
+	return res, reply
+}
+
+func TestGRPCProxyOverH2C(t *testing.T) {
+	plugin.RegisterPlugin("file_log", plugin.FileLogPlugin)
+
+	api := newGRPCServer(t)
+	defer api.Close()
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+
+	c := routingConfig(t, `
+plugins:
+- name: file_log
+  input:
+    path: `+logFile+`
+services:
+- name: payments
+  url: `+api.URL+`
+  protocol: http2
+  routes:
+  - name: payments-grpc
+    match: prefix
+    paths:
+    - /payments.v1.Payments/
+    methods:
+    - POST
+- name: inventory
+  url: http://127.0.0.1:1
+  protocol: http2
+  routes:
+  - name: reserve
+    paths:
+    - /inventory.v1.Stock/Reserve
+    methods:
+    - POST
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	gateway := httptest.NewUnstartedServer(http.NewServer(c))
+	gateway.Config.Protocols = http.ListenerProtocols()
+	gateway.Start()
+	defer gateway.Close()
+
+	client := newGRPCClient()
+
+	res, reply := callGRPC(t, client, gateway.URL+"/payments.v1.Payments/Authorize", "order-1")
+	if res.ProtoMajor != 2 || res.StatusCode != nethttp.StatusOK {
+		t.Errorf("expected HTTP/2 200 got %v %v", res.Proto, res.StatusCode)
+	}
+	if reply != "authorized order-1" {
+		t.Errorf("expected reply authorized order-1 got %q", reply)
+	}
+	if res.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("expected grpc-status 0 in trailers got %v", res.Trailer)
+	}
+
+	res, _ = callGRPC(t, client, gateway.URL+"/payments.v1.Payments/Refund", "order-1")
+	if res.Trailer.Get("Grpc-Status") != "5" || res.Trailer.Get("Grpc-Message") != "payment%20not%20found" {
+		t.Errorf("expected grpc-status 5 in trailers got %v", res.Trailer)
+	}
+
+	res, _ = callGRPC(t, client, gateway.URL+"/inventory.v1.Stock/Reserve", "sku-1")
+	if res.StatusCode != nethttp.StatusBadGateway {
+		t.Errorf("expected status code 502 got %v", res.StatusCode)
+	}
+
+	plugin.FlushAccessLogs()
+
+	file, err := os.Open(logFile)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer file.Close()
+
+	var statuses []plugin.GRPCStatus
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record plugin.AccessLogRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		if record.Response.GRPC == nil {
+			t.Fatalf("expected grpc status in record got %+v", record.Response)
+		}
+		statuses = append(statuses, *record.Response.GRPC)
+	}
+
+	expected := []plugin.GRPCStatus{
+		{Code: 0, Name: "OK"},
+		{Code: 5, Name: "NOT_FOUND", Message: "payment not found"},
+		{Code: 14, Name: "UNAVAILABLE"},
+	}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %d records got %v", len(expected), statuses)
+	}
+	for i := range expected {
+		if statuses[i] != expected[i] {
+			t.Errorf("expected record %d to have grpc status %+v got %+v", i, expected[i], statuses[i])
+		}
+	}
+}
+
+func TestHTTP1ClientKeepsWorkingOnH2CListener(t *testing.T) {
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.ProtoMajor != 1 {
+			t.Errorf("expected HTTP/1.1 upstream request got %v", r.Proto)
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: `+api.URL+`
+  routes:
+  - name: all
+    paths:
+    - /orders
+    methods:
+    - GET
+`)
+
+	gateway := httptest.NewUnstartedServer(http.NewServer(c))
+	gateway.Config.Protocols = http.ListenerProtocols()
+	gateway.Start()
+	defer gateway.Close()
+
+	res, err := nethttp.Get(gateway.URL + "/orders")
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.ProtoMajor != 1 || string(body) != "ok" {
+		t.Errorf("expected HTTP/1.1 response ok got %v %q", res.Proto, body)
+	}
+}
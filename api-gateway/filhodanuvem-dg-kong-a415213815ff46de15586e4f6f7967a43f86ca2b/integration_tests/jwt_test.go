@@ -0,0 +1,406 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/plugin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func signToken(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("could not sign jwt token: %v", err)
+	}
+
+	return tokenString
+}
+
+func serveJWT(p kong.Plugin, tokenString string, next http.HandlerFunc) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+tokenString)
+	r.Header.Set("X-User-Id", "spoofed")
+	w := httptest.NewRecorder()
+
+	plugin.JWTAuth(p, next)(w, r)
+
+	return w
+}
+
+func okHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestPluginJWTAuthRS256WithPublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	p := kong.Plugin{
+		Name: "jwt_auth",
+		Input: map[string]any{
+			"public_key":      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			"key_in_header":   true,
+			"key_name":        "Authorization",
+			"issuer":          "https://auth.example.com",
+			"audience":        "payments",
+			"required_claims": []any{"sub", "exp"},
+			"claims_to_headers": map[string]any{
+				"sub": "X-User-Id",
+			},
+		},
+	}
+
+	claims := jwt.MapClaims{
+		"sub": "user-1",
+		"iss": "https://auth.example.com",
+		"aud": "payments",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	var userID string
+	w := serveJWT(p, signToken(t, jwt.SigningMethodRS256, key, "", claims), func(w http.ResponseWriter, r *http.Request) {
+		userID = r.Header.Get("X-User-Id")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status code 200 got %v", w.Code)
+	}
+
+	if userID != "user-1" {
+		t.Errorf("expected X-User-Id to be user-1 got %v", userID)
+	}
+
+	invalid := map[string]jwt.MapClaims{
+		"wrong issuer":   {"sub": "user-1", "iss": "https://evil.example.com", "aud": "payments", "exp": claims["exp"]},
+		"wrong audience": {"sub": "user-1", "iss": claims["iss"], "aud": "shippings", "exp": claims["exp"]},
+		"missing sub":    {"iss": claims["iss"], "aud": "payments", "exp": claims["exp"]},
+		"missing exp":    {"sub": "user-1", "iss": claims["iss"], "aud": "payments"},
+	}
+
+	for name, c := range invalid {
+		w := serveJWT(p, signToken(t, jwt.SigningMethodRS256, key, "", c), okHandler)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status code 401 got %v", name, w.Code)
+		}
+	}
+}
+
+func TestPluginJWTAuthReadsPublicKeyFileOnEveryReload(t *testing.T) {
+	plugin.RegisterPlugin("jwt_auth", plugin.JWTAuthPlugin)
+
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	rotate := func() *ecdsa.PrivateKey {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+		return key
+	}
+
+	// cada reload valida o plugin de novo, como o config.Validate faz
+	reload := func() kong.Plugin {
+		p := kong.Plugin{
+			Name: "jwt_auth",
+			Input: map[string]any{
+				"public_key_file": keyFile,
+				"key_in_header":   true,
+				"key_name":        "Authorization",
+			},
+		}
+		if errs := config.ValidatePlugin(&p); len(errs) > 0 {
+			t.Fatalf("expected no errors got %v", errs)
+		}
+		return p
+	}
+
+	claims := jwt.MapClaims{"sub": "user-1"}
+
+	old := rotate()
+	p := reload()
+	if w := serveJWT(p, signToken(t, jwt.SigningMethodES256, old, "", claims), okHandler); w.Code != http.StatusOK {
+		t.Errorf("expected status code 200 got %v", w.Code)
+	}
+
+	renewed := rotate()
+	p = reload()
+	if w := serveJWT(p, signToken(t, jwt.SigningMethodES256, renewed, "", claims), okHandler); w.Code != http.StatusOK {
+		t.Errorf("expected the rotated key to be accepted after the reload got %v", w.Code)
+	}
+
+	if w := serveJWT(p, signToken(t, jwt.SigningMethodES256, old, "", claims), okHandler); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the old key to be rejected after the reload got %v", w.Code)
+	}
+
+	os.Remove(keyFile)
+	p = kong.Plugin{Name: "jwt_auth", Input: map[string]any{"public_key_file": keyFile, "key_in_header": true, "key_name": "Authorization"}}
+	if errs := config.ValidatePlugin(&p); len(errs) == 0 {
+		t.Errorf("expected error for missing public_key_file")
+	}
+}
+
+func TestPluginJWTAuthRejectsAlgorithmOutsideAllowList(t *testing.T) {
+	secret := "th1s1ss3cr3t"
+	p := kong.Plugin{
+		Name: "jwt_auth",
+		Input: map[string]any{
+			"secret":        secret,
+			"algorithms":    []any{"HS512"},
+			"key_in_header": true,
+			"key_name":      "Authorization",
+		},
+	}
+
+	w := serveJWT(p, signToken(t, jwt.SigningMethodHS256, []byte(secret), "", jwt.MapClaims{}), okHandler)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected HS256 to be rejected with status code 401 got %v", w.Code)
+	}
+
+	w = serveJWT(p, signToken(t, jwt.SigningMethodHS512, []byte(secret), "", jwt.MapClaims{}), okHandler)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected HS512 to be accepted with status code 200 got %v", w.Code)
+	}
+}
+
+func TestPluginJWTAuthClockSkew(t *testing.T) {
+	secret := "th1s1ss3cr3t"
+	input := map[string]any{
+		"secret":        secret,
+		"key_in_header": true,
+		"key_name":      "Authorization",
+	}
+
+	tokenString := signToken(t, jwt.SigningMethodHS256, []byte(secret), "", jwt.MapClaims{
+		"exp": time.Now().Add(-10 * time.Second).Unix(),
+	})
+
+	w := serveJWT(kong.Plugin{Name: "jwt_auth", Input: input}, tokenString, okHandler)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected expired token to get status code 401 got %v", w.Code)
+	}
+
+	input["clock_skew"] = 30
+	w = serveJWT(kong.Plugin{Name: "jwt_auth", Input: input}, tokenString, okHandler)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected token inside clock skew to get status code 200 got %v", w.Code)
+	}
+}
+
+func TestPluginJWTAuthES256WithJWKS(t *testing.T) {
+	plugin.PurgeJWKSCache()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32)))
+	}
+
+	var fetches atomic.Int32
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{"kty": "EC", "kid": "key-1", "use": "sig", "crv": "P-256", "x": encode(key.X), "y": encode(key.Y)},
+			},
+		})
+	}))
+	defer jwksServer.Close()
+
+	p := kong.Plugin{
+		Name: "jwt_auth",
+		Input: map[string]any{
+			"jwks_url":      jwksServer.URL,
+			"algorithms":    []any{"ES256"},
+			"key_in_header": true,
+			"key_name":      "Authorization",
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		w := serveJWT(p, signToken(t, jwt.SigningMethodES256, key, "key-1", jwt.MapClaims{"sub": "user-1"}), okHandler)
+		if w.Code != http.StatusOK {
+			t.Errorf("request %d: expected status code 200 got %v", i, w.Code)
+		}
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("expected jwks to be fetched once got %v", fetches.Load())
+	}
+
+	// kid desconhecido não é aceito e, dentro do intervalo mínimo, não busca o JWKS de novo
+	w := serveJWT(p, signToken(t, jwt.SigningMethodES256, key, "key-2", jwt.MapClaims{"sub": "user-1"}), okHandler)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unknown kid to get status code 401 got %v", w.Code)
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("expected jwks to still be fetched once got %v", fetches.Load())
+	}
+}
+
+func jwksBody(key *ecdsa.PrivateKey, kid string) []byte {
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, 32)))
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"keys": []map[string]string{
+			{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256", "x": encode(key.X), "y": encode(key.Y)},
+		},
+	})
+
+	return body
+}
+
+func jwksPlugin(url string, ttl int) kong.Plugin {
+	return kong.Plugin{
+		Name: "jwt_auth",
+		Input: map[string]any{
+			"jwks_url":       url,
+			"jwks_cache_ttl": ttl,
+			"algorithms":     []any{"ES256"},
+			"key_in_header":  true,
+			"key_name":       "Authorization",
+		},
+	}
+}
+
+func TestPluginJWTAuthFetchesJWKSOncePerSource(t *testing.T) {
+	plugin.PurgeJWKSCache()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	token := signToken(t, jwt.SigningMethodES256, key, "key-1", jwt.MapClaims{"sub": "user-1"})
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		w.Write(jwksBody(key, "key-1"))
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksBody(key, "key-1"))
+	}))
+	defer fast.Close()
+
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serveJWT(jwksPlugin(slow.URL, 300), token, okHandler).Code
+		}()
+	}
+
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// enquanto a fonte lenta não responde, as outras fontes continuam atendendo
+	done := make(chan int)
+	go func() { done <- serveJWT(jwksPlugin(fast.URL, 300), token, okHandler).Code }()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("expected status code 200 from the other source got %v", code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the other source not to wait for the slow jwks")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: expected status code 200 got %v", i, code)
+		}
+	}
+
+	if fetches.Load() != 1 {
+		t.Errorf("expected concurrent requests to share one jwks fetch got %v", fetches.Load())
+	}
+}
+
+func TestPluginJWTAuthKeepsStaleJWKSWhenRefreshFails(t *testing.T) {
+	plugin.PurgeJWKSCache()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	token := signToken(t, jwt.SigningMethodES256, key, "key-1", jwt.MapClaims{"sub": "user-1"})
+
+	var fetches atomic.Int32
+	var down atomic.Bool
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(jwksBody(key, "key-1"))
+	}))
+	defer jwksServer.Close()
+
+	// com ttl 0 toda request depois da primeira encontra as chaves vencidas
+	p := jwksPlugin(jwksServer.URL, 0)
+	if w := serveJWT(p, token, okHandler); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200 got %v", w.Code)
+	}
+
+	down.Store(true)
+	for i := 0; i < 3; i++ {
+		if w := serveJWT(p, token, okHandler); w.Code != http.StatusOK {
+			t.Errorf("request %d: expected stale keys to keep working got status code %v", i, w.Code)
+		}
+	}
+
+	// depois da falha a fonte só é consultada de novo depois do intervalo mínimo
+	if fetches.Load() != 2 {
+		t.Errorf("expected one failed refresh got %v fetches", fetches.Load())
+	}
+}
@@ -1,21 +1,37 @@
 package tests
 
 import (
-	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/devgymbr/kong"
 	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/plugin"
 )
 
-func TestInitialLoader(t *testing.T) {
-	pwd, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("unable to get current directory: %s", err)
+func registerLoaderPlugins() {
+	plugin.RegisterPlugin("http_log", plugin.Definition{Log: plugin.Log})
+	plugin.Register("add_header", plugin.AddHeader)
+}
+
+// waitForConfig espera o loader trocar a configuração ativa por uma que satisfaça cond.
+func waitForConfig(holder *kong.ConfigHolder, cond func(c *kong.Config) bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond(holder.Load()) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
-	path := pwd + "/files/initial_loader.yaml"
+
+	return false
+}
+
+func TestInitialLoader(t *testing.T) {
+	registerLoaderPlugins()
+	path := filepath.Join(t.TempDir(), "initial_loader.yaml")
 
 	yaml := []byte(
 		`
@@ -29,29 +45,23 @@ services:
   - paths:
     - /payments
     methods:
-    - GET	
+    - GET
 `)
 
-	fp, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("unable to create temporary file %s: %s", path, err)
-	}
-	defer fp.Close()
-
-	_, err = fp.Write(yaml)
-	if err != nil {
+	if err := os.WriteFile(path, yaml, 0644); err != nil {
 		t.Fatalf("unable to write on temporary file %s: %s", path, err)
 	}
 
-	tooLong := 10 * time.Second
-	c := kong.Config{}
-	err = config.Loader(&c, path, tooLong)
+	holder := kong.NewConfigHolder(&kong.Config{})
+	reloader, err := config.Loader(holder, path)
 	if err != nil {
 		t.Fatalf("unable to load config: %s", err)
 	}
+	defer reloader.Close()
 
+	c := holder.Load()
 	if len(c.Services) != 1 || len(c.Services[0].Routes) != 1 {
-		t.Fatalf("expected 1 service and 1 route (no refresh before 10sec), got %d services and %d routes", len(c.Services), len(c.Services[0].Routes))
+		t.Fatalf("expected 1 service and 1 route, got %d services", len(c.Services))
 	}
 
 	if c.Services[0].Name != "payments" {
@@ -71,12 +81,9 @@ services:
 	}
 }
 
-func TestLongRefreshTickerDoesNotRefresh(t *testing.T) {
-	pwd, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("unable to get current directory: %s", err)
-	}
-	path := pwd + "/files/one_route_get_payments.yaml"
+func TestLoaderRefreshesWhenFileChanges(t *testing.T) {
+	registerLoaderPlugins()
+	path := filepath.Join(t.TempDir(), "two_route_get_payments.yaml")
 
 	yaml := []byte(
 		`
@@ -90,26 +97,21 @@ services:
   - paths:
     - /payments
     methods:
-    - GET	
+    - GET
 `)
 
-	fp, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("unable to create temporary file %s: %s", path, err)
-	}
-	defer fp.Close()
-
-	_, err = fp.Write(yaml)
-	if err != nil {
+	if err := os.WriteFile(path, yaml, 0644); err != nil {
 		t.Fatalf("unable to write on temporary file %s: %s", path, err)
 	}
 
-	tooLong := 10 * time.Second
-	c := kong.Config{}
-	err = config.Loader(&c, path, tooLong)
+	holder := kong.NewConfigHolder(&kong.Config{})
+	reloader, err := config.Loader(holder, path)
 	if err != nil {
 		t.Fatalf("unable to load config: %s", err)
 	}
+	defer reloader.Close()
+
+	previous := holder.Load()
 
 	// update yaml
 	yaml = []byte(
@@ -125,32 +127,29 @@ services:
     - /payments
     methods:
     - GET
-		- POST # !!!!! added POST method 
+    - POST # !!!!! added POST method
 `)
 
-	if _, err = fp.Seek(int64(0), 0); err != nil {
-		t.Fatalf("unable to truncate temporary file %s: %s", path, err)
-	}
-
-	_, err = fp.Write(yaml)
-	if err != nil {
+	if err = os.WriteFile(path, yaml, 0644); err != nil {
 		t.Fatalf("unable to override on temporary file %s: %s", path, err)
 	}
 
-	// wait for the a few seconds but before the interval to refresh
-	time.Sleep(2 * time.Second)
+	refreshed := waitForConfig(holder, func(c *kong.Config) bool {
+		return len(c.Services) == 1 && len(c.Services[0].Routes) == 1 && len(c.Services[0].Routes[0].Methods) == 2
+	})
+	if !refreshed {
+		t.Fatalf("expected 1 service with a refreshed route with 2 methods")
+	}
 
-	if len(c.Services) != 1 || len(c.Services[0].Routes) != 1 || len(c.Services[0].Routes[0].Methods) != 1 {
-		t.Fatalf("expected 1 service with a refreshed route with %d methods", len(c.Services[0].Routes[0].Methods))
+	// quem pegou a configuração antes da troca não vê ela mudar
+	if len(previous.Services[0].Routes[0].Methods) != 1 {
+		t.Errorf("expected previous config to be untouched got %v", previous.Services[0].Routes[0].Methods)
 	}
 }
 
-func TestShortRefreshTickerDoesRefresh(t *testing.T) {
-	pwd, err := os.Getwd()
-	if err != nil {
-		t.Fatalf("unable to get current directory: %s", err)
-	}
-	path := pwd + "/files/two_route_get_payments.yaml"
+func TestLoaderKeepsLastValidConfig(t *testing.T) {
+	registerLoaderPlugins()
+	path := filepath.Join(t.TempDir(), "invalid_change.yaml")
 
 	yaml := []byte(
 		`
@@ -159,63 +158,113 @@ services:
   url: http://localhost:8081
   plugins:
   - name: http_log
-  - name: add_header
   routes:
   - paths:
     - /payments
     methods:
-    - GET	
+    - GET
 `)
 
-	fp, err := os.Create(path)
-	if err != nil {
-		t.Fatalf("unable to create temporary file %s: %s", path, err)
-	}
-	defer fp.Close()
-
-	_, err = fp.Write(yaml)
-	if err != nil {
+	if err := os.WriteFile(path, yaml, 0644); err != nil {
 		t.Fatalf("unable to write on temporary file %s: %s", path, err)
 	}
 
-	tooShort := 5 * time.Millisecond
-	c := kong.Config{}
-	err = config.Loader(&c, path, tooShort)
+	holder := kong.NewConfigHolder(&kong.Config{})
+	reloader, err := config.Loader(holder, path)
 	if err != nil {
 		t.Fatalf("unable to load config: %s", err)
 	}
+	defer reloader.Close()
 
-	// update yaml
-	yaml = []byte(
+	valid := holder.Load()
+
+	invalid := []byte(
 		`
 services:
 - name: payments
   url: http://localhost:8081
   plugins:
-  - name: http_log
-  - name: add_header
+  - name: plugin_that_does_not_exist
   routes:
   - paths:
     - /payments
     methods:
     - GET
-    - POST # !!!!! added POST method 
+    - POST
 `)
 
-	if _, err = fp.Seek(int64(0), io.SeekStart); err != nil {
-		t.Fatalf("unable to truncate temporary file %s: %s", path, err)
+	if err = os.WriteFile(path, invalid, 0644); err != nil {
+		t.Fatalf("unable to override on temporary file %s: %s", path, err)
+	}
+
+	// tempo suficiente para o watcher perceber a mudança
+	time.Sleep(300 * time.Millisecond)
+
+	if holder.Load() != valid {
+		t.Fatalf("expected invalid config to be rejected got %+v", holder.Load().Services)
+	}
+
+	// o mesmo vale para o reload forçado pelo SIGHUP
+	if err = reloader.Reload(); err == nil {
+		t.Errorf("expected reload of an invalid file to fail")
 	}
 
-	_, err = fp.Write(yaml)
+	if holder.Load() != valid {
+		t.Errorf("expected last valid config to stay active after reload")
+	}
+}
+
+func TestLoaderRefreshesDirectoryOfFragments(t *testing.T) {
+	registerLoaderPlugins()
+	dir := t.TempDir()
+
+	payments := []byte(
+		`
+services:
+- name: payments
+  url: http://localhost:8081
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	if err := os.WriteFile(filepath.Join(dir, "10-payments.yaml"), payments, 0644); err != nil {
+		t.Fatalf("unable to write on temporary file: %s", err)
+	}
+
+	holder := kong.NewConfigHolder(&kong.Config{})
+	reloader, err := config.Loader(holder, dir)
 	if err != nil {
-		t.Fatalf("unable to override on temporary file %s: %s", path, err)
+		t.Fatalf("unable to load config: %s", err)
+	}
+	defer reloader.Close()
+
+	if len(holder.Load().Services) != 1 {
+		t.Fatalf("expected 1 service got %v", holder.Load().Services)
+	}
+
+	shippings := []byte(`{"services": [{"name": "shippings", "url": "http://localhost:8082", "routes": [{"paths": ["/shippings"], "methods": ["GET"]}]}]}`)
+	if err = os.WriteFile(filepath.Join(dir, "20-shippings.json"), shippings, 0644); err != nil {
+		t.Fatalf("unable to write on temporary file: %s", err)
+	}
+
+	added := waitForConfig(holder, func(c *kong.Config) bool {
+		return len(c.Services) == 2 && c.Services[1].Name == "shippings"
+	})
+	if !added {
+		t.Fatalf("expected new fragment to be loaded got %v", holder.Load().Services)
 	}
-	fp.Sync()
 
-	// wait for the a few seconds but before the interval to refresh
-	time.Sleep(30 * time.Millisecond)
+	if err = os.Remove(filepath.Join(dir, "10-payments.yaml")); err != nil {
+		t.Fatalf("unable to remove temporary file: %s", err)
+	}
 
-	if len(c.Services) != 1 || len(c.Services[0].Routes) != 1 || len(c.Services[0].Routes[0].Methods) != 2 {
-		t.Fatalf("expected 1 service with a refreshed route with %d methods", len(c.Services[0].Routes[0].Methods))
+	removed := waitForConfig(holder, func(c *kong.Config) bool {
+		return len(c.Services) == 1 && c.Services[0].Name == "shippings"
+	})
+	if !removed {
+		t.Errorf("expected removed fragment to be dropped got %v", holder.Load().Services)
 	}
 }
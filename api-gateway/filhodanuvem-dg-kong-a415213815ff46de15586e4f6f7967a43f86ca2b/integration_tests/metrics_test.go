@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/admin"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func TestPrometheusMetricsAndStatus(t *testing.T) {
+	plugin.ResetMetrics()
+	plugin.RegisterPlugin("prometheus", plugin.PrometheusPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		io.Copy(io.Discard, r.Body)
+		time.Sleep(5 * time.Millisecond)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(nethttp.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer api.Close()
+
+	holder := kong.NewConfigHolder(routingConfig(t, `
+plugins:
+- name: prometheus
+services:
+- name: orders
+  url: `+api.URL+`
+  routes:
+  - name: create-order
+    paths:
+    - /orders
+    methods:
+    - POST
+  - name: broken
+    paths:
+    - /broken
+    methods:
+    - GET
+- name: idle
+  url: http://localhost:1
+  routes:
+  - name: idle
+    paths:
+    - /idle
+    methods:
+    - GET
+`))
+	gateway := http.NewServerFromHolder(holder)
+	adminServer := admin.NewServer(holder)
+	adminServer.Upstreams = gateway.Client
+	a := httptest.NewServer(adminServer)
+	defer a.Close()
+
+	for i := 0; i < 2; i++ {
+		gateway.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodPost, "/orders", strings.NewReader("1234")))
+	}
+	for i := 0; i < kong.UnhealthyAfter; i++ {
+		gateway.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodGet, "/broken", nil))
+	}
+
+	res := adminRequest(t, a.URL+"/metrics", nethttp.MethodGet, "")
+	body, _ := io.ReadAll(res.Body)
+	metrics := string(body)
+
+	expected := []string{
+		`kong_http_requests_total{service="orders",route="create-order",code="200"} 2`,
+		`kong_http_requests_total{service="orders",route="broken",code="500"} 3`,
+		`kong_bandwidth_bytes{service="orders",route="create-order",code="200",direction="egress"} 10`,
+		`kong_bandwidth_bytes{service="orders",route="create-order",code="200",direction="ingress"} 8`,
+		`kong_request_latency_ms_count{service="orders",route="create-order"} 2`,
+		`kong_upstream_latency_ms_bucket{service="orders",route="create-order",le="1"} 0`,
+		`kong_upstream_latency_ms_count{service="orders",route="create-order"} 2`,
+		`kong_gateway_latency_ms_count{service="orders",route="create-order"} 2`,
+		`# TYPE kong_request_latency_ms histogram`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("expected metrics to contain %s got\n%s", line, metrics)
+		}
+	}
+
+	res = adminRequest(t, a.URL+"/status", nethttp.MethodGet, "")
+	var status struct {
+		Config struct {
+			Version uint64 `json:"version"`
+		} `json:"config"`
+		Upstreams []struct {
+			Service string `json:"service"`
+			Health  string `json:"health"`
+		} `json:"upstreams"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if status.Config.Version != 1 {
+		t.Errorf("expected config version 1 got %v", status.Config.Version)
+	}
+
+	// o orders falhou nas últimas requests e o idle nunca recebeu nenhuma
+	health := map[string]string{}
+	for _, upstream := range status.Upstreams {
+		health[upstream.Service] = upstream.Health
+	}
+	if health["orders"] != "unhealthy" || health["idle"] != "unknown" {
+		t.Errorf("expected orders to be unhealthy and idle unknown got %v", health)
+	}
+}
@@ -0,0 +1,287 @@
+package tests
+
+import (
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+type mirroredRequest struct {
+	method string
+	uri    string
+	body   string
+	tenant string
+}
+
+// waitForMetric espera o worker do request_mirror registrar a métrica.
+func waitForMetric(t *testing.T, expected string) bool {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var b strings.Builder
+		plugin.WriteMetrics(&b)
+		if strings.Contains(b.String(), expected) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
+
+func TestRequestMirror(t *testing.T) {
+	plugin.ResetMetrics()
+	plugin.RegisterPlugin("request_mirror", plugin.RequestMirrorPlugin)
+
+	var primaryBody string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		primaryBody = string(body)
+		w.WriteHeader(nethttp.StatusCreated)
+		io.WriteString(w, "primary")
+	}))
+	defer api.Close()
+
+	mirrored := make(chan mirroredRequest, 10)
+	shadow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- mirroredRequest{r.Method, r.URL.RequestURI(), string(body), r.Header.Get("X-Tenant")}
+		w.WriteHeader(nethttp.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  routes:
+  - name: create-payment
+    paths:
+    - /payments
+    methods:
+    - POST
+    plugins:
+    - name: request_mirror
+      input:
+        url: `+shadow.URL+`/shadow
+        max_body_size: 32
+  - name: refund-payment
+    paths:
+    - /refunds
+    methods:
+    - POST
+    plugins:
+    - name: request_mirror
+      input:
+        url: `+shadow.URL+`
+        percentage: 0
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	send := func(path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(nethttp.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("X-Tenant", "acme")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := send("/payments?currency=BRL", `{"amount": 17}`)
+	if w.Code != nethttp.StatusCreated || w.Body.String() != "primary" {
+		t.Fatalf("expected primary response got %v %v", w.Code, w.Body.String())
+	}
+
+	if primaryBody != `{"amount": 17}` {
+		t.Errorf("expected primary to receive the body got %v", primaryBody)
+	}
+
+	select {
+	case got := <-mirrored:
+		expected := mirroredRequest{nethttp.MethodPost, "/shadow/payments?currency=BRL", `{"amount": 17}`, "acme"}
+		if got != expected {
+			t.Errorf("expected mirrored request %+v got %+v", expected, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected request to be mirrored")
+	}
+
+	if !waitForMetric(t, `kong_mirror_requests_total{service="payments",route="create-payment",primary_code="201",shadow_code="500"} 1`) {
+		t.Errorf("expected mirror comparison metric")
+	}
+
+	if !waitForMetric(t, `kong_mirror_latency_ms_count{service="payments",route="create-payment",target="shadow"} 1`) ||
+		!waitForMetric(t, `kong_mirror_latency_ms_count{service="payments",route="create-payment",target="primary"} 1`) {
+		t.Errorf("expected mirror latency metrics")
+	}
+
+	// bodies maiores que max_body_size vão inteiros para o upstream, mas não são copiados
+	large := strings.Repeat("x", 64)
+	if w = send("/payments", large); w.Code != nethttp.StatusCreated || primaryBody != large {
+		t.Errorf("expected primary to receive the whole body got %v %v", w.Code, len(primaryBody))
+	}
+
+	if !waitForMetric(t, `kong_mirror_dropped_total{service="payments",route="create-payment",reason="body_too_large"} 1`) {
+		t.Errorf("expected dropped metric for large body")
+	}
+
+	send("/refunds", "{}")
+
+	select {
+	case got := <-mirrored:
+		t.Errorf("expected no other request to be mirrored got %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRequestMirrorDropsWhenQueueIsFull(t *testing.T) {
+	plugin.ResetMetrics()
+	plugin.RegisterPlugin("request_mirror", plugin.RequestMirrorPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	release := make(chan struct{})
+	shadow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		<-release
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer shadow.Close()
+	defer close(release)
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: request_mirror
+    input:
+      url: `+shadow.URL+`
+      workers: 1
+      queue_size: 1
+  routes:
+  - name: list-payments
+    paths:
+    - /payments
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	// o shadow está travado, então um worker fica ocupado, um item fica na fila e o resto é descartado
+	for i := 0; i < 5; i++ {
+		started := time.Now()
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/payments", nil))
+
+		if w.Code != nethttp.StatusOK {
+			t.Fatalf("expected status code 200 got %v", w.Code)
+		}
+		if time.Since(started) > time.Second {
+			t.Fatalf("expected request not to wait for the shadow")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if !waitForMetric(t, `kong_mirror_dropped_total{service="payments",route="list-payments",reason="queue_full"} 3`) {
+		var b strings.Builder
+		plugin.WriteMetrics(&b)
+		t.Errorf("expected 3 dropped requests got\n%s", b.String())
+	}
+}
+
+func TestRequestMirrorClosesPoolsAfterReload(t *testing.T) {
+	plugin.RegisterPlugin("request_mirror", plugin.RequestMirrorPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer api.Close()
+
+	mirrored := make(chan string, 100)
+	shadow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		mirrored <- r.URL.Path
+	}))
+	defer shadow.Close()
+
+	mirrorConfig := func(i int) *kong.Config {
+		c := routingConfig(t, `
+services:
+- name: orders
+  url: `+api.URL+`
+  routes:
+  - name: list-orders
+    paths:
+    - /orders
+    methods:
+    - GET
+    plugins:
+    - name: request_mirror
+      input:
+        url: `+shadow.URL+`/`+strconv.Itoa(i)+`
+        workers: 8
+`)
+		if err := config.Validate(c); err != nil {
+			t.Fatalf("expected error to be nil got %v", err)
+		}
+
+		return c
+	}
+
+	waitForMirror := func(expected string) {
+		t.Helper()
+
+		select {
+		case got := <-mirrored:
+			if got != expected {
+				t.Errorf("expected request mirrored to %s got %s", expected, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected request to be mirrored to %s", expected)
+		}
+	}
+
+	holder := kong.NewConfigHolder(mirrorConfig(0))
+	s := http.NewServerFromHolder(holder)
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodGet, "/orders", nil))
+	waitForMirror("/0/orders")
+	time.Sleep(50 * time.Millisecond)
+
+	goroutines := runtime.NumGoroutine()
+	for i := 1; i <= 20; i++ {
+		holder.Store(mirrorConfig(i))
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(nethttp.MethodGet, "/orders", nil))
+		waitForMirror("/" + strconv.Itoa(i) + "/orders")
+	}
+
+	// cada reload troca o destino, e só os workers do último continuam rodando
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= goroutines+10 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the old mirror workers to stop, %d goroutines before the reloads got %d", goroutines, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,343 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/openapi"
+	"github.com/devgymbr/kong/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+const paymentsSpec = `
+openapi: 3.0.3
+info:
+  title: Payments API
+  version: 1.0.0
+servers:
+- url: http://payments.internal/v1
+paths:
+  /payments:
+    get:
+      operationId: listPayments
+      parameters:
+      - name: limit
+        in: query
+        schema:
+          type: integer
+          format: int32
+          maximum: 100
+      - $ref: '#/components/parameters/Tenant'
+    post:
+      operationId: createPayment
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/NewPayment'
+  /payments/{paymentId}:
+    parameters:
+    - name: paymentId
+      in: path
+      required: true
+      schema:
+        type: string
+        format: uuid
+    get:
+      operationId: getPayment
+    delete: {}
+  /payments/{paymentId}/items/{item-id}.json:
+    get:
+      parameters:
+      - name: paymentId
+        in: path
+        schema: {type: string, format: uuid}
+      - name: item-id
+        in: path
+        schema: {type: integer}
+components:
+  parameters:
+    Tenant:
+      name: X-Tenant
+      in: header
+      required: true
+      schema:
+        type: string
+  schemas:
+    Money:
+      type: number
+      minimum: 0
+      exclusiveMinimum: true
+    NewPayment:
+      type: object
+      required: [amount]
+      properties:
+        amount:
+          $ref: '#/components/schemas/Money'
+        description:
+          type: string
+          nullable: true
+          example: coffee
+`
+
+func TestImportOpenAPIGeneratesRoutes(t *testing.T) {
+	service, err := openapi.Import([]byte(paymentsSpec), openapi.Options{})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	if service.Name != "payments-api" || service.URL != "http://payments.internal/v1" {
+		t.Errorf("expected service payments-api at http://payments.internal/v1 got %q %q", service.Name, service.URL)
+	}
+
+	expected := []struct {
+		name   string
+		path   string
+		method string
+	}{
+		{"listPayments", "/payments", "GET"},
+		{"createPayment", "/payments", "POST"},
+		{"getPayment", "/payments/{paymentId:uuid}", "GET"},
+		{"delete-payments-paymentid", "/payments/{paymentId:uuid}", "DELETE"},
+		{"get-payments-paymentid-items-item-id-json", `/payments/{paymentId:uuid}/items/{item_id:int}\.json`, "GET"},
+	}
+
+	if len(service.Routes) != len(expected) {
+		t.Fatalf("expected %d routes got %+v", len(expected), service.Routes)
+	}
+
+	for i, route := range service.Routes {
+		if route.Name != expected[i].name || !slices.Equal(route.Paths, []string{expected[i].path}) || !slices.Equal(route.Methods, []string{expected[i].method}) {
+			t.Errorf("expected route %+v got %s %v %v", expected[i], route.Name, route.Paths, route.Methods)
+		}
+
+		if len(route.Plugins) > 0 {
+			t.Errorf("expected no plugins without validation got %v", route.Plugins)
+		}
+	}
+}
+
+func TestImportOpenAPIWithValidation(t *testing.T) {
+	plugin.RegisterPlugin("request_validator", plugin.RequestValidatorPlugin)
+
+	var forwarded []string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		forwarded = append(forwarded, r.Method+" "+r.URL.Path)
+	}))
+	defer api.Close()
+
+	service, err := openapi.Import([]byte(paymentsSpec), openapi.Options{Name: "payments", URL: api.URL + "/v1", Validation: true})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	// o serviço vai para o config.yaml, então passa pelo YAML como o comando faria
+	data, err := yaml.Marshal(&kong.Config{Services: []kong.Service{service}})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	c := routingConfig(t, string(data))
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	tests := []struct {
+		method string
+		path   string
+		header string
+		body   string
+		status int
+	}{
+		{"GET", "/payments?limit=10", "acme", "", nethttp.StatusOK},
+		{"GET", "/payments?limit=500", "acme", "", nethttp.StatusBadRequest},
+		{"GET", "/payments", "", "", nethttp.StatusBadRequest},
+		{"POST", "/payments", "", `{"amount":10,"description":null}`, nethttp.StatusOK},
+		{"POST", "/payments", "", `{"amount":0}`, nethttp.StatusBadRequest},
+		{"POST", "/payments", "", `{"description":"coffee"}`, nethttp.StatusBadRequest},
+		{"DELETE", "/payments/0b6b3f2e-3c1a-4a43-9f0e-1f6a4c1f7a10", "", "", nethttp.StatusOK},
+		{"GET", "/payments/not-an-uuid", "", "", nethttp.StatusNotFound},
+		{"GET", "/payments/0b6b3f2e-3c1a-4a43-9f0e-1f6a4c1f7a10/items/3.json", "", "", nethttp.StatusOK},
+		{"GET", "/payments/0b6b3f2e-3c1a-4a43-9f0e-1f6a4c1f7a10/items/3xjson", "", "", nethttp.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.header != "" {
+			r.Header.Set("X-Tenant", test.header)
+		}
+		if test.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("expected %s %s to return %d got %d: %s", test.method, test.path, test.status, w.Code, w.Body.String())
+		}
+	}
+
+	expected := []string{
+		"GET /v1/payments",
+		"POST /v1/payments",
+		"DELETE /v1/payments/0b6b3f2e-3c1a-4a43-9f0e-1f6a4c1f7a10",
+		"GET /v1/payments/0b6b3f2e-3c1a-4a43-9f0e-1f6a4c1f7a10/items/3.json",
+	}
+	if !slices.Equal(forwarded, expected) {
+		t.Errorf("expected requests %v to reach the upstream got %v", expected, forwarded)
+	}
+}
+
+func TestImportOpenAPIRejectsInvalidDocuments(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{"swagger: '2.0'\ninfo: {title: Old}\n", "only openapi 3 documents are supported"},
+		{"openapi: 3.1.0\ninfo: {title: Relative}\nservers: [{url: /v1}]\npaths: {/a: {get: {}}}\n", "is not an absolute url"},
+		{"openapi: 3.1.0\ninfo: {title: Empty}\nservers: [{url: 'http://a'}]\npaths: {}\n", "the document has no operations"},
+		{"openapi: 3.1.0\ninfo: {title: Ref}\nservers: [{url: 'http://a'}]\npaths: {/a: {get: {parameters: [{$ref: '#/components/parameters/Missing'}]}}}\n", `parameter $ref "#/components/parameters/Missing" not found`},
+	}
+
+	for _, test := range tests {
+		_, err := openapi.Import([]byte(test.spec), openapi.Options{})
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("expected error to contain %q got %v", test.expected, err)
+		}
+	}
+}
+
+const filesSpec = `
+openapi: 3.0.3
+info:
+  title: Files API
+servers:
+- url: http://files.internal
+paths:
+  /reports/{reportId}:
+    get:
+      operationId: getReport
+      parameters:
+      - {name: reportId, in: path, required: true, schema: {type: string}}
+  /users/{email}/objects/{key}:
+    get:
+      operationId: getObject
+      parameters:
+      - {name: email, in: path, required: true, schema: {type: string, format: email}}
+      - {name: key, in: path, required: true, schema: {type: string}}
+  /invoices/{status}:
+    get:
+      operationId: listInvoices
+      parameters:
+      - {name: status, in: path, required: true, schema: {type: string, enum: [open, paid]}}
+  /files/{name}:
+    get:
+      operationId: getFile
+      parameters:
+      - {name: name, in: path, required: true, schema: {type: string, pattern: '^[a-z]+\.(pdf|csv)$'}}
+  /codes/{code}:
+    get:
+      operationId: getCode
+      parameters:
+      - {name: code, in: path, required: true, schema: {type: string, pattern: '[0-9]{3}'}}
+`
+
+func TestImportOpenAPIPathParamTypes(t *testing.T) {
+	var forwarded []string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		forwarded = append(forwarded, r.URL.Path)
+	}))
+	defer api.Close()
+
+	service, err := openapi.Import([]byte(filesSpec), openapi.Options{URL: api.URL})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	paths := map[string]string{}
+	for _, route := range service.Routes {
+		paths[route.Name] = route.Paths[0]
+	}
+
+	expectedPaths := map[string]string{
+		"getReport":    "/reports/{reportId:segment}",
+		"getObject":    "/users/{email:segment}/objects/{key:segment}",
+		"listInvoices": "/invoices/(?P<status>open|paid)",
+		"getFile":      `/files/(?P<name>(?:[a-z]+\.(pdf|csv)))`,
+		"getCode":      `/codes/(?P<code>[^\x2f]*(?:[0-9]{3,3})[^\x2f]*)`,
+	}
+	for name, path := range expectedPaths {
+		if paths[name] != path {
+			t.Errorf("expected route %s to have path %q got %q", name, path, paths[name])
+		}
+	}
+
+	data, err := yaml.Marshal(&kong.Config{Services: []kong.Service{service}})
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	c := routingConfig(t, string(data))
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/reports/report.pdf", nethttp.StatusOK},
+		{"/reports/2024/report.pdf", nethttp.StatusNotFound},
+		{"/users/user@example.com/objects/ns:id", nethttp.StatusOK},
+		{"/users/user@example.com/objects/a.b.c", nethttp.StatusOK},
+		{"/invoices/paid", nethttp.StatusOK},
+		{"/invoices/void", nethttp.StatusNotFound},
+		{"/files/report.pdf", nethttp.StatusOK},
+		{"/files/report.txt", nethttp.StatusNotFound},
+		{"/files/Report.pdf", nethttp.StatusNotFound},
+		{"/codes/ab123cd", nethttp.StatusOK},
+		{"/codes/ab12cd", nethttp.StatusNotFound},
+		{"/codes/ab/123", nethttp.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(nethttp.MethodGet, test.path, nil)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("expected GET %s to return %d got %d: %s", test.path, test.status, w.Code, w.Body.String())
+		}
+	}
+
+	expected := []string{
+		"/reports/report.pdf",
+		"/users/user@example.com/objects/ns:id",
+		"/users/user@example.com/objects/a.b.c",
+		"/invoices/paid",
+		"/files/report.pdf",
+		"/codes/ab123cd",
+	}
+	if !slices.Equal(forwarded, expected) {
+		t.Errorf("expected requests %v to reach the upstream got %v", expected, forwarded)
+	}
+}
+
+func TestImportOpenAPIRejectsUnsupportedPatterns(t *testing.T) {
+	spec := "openapi: 3.0.3\ninfo: {title: Lookahead}\nservers: [{url: 'http://a'}]\n" +
+		"paths: {'/a/{id}': {get: {parameters: [{name: id, in: path, schema: {type: string, pattern: '^(?!admin)'}}]}}}\n"
+
+	_, err := openapi.Import([]byte(spec), openapi.Options{})
+	if err == nil || !strings.Contains(err.Error(), `path parameter "id": pattern "^(?!admin)" is not supported`) {
+		t.Errorf("expected unsupported pattern error got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+// upperFilter deixa o body em maiúsculas, só para o teste enxergar a fase body_filter.
+type upperFilter struct {
+	w io.Writer
+}
+
+func (u upperFilter) Write(b []byte) (int, error) {
+	if _, err := u.w.Write(bytes.ToUpper(b)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (u upperFilter) Close() error {
+	_, err := u.w.Write([]byte("!"))
+	return err
+}
+
+func TestServerRunsPluginPhasesByPriority(t *testing.T) {
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Server", "upstream")
+		w.Header().Set("X-Order", strings.Join(r.Header.Values("X-Order"), ", "))
+		w.Write([]byte("hello"))
+	}))
+	defer api.Close()
+
+	order := func(name string) plugin.Middleware {
+		return func(p kong.Plugin, f nethttp.HandlerFunc) nethttp.HandlerFunc {
+			return func(w nethttp.ResponseWriter, r *nethttp.Request) {
+				r.Header.Add("X-Order", name+":"+p.Input["level"].(string))
+				f(w, r)
+			}
+		}
+	}
+
+	var logged []plugin.LogEntry
+	plugin.RegisterPlugin("phase_low", plugin.Definition{Priority: 10, Access: order("low")})
+	plugin.RegisterPlugin("phase_high", plugin.Definition{Priority: 20, Access: order("high")})
+	plugin.RegisterPlugin("response_transformer", plugin.Definition{HeaderFilter: plugin.ResponseTransformer})
+	plugin.RegisterPlugin("phase_upper", plugin.Definition{
+		BodyFilter: func(_ kong.Plugin, _ *nethttp.Request, _ *plugin.ResponseHead, w io.Writer) io.WriteCloser {
+			return upperFilter{w}
+		},
+	})
+	plugin.RegisterPlugin("phase_log", plugin.Definition{
+		Log: func(_ kong.Plugin, _ *nethttp.Request, entry plugin.LogEntry) { logged = append(logged, entry) },
+	})
+
+	c := routingConfig(t, `
+plugins:
+- name: phase_log
+- name: phase_low
+  input:
+    level: global
+- name: phase_high
+  input:
+    level: global
+services:
+- name: api
+  url: `+api.URL+`
+  plugins:
+  - name: phase_low
+    input:
+      level: service
+  routes:
+  - name: plain
+    paths:
+    - /plain
+    methods:
+    - GET
+  - name: transformed
+    paths:
+    - /transformed
+    methods:
+    - GET
+    plugins:
+    - name: phase_low
+      input:
+        level: route
+    - name: phase_upper
+    - name: response_transformer
+      input:
+        remove:
+          headers:
+          - Server
+        add:
+          headers:
+            X-Transformed: "yes"
+`)
+	s := http.NewServer(c)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/plain", nil))
+
+	// a maior prioridade roda primeiro e o plugin do serviço substitui o global
+	if w.Header().Get("X-Order") != "high:global, low:service" {
+		t.Errorf("expected X-Order to be high:global, low:service got %v", w.Header().Get("X-Order"))
+	}
+
+	if w.Body.String() != "hello" || w.Header().Get("Server") != "upstream" {
+		t.Errorf("expected untouched response got %v with Server %v", w.Body.String(), w.Header().Get("Server"))
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/transformed", nil))
+
+	if w.Header().Get("X-Order") != "high:global, low:route" {
+		t.Errorf("expected X-Order to be high:global, low:route got %v", w.Header().Get("X-Order"))
+	}
+
+	if w.Header().Get("Server") != "" || w.Header().Get("X-Transformed") != "yes" {
+		t.Errorf("expected response headers to be transformed got %v", w.Header())
+	}
+
+	if w.Body.String() != "HELLO!" {
+		t.Errorf("expected body to be HELLO! got %v", w.Body.String())
+	}
+
+	if len(logged) != 2 {
+		t.Fatalf("expected 2 log entries got %v", len(logged))
+	}
+
+	if logged[1].Status != nethttp.StatusOK || logged[1].Bytes != int64(len("HELLO!")) {
+		t.Errorf("expected log entry with status 200 and 6 bytes got %+v", logged[1])
+	}
+}
+
+func TestServerLogPhaseRunsWhenAccessRejects(t *testing.T) {
+	var status int
+	plugin.RegisterPlugin("phase_reject", plugin.Definition{
+		Priority: 1000,
+		Access: func(_ kong.Plugin, _ nethttp.HandlerFunc) nethttp.HandlerFunc {
+			return func(w nethttp.ResponseWriter, _ *nethttp.Request) {
+				w.WriteHeader(nethttp.StatusForbidden)
+			}
+		},
+	})
+	plugin.RegisterPlugin("phase_status_log", plugin.Definition{
+		Log: func(_ kong.Plugin, _ *nethttp.Request, entry plugin.LogEntry) { status = entry.Status },
+	})
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:1
+  plugins:
+  - name: phase_status_log
+  - name: phase_reject
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+
+	w := httptest.NewRecorder()
+	http.NewServer(c).ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+
+	if w.Code != nethttp.StatusForbidden || status != nethttp.StatusForbidden {
+		t.Errorf("expected status 403 in response and log got %v and %v", w.Code, status)
+	}
+}
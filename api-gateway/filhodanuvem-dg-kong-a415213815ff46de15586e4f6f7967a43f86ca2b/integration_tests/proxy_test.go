@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/http"
+)
+
+func newProxyConfig(url string) *kong.Config {
+	return &kong.Config{
+		Services: []kong.Service{
+			{
+				Name: "upstream",
+				URL:  url,
+				Routes: []kong.Route{
+					{
+						Name:       "everything",
+						Paths:      []string{"/{path}"},
+						PathRegexp: regexp.MustCompile(`^/[a-z]+$`),
+						Methods:    []string{nethttp.MethodGet},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestProxyCopiesAllHeaderValuesAndStripsHopByHop(t *testing.T) {
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get("X-Internal") != "" || r.Header.Get("Keep-Alive") != "" {
+			t.Errorf("expected hop-by-hop headers to be removed from request got %v", r.Header)
+		}
+
+		if r.Header.Get("X-Forwarded-For") != "10.0.0.1, 192.0.2.1" {
+			t.Errorf("expected X-Forwarded-For to be appended got %v", r.Header.Get("X-Forwarded-For"))
+		}
+
+		if r.Header.Get("X-Forwarded-Proto") != "http" || r.Header.Get("X-Forwarded-Host") != "gateway.local" {
+			t.Errorf("expected X-Forwarded-Proto and X-Forwarded-Host to be set got %v", r.Header)
+		}
+
+		if r.URL.RawQuery != "page=2" {
+			t.Errorf("expected query string to be forwarded got %v", r.URL.RawQuery)
+		}
+
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "secret")
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	s := http.NewServer(newProxyConfig(api.URL))
+
+	req := httptest.NewRequest(nethttp.MethodGet, "http://gateway.local/cookies?page=2", nil)
+	req.RemoteAddr = "192.0.2.1:4321"
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	req.Header.Set("Connection", "X-Internal")
+	req.Header.Set("X-Internal", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, req)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	if cookies := res.Header.Values("Set-Cookie"); len(cookies) != 2 {
+		t.Errorf("expected 2 Set-Cookie headers got %v", cookies)
+	}
+
+	if res.Header.Get("X-Upstream-Hop") != "" || res.Header.Get("Connection") != "" {
+		t.Errorf("expected hop-by-hop headers to be removed from response got %v", res.Header)
+	}
+}
+
+func TestProxyPassesRedirectsThrough(t *testing.T) {
+	var requests []string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		switch r.URL.Path {
+		case "/old":
+			nethttp.Redirect(w, r, "/new", nethttp.StatusMovedPermanently)
+		case "/form":
+			nethttp.Redirect(w, r, "/done", nethttp.StatusFound)
+		default:
+			fmt.Fprintf(w, "followed %s", r.URL.Path)
+		}
+	}))
+	defer api.Close()
+
+	s := http.NewServer(routingConfig(t, `
+services:
+- name: upstream
+  url: `+api.URL+`
+  routes:
+  - name: everything
+    paths:
+    - /{path}
+    methods:
+    - GET
+    - POST
+`))
+
+	tests := []struct {
+		method   string
+		path     string
+		status   int
+		location string
+	}{
+		{nethttp.MethodGet, "/old", nethttp.StatusMovedPermanently, "/new"},
+		{nethttp.MethodPost, "/form", nethttp.StatusFound, "/done"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader("a=1")))
+
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("expected %s %s to return %d with Location %s got %d %q: %s", test.method, test.path, test.status, test.location, w.Code, w.Header().Get("Location"), w.Body.String())
+		}
+	}
+
+	expected := []string{"GET /old", "POST /form"}
+	if strings.Join(requests, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected the gateway not to follow redirects, upstream requests %v got %v", expected, requests)
+	}
+}
+
+func TestProxyStreamsResponseBeforeUpstreamFinishes(t *testing.T) {
+	release := make(chan struct{})
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(nethttp.StatusOK)
+		fmt.Fprint(w, "data: first\n\n")
+		w.(nethttp.Flusher).Flush()
+
+		<-release
+		fmt.Fprint(w, "data: second\n\n")
+	}))
+	defer api.Close()
+	defer close(release)
+
+	gateway := httptest.NewServer(http.NewServer(newProxyConfig(api.URL)))
+	defer gateway.Close()
+
+	res, err := nethttp.Get(gateway.URL + "/events")
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(res.Body).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		if line != "data: first\n" {
+			t.Errorf("expected first event got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected first event to be streamed before upstream finished")
+	}
+}
+
+func TestProxyUpgradesWebSocketConnection(t *testing.T) {
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Header.Get("Upgrade") != "websocket" {
+			w.WriteHeader(nethttp.StatusBadRequest)
+			return
+		}
+
+		conn, brw, err := w.(nethttp.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("could not hijack upstream connection: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		brw.Flush()
+
+		// servidor de eco: devolve a primeira linha recebida
+		line, _ := brw.ReadString('\n')
+		brw.WriteString("echo " + line)
+		brw.Flush()
+	}))
+	defer api.Close()
+
+	gateway := httptest.NewServer(http.NewServer(newProxyConfig(api.URL)))
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatalf("could not connect to gateway: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	fmt.Fprint(conn, "GET /socket HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	res, err := nethttp.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("could not read upgrade response: %s", err)
+	}
+
+	if res.StatusCode != nethttp.StatusSwitchingProtocols {
+		t.Fatalf("expected status code 101 got %v", res.StatusCode)
+	}
+
+	fmt.Fprint(conn, "ping\n")
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatalf("could not read echo: %s", err)
+	}
+
+	if line != "echo ping\n" {
+		t.Errorf("expected echo ping got %q", line)
+	}
+}
@@ -0,0 +1,293 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	proxy "github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestPluginRateLimitFixedWindowBlocksAfterLimit(t *testing.T) {
+	// store próprio para o teste não dividir contadores com os outros
+	plugin.RegisterRateLimitStore("fixed_window_test", plugin.NewMemoryRateLimitStore())
+
+	p := kong.Plugin{
+		Name: "rate_limiting",
+		Input: map[string]any{
+			"policy": plugin.PolicyFixedWindow,
+			"hour":   2,
+			"store":  "fixed_window_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	f = plugin.RateLimit(p, f)
+
+	expected := []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	}
+
+	for i, e := range expected {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+
+		f(w, r)
+
+		if w.Code != e.status {
+			t.Errorf("request %d: expected status code %d got %v", i, e.status, w.Code)
+		}
+
+		if w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Errorf("request %d: expected X-RateLimit-Limit to be 2 got %v", i, w.Header().Get("X-RateLimit-Limit"))
+		}
+
+		if w.Header().Get("X-RateLimit-Remaining") != e.remaining {
+			t.Errorf("request %d: expected X-RateLimit-Remaining to be %s got %v", i, e.remaining, w.Header().Get("X-RateLimit-Remaining"))
+		}
+
+		if w.Header().Get("X-RateLimit-Reset") == "" {
+			t.Errorf("request %d: expected X-RateLimit-Reset to be set", i)
+		}
+	}
+
+	// outro IP tem seu próprio contador
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	w := httptest.NewRecorder()
+
+	f(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected another client to get status code 200 got %v", w.Code)
+	}
+}
+
+func TestPluginRateLimitByHeader(t *testing.T) {
+	plugin.RegisterRateLimitStore("header_test", plugin.NewMemoryRateLimitStore())
+
+	p := kong.Plugin{
+		Name: "rate_limiting",
+		Input: map[string]any{
+			"minute":      1,
+			"limit_by":    "header",
+			"header_name": "X-Client-Id",
+			"store":       "header_test",
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	f = plugin.RateLimit(p, f)
+
+	for i, client := range []string{"a", "b"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Client-Id", client)
+		w := httptest.NewRecorder()
+
+		f(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("request %d: expected status code 200 got %v", i, w.Code)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Client-Id", "a")
+	w := httptest.NewRecorder()
+
+	f(w, r)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status code 429 got %v", w.Code)
+	}
+}
+
+func TestPluginRateLimitByJWTClaim(t *testing.T) {
+	plugin.RegisterRateLimitStore("jwt_claim_test", plugin.NewMemoryRateLimitStore())
+
+	p := kong.Plugin{
+		Name: "rate_limiting",
+		Input: map[string]any{
+			"minute":     1,
+			"limit_by":   "jwt_claim",
+			"claim_name": "sub",
+			"store":      "jwt_claim_test",
+		},
+	}
+
+	jwtAuth := kong.Plugin{
+		Name: "jwt_auth",
+		Input: map[string]any{
+			"secret":        "secret",
+			"key_in_header": true,
+			"key_name":      "Authorization",
+		},
+	}
+
+	f := func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// a claim vem do jwt_auth, que roda antes do rate_limiting
+	authenticated := plugin.JWTAuth(jwtAuth, plugin.RateLimit(p, f))
+	unauthenticated := plugin.RateLimit(p, f)
+
+	tokenFor := func(sub, secret string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub}, nil)
+		tokenString, err := token.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatalf("could not sign jwt token: %s", err)
+		}
+		return tokenString
+	}
+
+	expected := []struct {
+		handler    http.HandlerFunc
+		sub        string
+		secret     string
+		remoteAddr string
+		status     int
+	}{
+		{authenticated, "alice", "secret", "10.0.0.1:1234", http.StatusOK},
+		{authenticated, "bob", "secret", "10.0.0.1:1234", http.StatusOK},
+		{authenticated, "alice", "secret", "10.0.0.2:1234", http.StatusTooManyRequests},
+		// um token forjado não passa pelo jwt_auth
+		{authenticated, "mallory", "forged", "10.0.0.3:1234", http.StatusUnauthorized},
+		// sem o jwt_auth a claim não é lida do token, o limite é pelo IP
+		{unauthenticated, "carol", "forged", "10.0.0.3:1234", http.StatusOK},
+		{unauthenticated, "dave", "forged", "10.0.0.3:1234", http.StatusTooManyRequests},
+	}
+
+	for i, e := range expected {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = e.remoteAddr
+		r.Header.Set("Authorization", "Bearer "+tokenFor(e.sub, e.secret))
+		w := httptest.NewRecorder()
+
+		e.handler(w, r)
+
+		if w.Code != e.status {
+			t.Errorf("request %d: expected status code %d got %v", i, e.status, w.Code)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreTokenBucketRefills(t *testing.T) {
+	store := plugin.NewMemoryRateLimitStore()
+	period := 50 * time.Millisecond
+
+	for i := 0; i < 2; i++ {
+		if _, allowed, _ := store.TakeToken("bucket", 2, period); !allowed {
+			t.Fatalf("expected token %d to be allowed", i)
+		}
+	}
+
+	if _, allowed, _ := store.TakeToken("bucket", 2, period); allowed {
+		t.Fatalf("expected bucket to be empty")
+	}
+
+	time.Sleep(period)
+
+	if _, allowed, _ := store.TakeToken("bucket", 2, period); !allowed {
+		t.Errorf("expected bucket to be refilled after %s", period)
+	}
+}
+
+func TestPluginRateLimitSeparatesRoutesOfTheSameService(t *testing.T) {
+	plugin.RegisterRateLimitStore("routes_test", plugin.NewMemoryRateLimitStore())
+	plugin.RegisterPlugin("rate_limiting", plugin.RateLimitPlugin)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: `+api.URL+`
+  routes:
+  - name: a
+    paths:
+    - /a
+    methods:
+    - GET
+    plugins:
+    - name: rate_limiting
+      input:
+        minute: 1
+        store: routes_test
+  - name: b
+    paths:
+    - /b
+    methods:
+    - GET
+    plugins:
+    - name: rate_limiting
+      input:
+        minute: 1000
+        store: routes_test
+- name: other
+  url: `+api.URL+`
+  plugins:
+  - name: rate_limiting
+    input:
+      minute: 2
+      store: routes_test
+  routes:
+  - name: c
+    paths:
+    - /c
+    methods:
+    - GET
+  - name: d
+    paths:
+    - /d
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := proxy.NewServer(c)
+
+	expected := []struct {
+		path      string
+		status    int
+		remaining string
+	}{
+		{"/b", http.StatusOK, "999"},
+		{"/b", http.StatusOK, "998"},
+		{"/a", http.StatusOK, "0"},
+		{"/a", http.StatusTooManyRequests, "0"},
+		{"/b", http.StatusOK, "997"},
+		// o limite do serviço vale para todas as rotas dele
+		{"/c", http.StatusOK, "1"},
+		{"/d", http.StatusOK, "0"},
+		{"/c", http.StatusTooManyRequests, "0"},
+	}
+
+	for i, e := range expected {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, e.path, nil))
+
+		if w.Code != e.status || w.Header().Get("X-RateLimit-Remaining") != e.remaining {
+			t.Errorf("request %d to %s: expected status code %d with %s remaining got %v with %s", i, e.path, e.status, e.remaining, w.Code, w.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+}
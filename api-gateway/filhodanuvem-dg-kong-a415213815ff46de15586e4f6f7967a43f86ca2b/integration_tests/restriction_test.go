@@ -0,0 +1,210 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+func TestIPRestrictionWithTrustedProxies(t *testing.T) {
+	plugin.RegisterPlugin("ip_restriction", plugin.IPRestrictionPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: ip_restriction
+    input:
+      allow:
+      - 10.0.0.0/8
+      - 2001:db8::/32
+      deny:
+      - 10.0.0.66
+      trusted_proxies:
+      - 192.0.2.0/24
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	cases := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor []string
+		expectedCode int
+	}{
+		{"allowed client", "10.1.2.3:1234", nil, nethttp.StatusOK},
+		{"allowed ipv6 client", "[2001:db8::1]:1234", nil, nethttp.StatusOK},
+		{"client outside allow", "172.16.0.1:1234", nil, nethttp.StatusForbidden},
+		{"denied client", "10.0.0.66:1234", nil, nethttp.StatusForbidden},
+		{"forwarded by trusted proxy", "192.0.2.10:1234", []string{"10.1.2.3"}, nethttp.StatusOK},
+		{"forwarded through trusted proxies", "192.0.2.10:1234", []string{"10.1.2.3, 192.0.2.20"}, nethttp.StatusOK},
+		{"forwarded denied client", "192.0.2.10:1234", []string{"10.0.0.66", "192.0.2.20"}, nethttp.StatusForbidden},
+		{"spoofed header behind trusted proxy", "192.0.2.10:1234", []string{"10.1.2.3, 172.16.0.1"}, nethttp.StatusForbidden},
+		{"header from untrusted client", "172.16.0.1:1234", []string{"10.1.2.3"}, nethttp.StatusForbidden},
+		{"only trusted proxies", "192.0.2.10:1234", nil, nethttp.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(nethttp.MethodGet, "/payments", nil)
+		r.RemoteAddr = tc.remoteAddr
+		for _, value := range tc.forwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.expectedCode {
+			t.Errorf("%s: expected status code %d got %v", tc.name, tc.expectedCode, w.Code)
+		}
+	}
+}
+
+func TestIPRestrictionRejectsInvalidInput(t *testing.T) {
+	plugin.RegisterPlugin("ip_restriction", plugin.IPRestrictionPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: http://localhost:8081
+  plugins:
+  - name: ip_restriction
+    input:
+      allow:
+      - 10.0.0.0/33
+      trusted_proxies:
+      - proxy.local
+  - name: ip_restriction
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	err := config.Validate(c)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	for _, expected := range []string{
+		`allow: invalid ip or cidr "10.0.0.0/33"`,
+		`trusted_proxies: invalid ip or cidr "proxy.local"`,
+		"at least one of allow or deny is required",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
+
+func TestBotDetection(t *testing.T) {
+	plugin.RegisterPlugin("bot_detection", plugin.BotDetectionPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: bot_detection
+    input:
+      allow:
+      - ^internal-scanner/nikto
+      deny:
+      - (?i)^curl/
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+- name: shippings
+  url: `+api.URL+`
+  plugins:
+  - name: bot_detection
+    input:
+      known_bots: false
+      deny:
+      - ^$
+  routes:
+  - paths:
+    - /shippings
+    methods:
+    - GET
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	cases := []struct {
+		path         string
+		userAgent    string
+		expectedCode int
+	}{
+		{"/payments", "Mozilla/5.0 (X11; Linux x86_64) Firefox/118.0", nethttp.StatusOK},
+		{"/payments", "curl/8.4.0", nethttp.StatusForbidden},
+		{"/payments", "sqlmap/1.7 (https://sqlmap.org)", nethttp.StatusForbidden},
+		{"/payments", "Mozilla/5.0 (compatible; SemrushBot/7~bl)", nethttp.StatusForbidden},
+		{"/payments", "internal-scanner/nikto 2.5", nethttp.StatusOK},
+		{"/shippings", "sqlmap/1.7 (https://sqlmap.org)", nethttp.StatusOK},
+		{"/shippings", "", nethttp.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		r := httptest.NewRequest(nethttp.MethodGet, tc.path, nil)
+		r.Header.Set("User-Agent", tc.userAgent)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != tc.expectedCode {
+			t.Errorf("%s with %q: expected status code %d got %v", tc.path, tc.userAgent, tc.expectedCode, w.Code)
+		}
+	}
+}
+
+func TestBotDetectionRejectsInvalidRegex(t *testing.T) {
+	plugin.RegisterPlugin("bot_detection", plugin.BotDetectionPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: http://localhost:8081
+  plugins:
+  - name: bot_detection
+    input:
+      deny:
+      - "bot("
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - GET
+`)
+
+	err := config.Validate(c)
+	if err == nil || !strings.Contains(err.Error(), `deny: invalid regex "bot("`) {
+		t.Errorf("expected invalid regex error got %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+func routingConfig(t testing.TB, yaml string) *kong.Config {
+	t.Helper()
+
+	c := &kong.Config{}
+	if err := c.Refresh([]byte(yaml), time.Now()); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return c
+}
+
+func TestRoutingPrefixExactAndPriority(t *testing.T) {
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:3001
+  routes:
+  - name: everything
+    match: prefix
+    paths:
+    - /api
+    methods:
+    - GET
+  - name: exact-orders
+    paths:
+    - /api/orders
+    - /api/v1/orders
+    methods:
+    - GET
+  - name: order-by-id
+    paths:
+    - /api/orders/{id:uuid}
+    methods:
+    - GET
+  - name: important
+    priority: 10
+    match: prefix
+    paths:
+    - /api/admin
+    methods:
+    - GET
+  - name: admin-users
+    paths:
+    - /api/admin/users
+    methods:
+    - GET
+`)
+
+	requests := map[string]string{
+		"/api":               "everything",
+		"/api/anything/else": "everything",
+		"/api/orders":        "exact-orders",
+		"/api/v1/orders":     "exact-orders",
+		"/api/orders/4c3f9a8e-2d1b-4e6f-9a7c-0b1d2e3f4a5b": "order-by-id",
+		"/api/orders/not-a-uuid":                           "everything",
+		"/api/admin/users":                                 "important",
+		"/apiary":                                          "",
+	}
+
+	for path, expected := range requests {
+		t.Run(path, func(t *testing.T) {
+			match := c.Match(httptest.NewRequest(nethttp.MethodGet, path, nil))
+
+			if expected == "" {
+				if match != nil {
+					t.Errorf("expected no route got %v", match.Route.Name)
+				}
+				return
+			}
+
+			if match == nil || match.Route.Name != expected {
+				t.Errorf("expected route %v got %+v", expected, match)
+			}
+		})
+	}
+}
+
+func TestRoutingHostsAndHeaders(t *testing.T) {
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:3001
+  routes:
+  - name: default
+    paths:
+    - /orders
+    methods:
+    - GET
+  - name: tenant
+    hosts:
+    - "*.tenants.example.com"
+    paths:
+    - /orders
+    methods:
+    - GET
+  - name: beta
+    headers:
+      X-Version:
+      - beta
+      - canary
+    paths:
+    - /orders
+    methods:
+    - GET
+`)
+
+	tests := []struct {
+		host     string
+		version  string
+		expected string
+	}{
+		{"example.com", "", "default"},
+		{"acme.tenants.example.com:8080", "", "tenant"},
+		{"tenants.example.com", "", "default"},
+		{"example.com", "canary", "beta"},
+		{"example.com", "stable", "default"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(nethttp.MethodGet, "/orders", nil)
+		r.Host = test.host
+		if test.version != "" {
+			r.Header.Set("X-Version", test.version)
+		}
+
+		match := c.Match(r)
+		if match == nil || match.Route.Name != test.expected {
+			t.Errorf("%s with version %q: expected route %v got %+v", test.host, test.version, test.expected, match)
+		}
+	}
+}
+
+func TestRoutingStripAndRewritePath(t *testing.T) {
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:3001
+  routes:
+  - name: strip
+    match: prefix
+    strip_path: true
+    paths:
+    - /shop
+    methods:
+    - GET
+  - name: rewrite
+    rewrite_path: /v2/payments/{id}
+    paths:
+    - /payments/{id:int}
+    methods:
+    - GET
+  - name: rewrite-prefix
+    match: prefix
+    rewrite_path: /internal/{tenant}
+    paths:
+    - /tenants/{tenant:slug}
+    methods:
+    - GET
+`)
+
+	requests := map[string]string{
+		"/shop":                       "/",
+		"/shop/cart/1":                "/cart/1",
+		"/payments/42":                "/v2/payments/42",
+		"/tenants/acme-inc/orders/10": "/internal/acme-inc/orders/10",
+	}
+
+	for path, expected := range requests {
+		match := c.Match(httptest.NewRequest(nethttp.MethodGet, path, nil))
+		if match == nil || match.UpstreamPath != expected {
+			t.Errorf("%s: expected upstream path %v got %+v", path, expected, match)
+		}
+	}
+
+	match := c.Match(httptest.NewRequest(nethttp.MethodGet, "/payments/42", nil))
+	if match.Params["id"] != "42" {
+		t.Errorf("expected param id to be 42 got %v", match.Params)
+	}
+}
+
+func BenchmarkRoutingThousandsOfRoutes(b *testing.B) {
+	yaml := "services:\n"
+	for i := 0; i < 100; i++ {
+		yaml += fmt.Sprintf("- name: service-%d\n  url: http://localhost:3001\n  routes:\n", i)
+		for j := 0; j < 50; j++ {
+			yaml += fmt.Sprintf("  - name: route-%d\n    paths:\n    - /service-%d/resource-%d/{id:int}\n    methods:\n    - GET\n", j, i, j)
+		}
+	}
+
+	c := routingConfig(b, yaml)
+	r := httptest.NewRequest(nethttp.MethodGet, "/service-99/resource-49/10", nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if c.Match(r) == nil {
+			b.Fatal("expected route to match")
+		}
+	}
+}
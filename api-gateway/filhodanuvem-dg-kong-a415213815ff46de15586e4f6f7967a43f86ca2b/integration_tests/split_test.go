@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+)
+
+// versionServer responde com o nome da versão, para o teste saber qual serviço atendeu.
+func versionServer(version string) *httptest.Server {
+	return httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.URL.Query().Has("slow") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		io.WriteString(w, version)
+	}))
+}
+
+func splitConfig(t *testing.T, v1, v2 string, weight int) *kong.Config {
+	t.Helper()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+v1+`
+  routes:
+  - name: payments
+    paths:
+    - /payments
+    methods:
+    - GET
+    split:
+      sticky_cookie: payments_canary
+      destinations:
+      - service: payments-v2
+        headers:
+          X-Canary:
+          - "true"
+      - service: payments-v2
+        cookies:
+          beta:
+          - "1"
+      - service: payments-v2
+        weight: `+strconv.Itoa(weight)+`
+- name: payments-v2
+  url: `+v2+`
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	return c
+}
+
+func TestTrafficSplitByHeaderCookieAndWeight(t *testing.T) {
+	v1, v2 := versionServer("v1"), versionServer("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	s := http.NewServer(splitConfig(t, v1.URL, v2.URL, 30))
+
+	send := func(prepare func(r *nethttp.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(nethttp.MethodGet, "/payments", nil)
+		if prepare != nil {
+			prepare(r)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := send(func(r *nethttp.Request) { r.Header.Set("X-Canary", "true") })
+	if w.Body.String() != "v2" {
+		t.Errorf("expected header match to go to v2 got %v", w.Body.String())
+	}
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("expected no sticky cookie for a header match got %v", w.Header().Get("Set-Cookie"))
+	}
+
+	w = send(func(r *nethttp.Request) { r.AddCookie(&nethttp.Cookie{Name: "beta", Value: "1"}) })
+	if w.Body.String() != "v2" {
+		t.Errorf("expected cookie match to go to v2 got %v", w.Body.String())
+	}
+
+	// sem o cookie o gateway sorteia e devolve o cookie para o cliente ficar no mesmo destino
+	w = send(nil)
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "payments_canary" || cookies[0].Value == "" {
+		t.Fatalf("expected sticky cookie got %v", cookies)
+	}
+
+	first := w.Body.String()
+	for i := 0; i < 20; i++ {
+		w = send(func(r *nethttp.Request) { r.AddCookie(cookies[0]) })
+		if w.Body.String() != first {
+			t.Fatalf("expected sticky cookie to keep %v got %v", first, w.Body.String())
+		}
+		if w.Header().Get("Set-Cookie") != "" {
+			t.Fatalf("expected cookie not to be replaced got %v", w.Header().Get("Set-Cookie"))
+		}
+	}
+
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		w = send(func(r *nethttp.Request) {
+			r.AddCookie(&nethttp.Cookie{Name: "payments_canary", Value: "client-" + strconv.Itoa(i)})
+		})
+		if w.Body.String() == "v2" {
+			canary++
+		}
+	}
+
+	if canary < 230 || canary > 370 {
+		t.Errorf("expected around 30%% of the clients on v2 got %d of 1000", canary)
+	}
+}
+
+func TestTrafficSplitReloadKeepsInFlightRequests(t *testing.T) {
+	v1, v2 := versionServer("v1"), versionServer("v2")
+	defer v1.Close()
+	defer v2.Close()
+
+	holder := kong.NewConfigHolder(splitConfig(t, v1.URL, v2.URL, 0))
+	gateway := httptest.NewServer(http.NewServerFromHolder(holder))
+	defer gateway.Close()
+
+	done := make(chan string)
+	go func() {
+		res, err := nethttp.Get(gateway.URL + "/payments?slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(res.Body)
+		done <- strconv.Itoa(res.StatusCode) + " " + string(body)
+	}()
+
+	// o reload acontece com a request ainda no upstream
+	time.Sleep(50 * time.Millisecond)
+	holder.Store(splitConfig(t, v1.URL, v2.URL, 100))
+
+	if got := <-done; got != "200 v1" {
+		t.Errorf("expected in-flight request to finish on v1 got %v", got)
+	}
+
+	res, err := nethttp.Get(gateway.URL + "/payments")
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	if body, _ := io.ReadAll(res.Body); string(body) != "v2" {
+		t.Errorf("expected new weights after reload got %s", body)
+	}
+}
+
+func TestTrafficSplitValidation(t *testing.T) {
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: http://localhost:8081
+  routes:
+  - name: payments
+    paths:
+    - /payments
+    methods:
+    - GET
+    split:
+      sticky_cookie: "bad cookie"
+      destinations:
+      - service: payments-v3
+        weight: 60
+      - service: payments-v2
+        weight: 50
+      - service: payments-v2
+        weight: 10
+        headers:
+          X-Canary:
+          - "true"
+      - weight: 0
+- name: payments-v2
+  url: http://localhost:8082
+`)
+
+	err := config.Validate(c)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	for _, expected := range []string{
+		`route "payments": split: service "payments-v3" not found`,
+		"destinations[2]: weight can not be used with headers or cookies",
+		"destinations[3]: service is required",
+		"the sum of the weights can not be greater than 100, got 120",
+		`invalid sticky_cookie "bad cookie"`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+)
+
+type testCertificate struct {
+	certFile string
+	keyFile  string
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+}
+
+// newCertificate cria um certificado para hosts assinado por parent, ou uma CA quando parent é nil.
+func newCertificate(t *testing.T, name string, hosts []string, parent *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     hosts,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	dir := t.TempDir()
+	c := &testCertificate{
+		certFile: filepath.Join(dir, name+".pem"),
+		keyFile:  filepath.Join(dir, name+"-key.pem"),
+		cert:     cert,
+		key:      key,
+	}
+	os.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return c
+}
+
+func TestTLSListenerChoosesCertificateBySNI(t *testing.T) {
+	ca := newCertificate(t, "ca", nil, nil)
+	payments := newCertificate(t, "payments", []string{"payments.example.com"}, ca)
+	wildcard := newCertificate(t, "wildcard", []string{"*.example.org"}, ca)
+	fallback := newCertificate(t, "fallback", []string{"localhost"}, ca)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.Header.Get("X-Forwarded-Proto")))
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+tls:
+  redirect_http: true
+  https_port: 8443
+  certificates:
+  - hosts:
+    - payments.example.com
+    cert_file: `+payments.certFile+`
+    key_file: `+payments.keyFile+`
+  - hosts:
+    - "*.example.org"
+    cert_file: `+wildcard.certFile+`
+    key_file: `+wildcard.keyFile+`
+  - cert_file: `+fallback.certFile+`
+    key_file: `+fallback.keyFile+`
+services:
+- name: api
+  url: `+api.URL+`
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+	s := http.NewServer(c)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", s.TLSConfig())
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	server := &nethttp.Server{Handler: s}
+	go server.Serve(listener)
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	tests := map[string]*testCertificate{
+		"payments.example.com": payments,
+		"api.example.org":      wildcard,
+		"unknown.example.net":  fallback,
+	}
+
+	for serverName, expected := range tests {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: serverName, RootCAs: roots, InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("%s: expected error to be nil got %v", serverName, err)
+		}
+
+		if got := conn.ConnectionState().PeerCertificates[0]; !got.Equal(expected.cert) {
+			t.Errorf("%s: expected certificate %s got %s", serverName, expected.cert.Subject.CommonName, got.Subject.CommonName)
+		}
+		conn.Close()
+	}
+
+	client := &nethttp.Client{Transport: &nethttp.Transport{TLSClientConfig: &tls.Config{ServerName: "payments.example.com", RootCAs: roots}}}
+	res, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	defer res.Body.Close()
+
+	body := make([]byte, 5)
+	n, _ := res.Body.Read(body)
+	if res.StatusCode != nethttp.StatusOK || string(body[:n]) != "https" {
+		t.Errorf("expected status code 200 with X-Forwarded-Proto https got %v %q", res.StatusCode, body[:n])
+	}
+
+	// sem TLS, a request é redirecionada para o listener HTTPS
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodPost, "http://payments.example.com/orders?page=2", nil))
+	if w.Code != nethttp.StatusPermanentRedirect || w.Header().Get("Location") != "https://payments.example.com:8443/orders?page=2" {
+		t.Errorf("expected redirect 308 to https got %v %v", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestForwardClientUsesUpstreamMutualTLS(t *testing.T) {
+	ca := newCertificate(t, "ca", nil, nil)
+	client := newCertificate(t, "gateway", []string{"gateway"}, ca)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	api := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	api.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	api.StartTLS()
+	defer api.Close()
+
+	// o certificado do httptest vira o bundle de CAs do upstream
+	bundle := filepath.Join(t.TempDir(), "upstream-ca.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: api.Certificate().Raw}), 0o600)
+
+	service := func(tlsConfig string) string {
+		return `
+services:
+- name: secure
+  url: ` + api.URL + `
+  tls:` + tlsConfig + `
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`
+	}
+
+	c := routingConfig(t, service(`
+    ca_cert_file: `+bundle+`
+    client_cert_file: `+client.certFile+`
+    client_key_file: `+client.keyFile))
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	http.NewServer(c).ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+	if w.Code != nethttp.StatusOK || w.Body.String() != "gateway" {
+		t.Errorf("expected upstream to see the gateway client certificate got %v %v", w.Code, w.Body.String())
+	}
+
+	// sem o certificado do cliente o upstream recusa a conexão
+	c = routingConfig(t, service(`
+    ca_cert_file: `+bundle))
+	w = httptest.NewRecorder()
+	http.NewServer(c).ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+	if w.Code != nethttp.StatusBadGateway {
+		t.Errorf("expected status code 502 got %v", w.Code)
+	}
+
+	// os arquivos são lidos quando a configuração é carregada
+	c = &kong.Config{}
+	if err := c.Refresh([]byte(service(`
+    ca_cert_file: /does/not/exist.pem`)), time.Now()); err == nil || !strings.Contains(err.Error(), "exist.pem") {
+		t.Errorf("expected error for missing ca_cert_file got %v", err)
+	}
+}
+
+func TestForwardClientReloadsUpstreamCertificates(t *testing.T) {
+	ca := newCertificate(t, "ca", nil, nil)
+	rogue := newCertificate(t, "rogue", nil, nil)
+	renewed := newCertificate(t, "gateway", []string{"gateway"}, ca)
+	expired := newCertificate(t, "gateway", []string{"gateway"}, rogue)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	api := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	api.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	api.Config.ErrorLog = log.New(io.Discard, "", 0)
+	api.StartTLS()
+	defer api.Close()
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "upstream-ca.pem")
+	certFile, keyFile := filepath.Join(dir, "gateway.pem"), filepath.Join(dir, "gateway-key.pem")
+	os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: api.Certificate().Raw}), 0o600)
+
+	install := func(c *testCertificate) {
+		for from, to := range map[string]string{c.certFile: certFile, c.keyFile: keyFile} {
+			data, _ := os.ReadFile(from)
+			if err := os.WriteFile(to, data, 0o600); err != nil {
+				t.Fatalf("expected error to be nil got %v", err)
+			}
+		}
+	}
+
+	load := func() *kong.Config {
+		return routingConfig(t, `
+services:
+- name: secure
+  url: `+api.URL+`
+  tls:
+    ca_cert_file: `+bundle+`
+    client_cert_file: `+certFile+`
+    client_key_file: `+keyFile+`
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+	}
+
+	install(expired)
+	holder := kong.NewConfigHolder(load())
+	s := http.NewServerFromHolder(holder)
+
+	get := func() int {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+		return w.Code
+	}
+
+	if code := get(); code != nethttp.StatusBadGateway {
+		t.Errorf("expected the upstream to refuse the old certificate got %v", code)
+	}
+
+	// o certificado renovado no mesmo arquivo vale depois do reload
+	install(renewed)
+	holder.Store(load())
+	if code := get(); code != nethttp.StatusOK {
+		t.Errorf("expected the renewed certificate to be used after the reload got %v", code)
+	}
+}
+
+func TestValidateRejectsRedirectWithoutCertificates(t *testing.T) {
+	c := routingConfig(t, `
+tls:
+  redirect_http: true
+services:
+- name: api
+  url: http://localhost:3000
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+
+	err := config.Validate(c)
+	if err == nil || !strings.Contains(err.Error(), "tls: redirect_http requires certificates") {
+		t.Errorf("expected redirect_http without certificates to be rejected got %v", err)
+	}
+}
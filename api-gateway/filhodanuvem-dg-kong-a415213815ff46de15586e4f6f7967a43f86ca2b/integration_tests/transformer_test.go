@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequestAndResponseTransformers(t *testing.T) {
+	var received *nethttp.Request
+	var body map[string]any
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		received = r
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Internal-Trace", "abc")
+		w.Write([]byte(`[{"id":1,"secret":"s1","total":1700000000},{"id":2,"secret":"s2"}]`))
+	}))
+	defer api.Close()
+
+	plugin.RegisterPlugin("jwt_auth", plugin.JWTAuthPlugin)
+	plugin.RegisterPlugin("request_transformer", plugin.RequestTransformerPlugin)
+	plugin.RegisterPlugin("response_transformer", plugin.ResponseTransformerPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: orders
+  url: `+api.URL+`
+  plugins:
+  - name: jwt_auth
+    input:
+      secret: th1s1ss3cr3t
+      key_in_header: true
+      key_name: Authorization
+  routes:
+  - name: update-order
+    paths:
+    - /orders/{id:int}
+    methods:
+    - PUT
+    plugins:
+    - name: request_transformer
+      input:
+        remove:
+          headers:
+          - X-Debug
+          querystring:
+          - debug
+          body:
+          - customer.internal
+        rename:
+          headers:
+            X-Old: X-New
+          querystring:
+            q: search
+          body:
+            note: comment
+        replace:
+          body:
+            status: pending
+        add:
+          headers:
+            X-Order-Id: $(path_params.id)
+          querystring:
+            user: $(claims.sub)
+          body:
+            customer.tenant: $(headers.X-Tenant)
+    - name: response_transformer
+      input:
+        remove:
+          headers:
+          - X-Internal-Trace
+          json:
+          - secret
+        add:
+          json:
+            order: $(path_params.id)
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	token := signToken(t, jwt.SigningMethodHS256, []byte("th1s1ss3cr3t"), "", jwt.MapClaims{"sub": "user-1"})
+	r := httptest.NewRequest(nethttp.MethodPut, "/orders/42?q=shoes&debug=1", strings.NewReader(`{"status":"paid","note":"hi","customer":{"internal":true,"name":"Ana"}}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Debug", "1")
+	r.Header.Set("X-Old", "value")
+	r.Header.Set("X-Tenant", "acme")
+	w := httptest.NewRecorder()
+
+	s.ServeHTTP(w, r)
+
+	if w.Code != nethttp.StatusOK {
+		t.Fatalf("expected status code 200 got %v", w.Code)
+	}
+
+	if received.Header.Get("X-Debug") != "" || received.Header.Get("X-Old") != "" || received.Header.Get("X-New") != "value" {
+		t.Errorf("expected headers to be removed and renamed got %v", received.Header)
+	}
+
+	if received.Header.Get("X-Order-Id") != "42" {
+		t.Errorf("expected X-Order-Id to be 42 got %v", received.Header.Get("X-Order-Id"))
+	}
+
+	if received.URL.RawQuery != "search=shoes&user=user-1" {
+		t.Errorf("expected query to be search=shoes&user=user-1 got %v", received.URL.RawQuery)
+	}
+
+	expectedBody := `{"comment":"hi","customer":{"name":"Ana","tenant":"acme"},"status":"pending"}`
+	if b, _ := json.Marshal(body); string(b) != expectedBody {
+		t.Errorf("expected body to be %v got %s", expectedBody, b)
+	}
+
+	if w.Header().Get("X-Internal-Trace") != "" {
+		t.Errorf("expected X-Internal-Trace to be removed got %v", w.Header().Get("X-Internal-Trace"))
+	}
+
+	expectedResponse := `[{"id":1,"order":"42","total":1700000000},{"id":2,"order":"42"}]`
+	if response, _ := io.ReadAll(w.Body); string(response) != expectedResponse {
+		t.Errorf("expected response to be %v got %s", expectedResponse, response)
+	}
+}
+
+func TestRequestTransformerRejectsInvalidJSONBody(t *testing.T) {
+	plugin.RegisterPlugin("request_transformer", plugin.RequestTransformerPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:1
+  plugins:
+  - name: request_transformer
+    input:
+      add:
+        body:
+          source: gateway
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - POST
+`)
+
+	r := httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader(`not json`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	http.NewServer(c).ServeHTTP(w, r)
+
+	if w.Code != nethttp.StatusBadRequest {
+		t.Errorf("expected status code 400 got %v", w.Code)
+	}
+}
+
+func TestValidateRejectsUnknownTemplateSource(t *testing.T) {
+	plugin.RegisterPlugin("request_transformer", plugin.RequestTransformerPlugin)
+
+	c := routingConfig(t, `
+plugins:
+- name: request_transformer
+  input:
+    add:
+      headers:
+        X-User: $(cookies.session)
+`)
+
+	err := config.Validate(c)
+	if err == nil || !strings.Contains(err.Error(), `invalid template "$(cookies.session)"`) {
+		t.Errorf("expected invalid template error got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+)
+
+func TestUpstreamRetriesIdempotentRequests(t *testing.T) {
+	var calls atomic.Int32
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(nethttp.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: api
+  url: `+api.URL+`
+  retries:
+    attempts: 2
+    status_codes:
+    - 503
+    backoff: 1
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+    - POST
+`)
+	s := http.NewServer(c)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/", nil))
+
+	if w.Code != nethttp.StatusOK || calls.Load() != 3 {
+		t.Errorf("expected status code 200 after 3 calls got %v after %v", w.Code, calls.Load())
+	}
+
+	// POST não é idempotente, então não é repetido
+	calls.Store(0)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodPost, "/", strings.NewReader("{}")))
+
+	if w.Code != nethttp.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("expected status code 503 after 1 call got %v after %v", w.Code, calls.Load())
+	}
+}
+
+func TestUpstreamErrorsMapToGatewayStatus(t *testing.T) {
+	slow := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(nethttp.StatusOK)
+	}))
+	defer slow.Close()
+
+	closed := httptest.NewServer(nil)
+	closed.Close()
+
+	c := routingConfig(t, `
+services:
+- name: slow
+  url: `+slow.URL+`
+  timeouts:
+    read: 50
+  routes:
+  - name: slow
+    paths:
+    - /slow
+    methods:
+    - GET
+- name: down
+  url: `+closed.URL+`
+  retries:
+    attempts: 1
+  routes:
+  - name: down
+    paths:
+    - /down
+    methods:
+    - GET
+`)
+	s := http.NewServer(c)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/slow", nil))
+	if w.Code != nethttp.StatusGatewayTimeout {
+		t.Errorf("expected status code 504 got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(nethttp.MethodGet, "/down", nil))
+	if w.Code != nethttp.StatusBadGateway {
+		t.Errorf("expected status code 502 got %v", w.Code)
+	}
+}
+
+func TestValidateRejectsInvalidUpstreamOptions(t *testing.T) {
+	c := routingConfig(t, `
+services:
+- name: api
+  url: http://localhost:3000
+  protocol: grpc
+  timeouts:
+    read: -1
+  retries:
+    status_codes:
+    - 700
+  routes:
+  - name: all
+    paths:
+    - /
+    methods:
+    - GET
+`)
+
+	err := config.Validate(c)
+	for _, expected := range []string{"timeouts.read can not be negative", "invalid status code 700", "requires retries.attempts", `invalid protocol "grpc"`} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
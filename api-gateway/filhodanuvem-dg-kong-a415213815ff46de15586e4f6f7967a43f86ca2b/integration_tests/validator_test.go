@@ -0,0 +1,293 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/devgymbr/kong/config"
+	"github.com/devgymbr/kong/http"
+	"github.com/devgymbr/kong/plugin"
+)
+
+type validationResponse struct {
+	Message string                    `json:"message"`
+	Errors  []plugin.RequestViolation `json:"errors"`
+}
+
+func TestRequestValidator(t *testing.T) {
+	plugin.RegisterPlugin("request_validator", plugin.RequestValidatorPlugin)
+
+	schemaFile := filepath.Join(t.TempDir(), "create-payment.json")
+	schema := `{
+	"type": "object",
+	"required": ["user_id", "amount", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"user_id": {"type": "string", "minLength": 3},
+		"amount": {"type": "number", "exclusiveMinimum": 0},
+		"currency": {"enum": ["BRL", "USD"]},
+		"email": {"type": "string", "format": "email"},
+		"items": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/item"}}
+	},
+	"$defs": {
+		"item": {
+			"type": "object",
+			"required": ["sku", "quantity"],
+			"properties": {
+				"sku": {"type": "string", "pattern": "^[A-Z]{3}-[0-9]+$"},
+				"quantity": {"type": "integer", "minimum": 1}
+			}
+		}
+	}
+}`
+	if err := os.WriteFile(schemaFile, []byte(schema), 0644); err != nil {
+		t.Fatalf("unable to write schema: %s", err)
+	}
+
+	var received string
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(nethttp.StatusCreated)
+	}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  plugins:
+  - name: request_validator
+    input:
+      body_schema_file: `+schemaFile+`
+      query_schema:
+        type: object
+        properties:
+          dry_run:
+            type: boolean
+          tags:
+            type: array
+            items:
+              type: string
+      header_schema:
+        type: object
+        required:
+        - X-Tenant
+        properties:
+          X-Tenant:
+            type: string
+          X-Retries:
+            type: integer
+            maximum: 3
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - POST
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	send := func(query, body string, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(nethttp.MethodPost, "/payments"+query, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("X-Tenant", "acme")
+		for name, value := range header {
+			if value == "" {
+				r.Header.Del(name)
+				continue
+			}
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		return w
+	}
+
+	valid := `{"user_id": "filhodanuvem", "amount": 17.5, "currency": "BRL", "items": [{"sku": "ABC-1", "quantity": 2}]}`
+	w := send("?dry_run=true&tags=a&tags=b", valid, map[string]string{"X-Retries": "2"})
+	if w.Code != nethttp.StatusCreated {
+		t.Fatalf("expected status code 201 got %v: %s", w.Code, w.Body.String())
+	}
+
+	if received != valid {
+		t.Errorf("expected upstream to receive the original body got %v", received)
+	}
+
+	invalid := `{"user_id": "fi", "amount": 0, "currency": "EUR", "email": "not an email", "items": [{"sku": "abc", "quantity": 1.5}], "coupon": "X"}`
+	w = send("?dry_run=maybe", invalid, map[string]string{"X-Tenant": "", "X-Retries": "5"})
+	if w.Code != nethttp.StatusBadRequest {
+		t.Fatalf("expected status code 400 got %v", w.Code)
+	}
+
+	if w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected json response got %v", w.Header().Get("Content-Type"))
+	}
+
+	var res validationResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("could not decode response: %s", err)
+	}
+
+	expected := []string{
+		"header X-Tenant: is required",
+		"header X-Retries: must be <= 3",
+		"query dry_run: must be of type boolean, got string",
+		"body amount: must be > 0",
+		"body coupon: is not allowed",
+		`body currency: must be one of ["BRL","USD"]`,
+		"body email: must be a valid email",
+		`body items[0].quantity: must be of type integer, got number`,
+		`body items[0].sku: must match pattern "^[A-Z]{3}-[0-9]+$"`,
+		"body user_id: must have at least 3 characters",
+	}
+
+	var got []string
+	for _, v := range res.Errors {
+		got = append(got, v.In+" "+v.Field+": "+v.Message)
+	}
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected violations\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	for _, tc := range []struct {
+		body     string
+		expected string
+	}{
+		{"", "body : is required"},
+		{"{", "body : must be valid json: unexpected EOF"},
+		{`[]`, "body : must be of type object, got array"},
+	} {
+		w = send("", tc.body, nil)
+		res = validationResponse{}
+		json.NewDecoder(w.Body).Decode(&res)
+
+		if w.Code != nethttp.StatusBadRequest || len(res.Errors) != 1 || res.Errors[0].In+" "+res.Errors[0].Field+": "+res.Errors[0].Message != tc.expected {
+			t.Errorf("expected %q got %v %+v", tc.expected, w.Code, res.Errors)
+		}
+	}
+}
+
+func TestRequestValidatorRejectsInvalidSchema(t *testing.T) {
+	plugin.RegisterPlugin("request_validator", plugin.RequestValidatorPlugin)
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: http://localhost:8081
+  plugins:
+  - name: request_validator
+    input:
+      body_schema:
+        type: object
+        properties:
+          amount:
+            type: money
+      query_schema:
+        if:
+          type: object
+      header_schema_file: /does/not/exist.json
+  - name: request_validator
+  routes:
+  - paths:
+    - /payments
+    methods:
+    - POST
+`)
+
+	err := config.Validate(c)
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+
+	for _, expected := range []string{
+		`body_schema: #/properties/amount: unknown type "money"`,
+		`query_schema: #: keyword "if" is not supported`,
+		"header_schema_file: open /does/not/exist.json",
+		"at least one of body_schema, query_schema or header_schema is required",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q got %v", expected, err)
+		}
+	}
+}
+
+func TestRequestValidatorWithRequestSizeLimit(t *testing.T) {
+	plugin.RegisterPlugin("request_validator", plugin.RequestValidatorPlugin)
+	plugin.RegisterPlugin("request_size_limiting", plugin.RequestSizeLimitPlugin)
+
+	api := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {}))
+	defer api.Close()
+
+	c := routingConfig(t, `
+services:
+- name: payments
+  url: `+api.URL+`
+  routes:
+  - name: create-payment
+    paths:
+    - /payments
+    methods:
+    - POST
+    plugins:
+    - name: request_validator
+      input:
+        body_schema:
+          type: object
+          required: [amount]
+    - name: request_size_limiting
+      input:
+        allowed_payload_size: 1024
+  - name: create-refund
+    paths:
+    - /refunds
+    methods:
+    - POST
+    plugins:
+    - name: request_validator
+      input:
+        max_body_size: 64
+        body_schema:
+          type: object
+          required: [amount]
+`)
+	if err := config.Validate(c); err != nil {
+		t.Fatalf("expected error to be nil got %v", err)
+	}
+	s := http.NewServer(c)
+
+	tests := []struct {
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"/payments", "application/json", `{"amount": 10}`, nethttp.StatusOK},
+		// o request_size_limiting responde antes de o request_validator ler o body
+		{"/payments", "text/plain", strings.Repeat("x", 2048), nethttp.StatusRequestEntityTooLarge},
+		{"/payments", "application/json", `{"amount": 10, "note": "` + strings.Repeat("x", 2048) + `"}`, nethttp.StatusRequestEntityTooLarge},
+		{"/refunds", "application/json", `{"amount": 10}`, nethttp.StatusOK},
+		{"/refunds", "application/json", `{"amount": 10, "note": "` + strings.Repeat("x", 64) + `"}`, nethttp.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(nethttp.MethodPost, test.path, strings.NewReader(test.body))
+		r.Header.Set("Content-Type", test.contentType)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("expected POST %s with %d bytes to return %d got %d: %s", test.path, len(test.body), test.status, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package kong
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/devgymbr/kong/routes"
+)
+
+// RouteMatch é o resultado do roteamento de uma request.
+type RouteMatch struct {
+	Service *Service
+	Route   *Route
+	Params  map[string]string
+	// UpstreamPath é o path que vai para o upstream, depois do strip_path e do rewrite_path.
+	UpstreamPath string
+	// Upstream é o serviço que recebe a request, diferente de Service quando a rota
+	// divide o tráfego. Vazio até o servidor escolher o destino.
+	Upstream *Service
+}
+
+type routeEntry struct {
+	service        int
+	route          int
+	staticSegments int
+	order          int
+}
+
+// Match encontra a rota da request. Quando mais de uma casa, a ordem é:
+// maior priority, exact antes de prefix, prefixo mais longo, mais segmentos fixos,
+// mais predicados (hosts/headers) e, por último, a ordem do config.yaml.
+func (c *Config) Match(r *http.Request) *RouteMatch {
+	if c.router == nil {
+		return c.matchUncompiled(r)
+	}
+
+	matches := c.router.Lookup(r.URL.Path)
+
+	var best *routes.Match[routeEntry]
+	for i := range matches {
+		m := &matches[i]
+		if !c.route(m.Value).matchesRequest(r) {
+			continue
+		}
+
+		if best == nil || c.better(m, best) {
+			best = m
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+
+	service := &c.Services[best.Value.service]
+	route := c.route(best.Value)
+
+	return &RouteMatch{
+		Service:      service,
+		Route:        route,
+		Params:       best.Params,
+		UpstreamPath: route.upstreamPath(r.URL.Path, best.Params, best.Depth),
+	}
+}
+
+// MatchPreflight encontra a rota da request anunciada num preflight de CORS, para
+// rotas que não declaram OPTIONS. Devolve nil quando r não é um preflight.
+func (c *Config) MatchPreflight(r *http.Request) *RouteMatch {
+	if !IsPreflight(r) {
+		return nil
+	}
+
+	announced := *r
+	announced.Method = r.Header.Get("Access-Control-Request-Method")
+
+	return c.Match(&announced)
+}
+
+// IsPreflight diz se a request é o OPTIONS que o browser envia antes de uma
+// request cross-origin.
+func IsPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+func (c *Config) route(entry routeEntry) *Route {
+	return &c.Services[entry.service].Routes[entry.route]
+}
+
+func (c *Config) better(a, b *routes.Match[routeEntry]) bool {
+	routeA, routeB := c.route(a.Value), c.route(b.Value)
+
+	switch {
+	case routeA.Priority != routeB.Priority:
+		return routeA.Priority > routeB.Priority
+	case a.Exact != b.Exact:
+		return a.Exact
+	case a.Depth != b.Depth:
+		return a.Depth > b.Depth
+	case a.Value.staticSegments != b.Value.staticSegments:
+		return a.Value.staticSegments > b.Value.staticSegments
+	case routeA.predicates() != routeB.predicates():
+		return routeA.predicates() > routeB.predicates()
+	}
+
+	return a.Value.order < b.Value.order
+}
+
+// matchUncompiled percorre as rotas uma a uma usando o PathRegexp, para
+// configurações que foram montadas em código e nunca passaram pelo Compile.
+func (c *Config) matchUncompiled(r *http.Request) *RouteMatch {
+	for i := range c.Services {
+		service := &c.Services[i]
+		for j := range service.Routes {
+			route := &service.Routes[j]
+			if route.PathRegexp == nil || !route.matchesRequest(r) {
+				continue
+			}
+
+			m := route.PathRegexp.FindStringSubmatch(r.URL.Path)
+			if m == nil {
+				continue
+			}
+
+			params := map[string]string{}
+			for k, name := range route.PathRegexp.SubexpNames() {
+				if name != "" {
+					params[name] = m[k]
+				}
+			}
+
+			return &RouteMatch{Service: service, Route: route, Params: params, UpstreamPath: r.URL.Path}
+		}
+	}
+
+	return nil
+}
+
+func (route *Route) matchesRequest(r *http.Request) bool {
+	if !slices.Contains(route.Methods, r.Method) {
+		return false
+	}
+
+	if len(route.Hosts) > 0 && !slices.ContainsFunc(route.Hosts, func(host string) bool { return hostMatches(host, r.Host) }) {
+		return false
+	}
+
+	for name, values := range route.Headers {
+		if !slices.Contains(values, r.Header.Get(name)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (route *Route) predicates() int {
+	return len(route.Hosts) + len(route.Headers)
+}
+
+// upstreamPath aplica o rewrite_path ou o strip_path. Em rotas de prefixo,
+// o que veio depois do prefixo é mantido no fim do path.
+func (route *Route) upstreamPath(path string, params map[string]string, depth int) string {
+	if route.RewritePath == "" && !route.StripPath {
+		return path
+	}
+
+	base := "/"
+	if route.RewritePath != "" {
+		base = route.RewritePath
+		for name, value := range params {
+			base = strings.ReplaceAll(base, "{"+name+"}", value)
+		}
+	}
+
+	remainder := routes.Remainder(path, depth)
+	if remainder == "" {
+		return base
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + remainder
+}
+
+func hostMatches(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	pattern = strings.ToLower(pattern)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+
+	return host == pattern
+}
@@ -0,0 +1,561 @@
+// Package openapi gera serviços e rotas do gateway a partir de um documento OpenAPI 3.
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devgymbr/kong"
+	"github.com/devgymbr/kong/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+// Options ajustam o serviço gerado. Name e URL vazios vêm do info.title e do
+// primeiro item de servers do documento.
+type Options struct {
+	Name string
+	URL  string
+	// Validation coloca um request_validator em cada rota com os schemas da operação
+	Validation bool
+}
+
+type document struct {
+	OpenAPI string `yaml:"openapi"`
+	Info    struct {
+		Title string `yaml:"title"`
+	} `yaml:"info"`
+	Servers []struct {
+		URL string `yaml:"url"`
+	} `yaml:"servers"`
+	Paths      map[string]pathItem `yaml:"paths"`
+	Components struct {
+		Schemas       map[string]any         `yaml:"schemas"`
+		Parameters    map[string]parameter   `yaml:"parameters"`
+		RequestBodies map[string]requestBody `yaml:"requestBodies"`
+	} `yaml:"components"`
+}
+
+type pathItem struct {
+	Parameters []parameter `yaml:"parameters"`
+	Get        *operation  `yaml:"get"`
+	Put        *operation  `yaml:"put"`
+	Post       *operation  `yaml:"post"`
+	Delete     *operation  `yaml:"delete"`
+	Options    *operation  `yaml:"options"`
+	Head       *operation  `yaml:"head"`
+	Patch      *operation  `yaml:"patch"`
+	Trace      *operation  `yaml:"trace"`
+}
+
+// operations devolve as operações na ordem dos métodos, para o resultado não
+// depender da ordem do documento.
+func (p pathItem) operations() []struct {
+	method    string
+	operation *operation
+} {
+	all := []struct {
+		method    string
+		operation *operation
+	}{
+		{"GET", p.Get}, {"HEAD", p.Head}, {"POST", p.Post}, {"PUT", p.Put},
+		{"PATCH", p.Patch}, {"DELETE", p.Delete}, {"OPTIONS", p.Options}, {"TRACE", p.Trace},
+	}
+
+	operations := all[:0]
+	for _, op := range all {
+		if op.operation != nil {
+			operations = append(operations, op)
+		}
+	}
+
+	return operations
+}
+
+type operation struct {
+	OperationID string       `yaml:"operationId"`
+	Parameters  []parameter  `yaml:"parameters"`
+	RequestBody *requestBody `yaml:"requestBody"`
+}
+
+type parameter struct {
+	Ref      string `yaml:"$ref"`
+	Name     string `yaml:"name"`
+	In       string `yaml:"in"`
+	Required bool   `yaml:"required"`
+	Schema   any    `yaml:"schema"`
+}
+
+type requestBody struct {
+	Ref      string `yaml:"$ref"`
+	Required bool   `yaml:"required"`
+	Content  map[string]struct {
+		Schema any `yaml:"schema"`
+	} `yaml:"content"`
+}
+
+// Import lê um documento OpenAPI 3, em YAML ou JSON, e gera um serviço com uma rota
+// para cada operação. O nome da rota é o operationId; os parâmetros do path viram
+// {nome:tipo} com o tipo tirado do schema (integer vira int, uuid vira uuid e o
+// resto vira segment, que aceita qualquer coisa menos /). Um enum ou pattern do
+// schema restringe o parâmetro.
+func Import(data []byte, options Options) (kong.Service, error) {
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return kong.Service{}, fmt.Errorf("invalid openapi document: %w", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return kong.Service{}, fmt.Errorf("only openapi 3 documents are supported, got openapi %q", doc.OpenAPI)
+	}
+
+	service := kong.Service{Name: options.Name, URL: options.URL}
+	if service.Name == "" {
+		service.Name = slugify(doc.Info.Title)
+	}
+	if service.Name == "" {
+		return kong.Service{}, errors.New("the document has no info.title, a service name is required")
+	}
+
+	if service.URL == "" && len(doc.Servers) > 0 {
+		service.URL = doc.Servers[0].URL
+	}
+	if u, err := url.Parse(service.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return kong.Service{}, fmt.Errorf("servers[0].url %q is not an absolute url, an upstream url is required", service.URL)
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	names := map[string]int{}
+	for _, path := range paths {
+		item := doc.Paths[path]
+
+		for _, op := range item.operations() {
+			route, err := doc.route(path, op.method, item, op.operation, options)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", op.method, path, err))
+				continue
+			}
+
+			// nomes repetidos, de operationId duplicado ou gerados, ganham um número
+			names[route.Name]++
+			if n := names[route.Name]; n > 1 {
+				route.Name += "-" + strconv.Itoa(n)
+			}
+
+			service.Routes = append(service.Routes, route)
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return kong.Service{}, err
+	}
+
+	if len(service.Routes) == 0 {
+		return kong.Service{}, errors.New("the document has no operations")
+	}
+
+	return service, nil
+}
+
+func (doc *document) route(path, method string, item pathItem, op *operation, options Options) (kong.Route, error) {
+	parameters, err := doc.parameters(item.Parameters, op.Parameters)
+	if err != nil {
+		return kong.Route{}, err
+	}
+
+	routePath, err := convertPath(path, parameters)
+	if err != nil {
+		return kong.Route{}, err
+	}
+
+	route := kong.Route{
+		Name:    op.OperationID,
+		Paths:   []string{routePath},
+		Methods: []string{method},
+	}
+	if route.Name == "" {
+		route.Name = strings.ToLower(method) + "-" + slugify(path)
+	}
+
+	if !options.Validation {
+		return route, nil
+	}
+
+	input, err := doc.validatorInput(parameters, op.RequestBody)
+	if err != nil {
+		return kong.Route{}, err
+	}
+
+	if len(input) > 0 {
+		route.Plugins = []kong.Plugin{{Name: "request_validator", Input: input}}
+	}
+
+	return route, nil
+}
+
+// parameters junta os parâmetros do path com os da operação, que substituem os de
+// mesmo nome e local.
+func (doc *document) parameters(shared, own []parameter) ([]parameter, error) {
+	var parameters []parameter
+
+	for _, list := range [][]parameter{shared, own} {
+		for _, p := range list {
+			if p.Ref != "" {
+				name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+				resolved, found := doc.Components.Parameters[name]
+				if !ok || !found {
+					return nil, fmt.Errorf("parameter $ref %q not found", p.Ref)
+				}
+				p = resolved
+			}
+
+			replaced := false
+			for i := range parameters {
+				if parameters[i].Name == p.Name && parameters[i].In == p.In {
+					parameters[i] = p
+					replaced = true
+				}
+			}
+
+			if !replaced {
+				parameters = append(parameters, p)
+			}
+		}
+	}
+
+	return parameters, nil
+}
+
+var (
+	templateParam = regexp.MustCompile(`{([^}]+)}`)
+	invalidName   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// convertPath troca os {param} do OpenAPI pelo {param:tipo} das rotas. O resto do
+// path é escapado, porque as rotas são expressões regulares.
+func convertPath(path string, parameters []parameter) (string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", errors.New("path must start with /")
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, m := range templateParam.FindAllStringSubmatchIndex(path, -1) {
+		b.WriteString(regexp.QuoteMeta(path[last:m[0]]))
+		last = m[1]
+
+		name := path[m[2]:m[3]]
+		var schema any
+		for _, p := range parameters {
+			if p.In == "path" && p.Name == name {
+				schema = p.Schema
+			}
+		}
+
+		param, err := pathParam(invalidName.ReplaceAllString(name, "_"), schema)
+		if err != nil {
+			return "", fmt.Errorf("path parameter %q: %w", name, err)
+		}
+		b.WriteString(param)
+	}
+	b.WriteString(regexp.QuoteMeta(path[last:]))
+
+	return b.String(), nil
+}
+
+// pathParam escreve o parâmetro como {nome:tipo}. Um enum ou pattern do schema vira
+// um grupo com a própria expressão, porque nenhum tipo das rotas o representa.
+func pathParam(name string, schema any) (string, error) {
+	object, _ := schema.(map[string]any)
+
+	if values, ok := object["enum"].([]any); ok && len(values) > 0 {
+		alternatives := make([]string, len(values))
+		for i, value := range values {
+			alternatives[i] = routeExpr(regexp.QuoteMeta(fmt.Sprint(value)))
+		}
+		return "(?P<" + name + ">" + strings.Join(alternatives, "|") + ")", nil
+	}
+
+	if pattern, ok := object["pattern"].(string); ok {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return "", fmt.Errorf("pattern %q is not supported: %w", pattern, err)
+		}
+
+		// o pattern do JSON Schema procura em qualquer parte do valor, sem âncoras
+		// ele pode ter mais coisa antes e depois
+		expr := `[^\x2f]*(?:` + routeExpr(pattern) + `)[^\x2f]*`
+		if inner, ok := strings.CutPrefix(pattern, "^"); ok && strings.HasSuffix(inner, "$") && !strings.HasSuffix(inner, `\$`) {
+			expr = "(?:" + routeExpr(strings.TrimSuffix(inner, "$")) + ")"
+		}
+		return "(?P<" + name + ">" + expr + ")", nil
+	}
+
+	paramType := "segment"
+	switch {
+	case object["type"] == "integer":
+		paramType = "int"
+	case object["format"] == "uuid":
+		paramType = "uuid"
+	}
+
+	return "{" + name + ":" + paramType + "}", nil
+}
+
+// repetition é um {n}, {n,} ou {n,m} de uma expressão regular.
+var repetition = regexp.MustCompile(`^{[0-9]+(,[0-9]*)?}`)
+
+// routeExpr ajusta uma expressão regular para ficar dentro de um segmento da rota:
+// a / separaria o segmento e vira \x2f, um {n} seria lido como {parâmetro} e vira
+// {n,n} e as outras chaves viram \x7b.
+func routeExpr(expr string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(expr); i++ {
+		switch c := expr[i]; {
+		case c == '\\' && i+1 < len(expr):
+			switch expr[i+1] {
+			case '/':
+				b.WriteString(`\x2f`)
+			case '{':
+				b.WriteString(`\x7b`)
+			default:
+				b.WriteString(expr[i : i+2])
+			}
+			i++
+		case c == '/':
+			b.WriteString(`\x2f`)
+		case c == '{':
+			m := repetition.FindString(expr[i:])
+			switch {
+			case m == "":
+				b.WriteString(`\x7b`)
+			case strings.Contains(m, ","):
+				b.WriteString(m)
+			default:
+				n := strings.Trim(m, "{}")
+				b.WriteString("{" + n + "," + n + "}")
+			}
+			i += max(len(m)-1, 0)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
+
+// validatorInput monta o input do request_validator com os parâmetros de query e de
+// header e o schema JSON do body. O body só é conferido quando o requestBody é
+// required, porque o request_validator recusa requests sem body.
+func (doc *document) validatorInput(parameters []parameter, body *requestBody) (map[string]any, error) {
+	input := map[string]any{}
+	converter := &schemaConverter{components: doc.Components.Schemas}
+
+	for _, in := range []string{"query", "header"} {
+		properties := map[string]any{}
+		var required []any
+
+		for _, p := range parameters {
+			if p.In != in || p.Schema == nil {
+				continue
+			}
+
+			properties[p.Name] = p.Schema
+			if p.Required {
+				required = append(required, p.Name)
+			}
+		}
+
+		if len(properties) == 0 {
+			continue
+		}
+
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+
+		converted, err := converter.document(schema)
+		if err != nil {
+			return nil, fmt.Errorf("%s parameters: %w", in, err)
+		}
+		input[in+"_schema"] = converted
+	}
+
+	if body != nil && body.Ref != "" {
+		name, ok := strings.CutPrefix(body.Ref, "#/components/requestBodies/")
+		resolved, found := doc.Components.RequestBodies[name]
+		if !ok || !found {
+			return nil, fmt.Errorf("request body $ref %q not found", body.Ref)
+		}
+		body = &resolved
+	}
+
+	if body != nil && body.Required {
+		for contentType, content := range body.Content {
+			mediaType, _, err := mime.ParseMediaType(contentType)
+			if err != nil || content.Schema == nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+				continue
+			}
+
+			converted, err := converter.document(content.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("request body: %w", err)
+			}
+			input["body_schema"] = converted
+			break
+		}
+	}
+
+	return input, nil
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// annotations são palavras do OpenAPI que só documentam o schema.
+var annotations = []string{"example", "examples", "xml", "externalDocs", "discriminator", "readOnly", "writeOnly", "deprecated"}
+
+// schemaConverter leva os schemas do OpenAPI para o subconjunto de JSON Schema do
+// request_validator. Os $ref para components/schemas viram $defs dentro do próprio
+// schema, que precisa ser independente do documento.
+type schemaConverter struct {
+	components map[string]any
+}
+
+func (c *schemaConverter) document(schema any) (any, error) {
+	defs := map[string]any{}
+
+	converted, err := c.convert(schema, defs)
+	if err != nil {
+		return nil, err
+	}
+
+	if object, ok := converted.(map[string]any); ok && len(defs) > 0 {
+		object["$defs"] = defs
+	}
+
+	return converted, nil
+}
+
+func (c *schemaConverter) convert(schema any, defs map[string]any) (any, error) {
+	switch schema := schema.(type) {
+	case []any:
+		items := make([]any, len(schema))
+		for i, item := range schema {
+			converted, err := c.convert(item, defs)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = converted
+		}
+		return items, nil
+
+	case map[string]any:
+		object := make(map[string]any, len(schema))
+		for keyword, value := range schema {
+			if keyword == "nullable" || slices.Contains(annotations, keyword) {
+				continue
+			}
+
+			if keyword == "format" {
+				// formatos como int64 e password não mudam a validação
+				if name, ok := value.(string); !ok || !plugin.SchemaFormatSupported(name) {
+					continue
+				}
+			}
+
+			if keyword == "$ref" {
+				ref, err := c.ref(value, defs)
+				if err != nil {
+					return nil, err
+				}
+				object[keyword] = ref
+				continue
+			}
+
+			converted, err := c.convert(value, defs)
+			if err != nil {
+				return nil, err
+			}
+			object[keyword] = converted
+		}
+
+		// no OpenAPI 3.0, nullable acrescenta null aos tipos
+		if schema["nullable"] == true {
+			switch t := object["type"].(type) {
+			case string:
+				object["type"] = []any{t, "null"}
+			case []any:
+				object["type"] = append(t, "null")
+			}
+		}
+
+		// e exclusiveMinimum/exclusiveMaximum são booleanos que mudam minimum/maximum
+		for exclusive, limit := range map[string]string{"exclusiveMinimum": "minimum", "exclusiveMaximum": "maximum"} {
+			if flag, ok := object[exclusive].(bool); ok {
+				delete(object, exclusive)
+				if flag && object[limit] != nil {
+					object[exclusive] = object[limit]
+					delete(object, limit)
+				}
+			}
+		}
+
+		return object, nil
+	}
+
+	return schema, nil
+}
+
+func (c *schemaConverter) ref(value any, defs map[string]any) (string, error) {
+	ref, _ := value.(string)
+	name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+	if !ok {
+		return "", fmt.Errorf("$ref %q is not supported, only #/components/schemas", ref)
+	}
+
+	if _, done := defs[name]; !done {
+		target, found := c.components[name]
+		if !found {
+			return "", fmt.Errorf("$ref %q not found", ref)
+		}
+
+		// reservado antes da conversão, assim um schema que aponta para ele mesmo termina
+		defs[name] = nil
+		converted, err := c.convert(target, defs)
+		if err != nil {
+			return "", err
+		}
+		defs[name] = converted
+	}
+
+	return "#/$defs/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(name), nil
+}
@@ -0,0 +1,569 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+const redacted = "[REDACTED]"
+
+// AccessLogQueueConfig são as opções comuns do file_log, tcp_log e http_log. Os
+// registros vão para uma fila e são enviados em lotes por uma goroutine, assim a
+// request não espera o destino do log. Com a fila cheia, os registros novos são descartados.
+type AccessLogQueueConfig struct {
+	QueueSize int `input:"queue_size" default:"10000"`
+	BatchSize int `input:"batch_size" default:"100"`
+	// FlushTimeout é o tempo máximo, em milissegundos, que um registro espera o lote encher
+	FlushTimeout int `input:"flush_timeout" default:"1000"`
+	// RedactHeaders também vale para os parâmetros da query string. As key_names do
+	// key_auth e o key_name do jwt_auth são escondidos mesmo quando não estão na lista.
+	RedactHeaders []string `input:"redact_headers" default:"Authorization,Proxy-Authorization,Cookie,Set-Cookie,apikey"`
+}
+
+func (c *AccessLogQueueConfig) Validate() error {
+	if c.QueueSize <= 0 || c.BatchSize <= 0 || c.FlushTimeout <= 0 {
+		return errors.New("queue_size, batch_size and flush_timeout must be greater than 0")
+	}
+
+	return nil
+}
+
+type FileLogConfig struct {
+	Path string `input:"path,required"`
+	AccessLogQueueConfig
+}
+
+type TCPLogConfig struct {
+	Host string `input:"host,required"`
+	Port int    `input:"port,required"`
+	// Timeout da conexão e do envio de cada lote, em milissegundos
+	Timeout int `input:"timeout" default:"10000"`
+	AccessLogQueueConfig
+}
+
+// HTTPLogConfig envia os registros em lotes, como uma lista JSON, para http_endpoint.
+// Sem http_endpoint, cada registro vira uma linha no log do gateway.
+type HTTPLogConfig struct {
+	HTTPEndpoint string            `input:"http_endpoint"`
+	Method       string            `input:"method" default:"POST" oneof:"POST|PUT|PATCH"`
+	Headers      map[string]string `input:"headers"`
+	Timeout      int               `input:"timeout" default:"10000"`
+	AccessLogQueueConfig
+}
+
+func (c *HTTPLogConfig) Validate() error {
+	if c.HTTPEndpoint != "" {
+		if u, err := url.Parse(c.HTTPEndpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid http_endpoint %q", c.HTTPEndpoint)
+		}
+	}
+
+	return c.AccessLogQueueConfig.Validate()
+}
+
+// queueKey identifica a fila de cada destino. A chave inclui as opções, então uma
+// configuração alterada ganha uma fila nova e a antiga é fechada por ReleaseUnused.
+func (c *HTTPLogConfig) queueKey() string {
+	return fmt.Sprintf("http_log:%s %s %+v %+v", c.Method, c.HTTPEndpoint, c.Headers, c.AccessLogQueueConfig)
+}
+
+func (c *FileLogConfig) queueKey() string {
+	return fmt.Sprintf("file_log:%s %+v", c.Path, c.AccessLogQueueConfig)
+}
+
+func (c *TCPLogConfig) queueKey() string {
+	return fmt.Sprintf("tcp_log:%s %d %+v", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), c.Timeout, c.AccessLogQueueConfig)
+}
+
+// AccessLogRecord é o que os plugins de log registram de cada request.
+type AccessLogRecord struct {
+	StartedAt time.Time         `json:"started_at"`
+	ClientIP  string            `json:"client_ip"`
+	Consumer  string            `json:"consumer,omitempty"`
+	Service   string            `json:"service,omitempty"`
+	Route     string            `json:"route,omitempty"`
+	Upstream  string            `json:"upstream_uri,omitempty"`
+	Request   AccessLogRequest  `json:"request"`
+	Response  AccessLogResponse `json:"response"`
+	Latencies AccessLogLatency  `json:"latencies"`
+}
+
+type AccessLogRequest struct {
+	Method  string      `json:"method"`
+	URI     string      `json:"uri"`
+	Host    string      `json:"host"`
+	Size    int64       `json:"size"`
+	Headers http.Header `json:"headers"`
+}
+
+type AccessLogResponse struct {
+	Status   int         `json:"status"`
+	Size     int64       `json:"size"`
+	Headers  http.Header `json:"headers"`
+	Trailers http.Header `json:"trailers,omitempty"`
+	// GRPC só aparece nas chamadas gRPC, que quase sempre respondem 200 mesmo com erro
+	GRPC *GRPCStatus `json:"grpc,omitempty"`
+}
+
+// AccessLogLatency são os tempos da request em milissegundos.
+type AccessLogLatency struct {
+	Request  int64 `json:"request"`
+	Upstream int64 `json:"upstream"`
+	Gateway  int64 `json:"gateway"`
+}
+
+// NewAccessLogRecord monta o registro da request com os headers e parâmetros da query
+// de redact escondidos.
+func NewAccessLogRecord(r *http.Request, entry LogEntry, redact []string) AccessLogRecord {
+	redact = append(slices.Clone(redact), credentialNames(r)...)
+
+	record := AccessLogRecord{
+		StartedAt: entry.StartedAt,
+		ClientIP:  r.RemoteAddr,
+		Request: AccessLogRequest{
+			Method:  r.Method,
+			URI:     redactURI(r.URL, redact),
+			Host:    r.Host,
+			Size:    entry.RequestBytes,
+			Headers: redactHeaders(r.Header, redact),
+		},
+		Response: AccessLogResponse{
+			Status:  entry.Status,
+			Size:    entry.Bytes,
+			Headers: redactHeaders(entry.ResponseHeader, redact),
+		},
+		Latencies: AccessLogLatency{
+			Request:  entry.Latency.Milliseconds(),
+			Upstream: entry.UpstreamLatency.Milliseconds(),
+			Gateway:  (entry.Latency - entry.UpstreamLatency).Milliseconds(),
+		},
+	}
+
+	if entry.ResponseTrailer != nil {
+		record.Response.Trailers = redactHeaders(entry.ResponseTrailer, redact)
+	}
+
+	if status, ok := GRPCStatusOf(r, entry); ok {
+		record.Response.GRPC = &status
+	}
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		record.ClientIP = ip
+	}
+
+	if consumer, ok := kong.ConsumerFromContext(r.Context()); ok {
+		record.Consumer = consumer.Name
+	}
+
+	if match, ok := kong.RouteMatchFromContext(r.Context()); ok {
+		record.Service = match.Service.Name
+		record.Route = match.Route.Name
+		upstream := match.Service
+		if match.Upstream != nil {
+			upstream = match.Upstream
+		}
+		record.Upstream = upstream.URL + match.UpstreamPath
+		if r.URL.RawQuery != "" {
+			record.Upstream += "?" + redactQuery(r.URL.RawQuery, redact)
+		}
+	}
+
+	return record
+}
+
+// credentialNames são os headers e parâmetros onde os key_auth e jwt_auth da request
+// procuram a chave ou o token.
+func credentialNames(r *http.Request) []string {
+	config, ok := kong.ConfigFromContext(r.Context())
+	if !ok {
+		return nil
+	}
+
+	plugins := slices.Clone(config.Plugins)
+	if match, ok := kong.RouteMatchFromContext(r.Context()); ok {
+		plugins = append(plugins, match.Service.Plugins...)
+		if match.Route != nil {
+			plugins = append(plugins, match.Route.Plugins...)
+		}
+	}
+
+	var names []string
+	for _, p := range plugins {
+		switch p.Name {
+		case "key_auth":
+			if settings, err := Settings[KeyAuthConfig](p); err == nil {
+				names = append(names, settings.KeyNames...)
+			}
+		case "jwt_auth":
+			// key_name é o header ou, com key_in_header false, o parâmetro da query
+			if settings, err := Settings[JWTAuthConfig](p); err == nil {
+				names = append(names, settings.KeyName)
+			}
+		}
+	}
+
+	return names
+}
+
+func redactURI(u *url.URL, redact []string) string {
+	uri := u.RequestURI()
+	if path, query, ok := strings.Cut(uri, "?"); ok {
+		return path + "?" + redactQuery(query, redact)
+	}
+
+	return uri
+}
+
+// redactQuery troca só os valores, sem decodificar e codificar a query de novo,
+// assim o resto dela fica como o cliente enviou.
+func redactQuery(query string, redact []string) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if slices.ContainsFunc(redact, func(r string) bool { return strings.EqualFold(r, name) }) {
+			params[i] = url.QueryEscape(name) + "=" + redacted
+		}
+	}
+
+	return strings.Join(params, "&")
+}
+
+func redactHeaders(header http.Header, redact []string) http.Header {
+	clone := header.Clone()
+	if clone == nil {
+		return http.Header{}
+	}
+
+	for _, name := range redact {
+		if _, ok := clone[http.CanonicalHeaderKey(name)]; ok {
+			clone.Set(name, redacted)
+		}
+	}
+
+	return clone
+}
+
+// Log é a fase log do http_log, roda quando a resposta já foi enviada.
+func Log(p kong.Plugin, r *http.Request, entry LogEntry) {
+	settings, err := Settings[HTTPLogConfig](p)
+	if err != nil {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		return
+	}
+
+	record := NewAccessLogRecord(r, entry, settings.RedactHeaders)
+	if settings.HTTPEndpoint == "" {
+		logRecord(record)
+		return
+	}
+
+	findAccessLogQueue(settings.queueKey(), settings.AccessLogQueueConfig, func() logSink {
+		return &httpLogSink{settings: settings, client: &http.Client{Timeout: time.Duration(settings.Timeout) * time.Millisecond}}
+	}).push(record)
+}
+
+// FileLog é a fase log do file_log, que escreve um registro JSON por linha no arquivo path.
+func FileLog(p kong.Plugin, r *http.Request, entry LogEntry) {
+	settings, err := Settings[FileLogConfig](p)
+	if err != nil {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		return
+	}
+
+	findAccessLogQueue(settings.queueKey(), settings.AccessLogQueueConfig, func() logSink {
+		return &fileLogSink{path: settings.Path}
+	}).push(NewAccessLogRecord(r, entry, settings.RedactHeaders))
+}
+
+// TCPLog é a fase log do tcp_log, que envia um registro JSON por linha para host:port.
+func TCPLog(p kong.Plugin, r *http.Request, entry LogEntry) {
+	settings, err := Settings[TCPLogConfig](p)
+	if err != nil {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		return
+	}
+
+	findAccessLogQueue(settings.queueKey(), settings.AccessLogQueueConfig, func() logSink {
+		return &tcpLogSink{address: net.JoinHostPort(settings.Host, strconv.Itoa(settings.Port)), timeout: time.Duration(settings.Timeout) * time.Millisecond}
+	}).push(NewAccessLogRecord(r, entry, settings.RedactHeaders))
+}
+
+func logRecord(record AccessLogRecord) {
+	attrs := []any{
+		slog.String("client_ip", record.ClientIP),
+		slog.String("method", record.Request.Method),
+		slog.String("url", record.Request.URI),
+		slog.Int("status", record.Response.Status),
+		slog.Int64("request_bytes", record.Request.Size),
+		slog.Int64("bytes", record.Response.Size),
+		slog.Int64("latency_ms", record.Latencies.Request),
+		slog.Int64("upstream_latency_ms", record.Latencies.Upstream),
+	}
+
+	if record.Service != "" {
+		attrs = append(attrs, slog.String("service", record.Service), slog.String("route", record.Route), slog.String("upstream", record.Upstream))
+	}
+
+	if record.Consumer != "" {
+		attrs = append(attrs, slog.String("consumer", record.Consumer))
+	}
+
+	slog.Info("request served", attrs...)
+}
+
+// logSink é o destino de um lote de registros.
+type logSink interface {
+	send(batch []AccessLogRecord) error
+}
+
+type fileLogSink struct {
+	path string
+}
+
+// o arquivo é aberto a cada lote, então ele pode ser rotacionado sem reiniciar o gateway
+func (s *fileLogSink) send(batch []AccessLogRecord) error {
+	fp, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fp.Write(jsonLines(batch)); err != nil {
+		fp.Close()
+		return err
+	}
+
+	return fp.Close()
+}
+
+type tcpLogSink struct {
+	address string
+	timeout time.Duration
+}
+
+func (s *tcpLogSink) send(batch []AccessLogRecord) error {
+	conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err = conn.SetWriteDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	_, err = conn.Write(jsonLines(batch))
+	return err
+}
+
+type httpLogSink struct {
+	settings *HTTPLogConfig
+	client   *http.Client
+}
+
+func (s *httpLogSink) send(batch []AccessLogRecord) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), s.settings.Method, s.settings.HTTPEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.settings.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log endpoint responded %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func jsonLines(batch []AccessLogRecord) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, record := range batch {
+		if err := encoder.Encode(record); err != nil {
+			slog.Error("could not encode access log record", slog.String("error", err.Error()))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// accessLogQueue junta os registros de um destino e envia em lotes numa goroutine própria.
+type accessLogQueue struct {
+	name         string
+	records      chan AccessLogRecord
+	flushes      chan chan struct{}
+	stop         chan struct{}
+	stopped      chan struct{}
+	sink         logSink
+	batchSize    int
+	flushTimeout time.Duration
+}
+
+func newAccessLogQueue(name string, settings AccessLogQueueConfig, sink logSink) *accessLogQueue {
+	q := &accessLogQueue{
+		name:         name,
+		records:      make(chan AccessLogRecord, settings.QueueSize),
+		flushes:      make(chan chan struct{}),
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		sink:         sink,
+		batchSize:    settings.BatchSize,
+		flushTimeout: time.Duration(settings.FlushTimeout) * time.Millisecond,
+	}
+	go q.run()
+
+	return q
+}
+
+func (q *accessLogQueue) push(record AccessLogRecord) {
+	select {
+	case q.records <- record:
+	default:
+		slog.Warn("access log queue is full, dropping record", slog.String("queue", q.name))
+	}
+}
+
+func (q *accessLogQueue) run() {
+	batch := make([]AccessLogRecord, 0, q.batchSize)
+	ticker := time.NewTicker(q.flushTimeout)
+	defer ticker.Stop()
+	defer close(q.stopped)
+
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := q.sink.send(batch); err != nil {
+			slog.Error("could not send access log batch", slog.String("queue", q.name), slog.Int("records", len(batch)), slog.String("error", err.Error()))
+		}
+		batch = make([]AccessLogRecord, 0, q.batchSize)
+	}
+
+	// o que ainda está no channel entra no último lote
+	drain := func() {
+		for len(q.records) > 0 {
+			batch = append(batch, <-q.records)
+			if len(batch) >= q.batchSize {
+				send()
+			}
+		}
+		send()
+	}
+
+	for {
+		select {
+		case record := <-q.records:
+			batch = append(batch, record)
+			if len(batch) >= q.batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-q.flushes:
+			drain()
+			close(done)
+		case <-q.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func (q *accessLogQueue) flush() {
+	done := make(chan struct{})
+	select {
+	case q.flushes <- done:
+		<-done
+	case <-q.stopped:
+	}
+}
+
+// close envia o que está na fila e encerra a goroutine.
+func (q *accessLogQueue) close() {
+	close(q.stop)
+	<-q.stopped
+}
+
+var (
+	accessLogQueuesMu sync.Mutex
+	accessLogQueues   = map[string]*accessLogQueue{}
+)
+
+// findAccessLogQueue devolve a fila do destino, criando ela na primeira vez.
+func findAccessLogQueue(key string, settings AccessLogQueueConfig, sink func() logSink) *accessLogQueue {
+	accessLogQueuesMu.Lock()
+	defer accessLogQueuesMu.Unlock()
+
+	q, ok := accessLogQueues[key]
+	if !ok {
+		q = newAccessLogQueue(key, settings, sink())
+		accessLogQueues[key] = q
+	}
+
+	return q
+}
+
+// closeAccessLogQueues fecha as filas cuja chave não está em keys.
+func closeAccessLogQueues(keys map[string]bool) {
+	accessLogQueuesMu.Lock()
+	var unused []*accessLogQueue
+	for key, q := range accessLogQueues {
+		if !keys[key] {
+			unused = append(unused, q)
+			delete(accessLogQueues, key)
+		}
+	}
+	accessLogQueuesMu.Unlock()
+
+	for _, q := range unused {
+		q.close()
+	}
+}
+
+// FlushAccessLogs envia na hora tudo que está nas filas dos plugins de log, como
+// antes de o gateway terminar.
+func FlushAccessLogs() {
+	accessLogQueuesMu.Lock()
+	queues := make([]*accessLogQueue, 0, len(accessLogQueues))
+	for _, q := range accessLogQueues {
+		queues = append(queues, q)
+	}
+	accessLogQueuesMu.Unlock()
+
+	for _, q := range queues {
+		q.flush()
+	}
+}
@@ -0,0 +1,173 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+const (
+	HeaderConsumerUsername = "X-Consumer-Username"
+	HeaderConsumerCustomID = "X-Consumer-Custom-ID"
+	HeaderConsumerGroups   = "X-Consumer-Groups"
+)
+
+type KeyAuthConfig struct {
+	KeyNames        []string `input:"key_names" default:"apikey"`
+	HideCredentials bool     `input:"hide_credentials"`
+}
+
+type BasicAuthConfig struct {
+	Realm           string `input:"realm" default:"kong"`
+	HideCredentials bool   `input:"hide_credentials"`
+}
+
+type ACLConfig struct {
+	Allow []string `input:"allow"`
+	Deny  []string `input:"deny"`
+}
+
+func (c *ACLConfig) Validate() error {
+	if len(c.Allow) == 0 && len(c.Deny) == 0 {
+		return errors.New("at least one of allow or deny is required")
+	}
+
+	return nil
+}
+
+// KeyAuth identifica o consumer pela chave enviada em um header ou na query string.
+func KeyAuth(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[KeyAuthConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, ok := kong.ConfigFromContext(r.Context())
+		if !ok {
+			slog.Error("could not find config in request context")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var key string
+		for _, name := range settings.KeyNames {
+			if key = r.Header.Get(name); key != "" {
+				break
+			}
+
+			if key = r.URL.Query().Get(name); key != "" {
+				break
+			}
+		}
+
+		if key == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		consumer, ok := config.FindConsumerByKey(key)
+		if !ok {
+			slog.Info("invalid api key")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// a chave não precisa chegar no upstream
+		if settings.HideCredentials {
+			query := r.URL.Query()
+			for _, name := range settings.KeyNames {
+				r.Header.Del(name)
+				query.Del(name)
+			}
+			r.URL.RawQuery = query.Encode()
+		}
+
+		f(w, authenticated(r, consumer))
+	}
+}
+
+// BasicAuth identifica o consumer pelo usuário e senha do header Authorization.
+func BasicAuth(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[BasicAuthConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		config, ok := kong.ConfigFromContext(r.Context())
+		if !ok {
+			slog.Error("could not find config in request context")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", settings.Realm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		consumer, ok := config.FindConsumerByBasicAuth(username, password)
+		if !ok {
+			slog.Info("invalid basic auth credentials", slog.String("username", username))
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", settings.Realm))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if settings.HideCredentials {
+			r.Header.Del("Authorization")
+		}
+
+		f(w, authenticated(r, consumer))
+	}
+}
+
+// ACL libera ou bloqueia a request pelos grupos do consumer. Precisa rodar depois
+// de um plugin de autenticação.
+func ACL(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[ACLConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		consumer, ok := kong.ConsumerFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if consumer.InGroup(settings.Deny...) || (len(settings.Allow) > 0 && !consumer.InGroup(settings.Allow...)) {
+			slog.Info("consumer not allowed", slog.String("consumer", consumer.Name))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+// authenticated guarda o consumer no contexto e avisa o upstream quem ele é.
+// Os headers X-Consumer-* que vieram do cliente são sempre descartados.
+func authenticated(r *http.Request, consumer *kong.Consumer) *http.Request {
+	r.Header.Del(HeaderConsumerUsername)
+	r.Header.Del(HeaderConsumerCustomID)
+	r.Header.Del(HeaderConsumerGroups)
+
+	r.Header.Set(HeaderConsumerUsername, consumer.Name)
+	if consumer.CustomID != "" {
+		r.Header.Set(HeaderConsumerCustomID, consumer.CustomID)
+	}
+	if len(consumer.Groups) > 0 {
+		r.Header.Set(HeaderConsumerGroups, strings.Join(consumer.Groups, ", "))
+	}
+
+	return r.WithContext(kong.WithConsumer(r.Context(), consumer))
+}
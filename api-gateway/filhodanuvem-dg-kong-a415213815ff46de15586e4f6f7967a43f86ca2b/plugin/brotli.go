@@ -0,0 +1,591 @@
+package plugin
+
+import (
+	"errors"
+	"io"
+	"slices"
+)
+
+// brotliWriter comprime no formato brotli só com a biblioteca padrão. Cada bloco
+// usa LZ77 com hash chain e códigos de Huffman próprios, sem o dicionário estático
+// e sem modelagem de contexto, então a taxa fica próxima à do gzip.
+// leia: https://www.rfc-editor.org/rfc/rfc7932
+type brotliWriter struct {
+	w     io.Writer
+	bits  brotliBits
+	chain int
+	// history guarda o fim do que já foi comprimido, que os próximos blocos podem
+	// referenciar por distância
+	history []byte
+	pending []byte
+	started bool
+	closed  bool
+}
+
+const (
+	// com WBITS 16 a janela é de 65520 bytes, e o header do stream é um único bit 0
+	brotliMaxDistance = 1<<16 - 16
+	brotliBlockSize   = 1 << 16
+	brotliHashBits    = 15
+	brotliMinMatch    = 4
+)
+
+var (
+	brotliInsertBase  = []int{0, 1, 2, 3, 4, 5, 6, 8, 10, 14, 18, 26, 34, 50, 66, 98, 130, 194, 322, 578, 1090, 2114, 6210, 22594}
+	brotliInsertExtra = []uint{0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 12, 14, 24}
+	brotliCopyBase    = []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 12, 14, 18, 22, 30, 38, 54, 70, 102, 134, 198, 326, 582, 1094, 2118}
+	brotliCopyExtra   = []uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 10, 24}
+
+	// ordem em que os tamanhos do código dos tamanhos de código são gravados
+	brotliCodeLengthOrder = []int{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+)
+
+// newBrotliWriter recebe o level do compress/flate (1 a 9, -1 para o padrão), que
+// aqui decide quantas posições anteriores são comparadas em cada busca.
+func newBrotliWriter(w io.Writer, level int) *brotliWriter {
+	if level < 0 {
+		level = 6
+	}
+
+	return &brotliWriter{w: w, chain: level * 4}
+}
+
+func (b *brotliWriter) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("brotli: write after close")
+	}
+
+	b.pending = append(b.pending, p...)
+	for len(b.pending) >= brotliBlockSize {
+		b.compress(b.pending[:brotliBlockSize])
+		b.pending = slices.Clone(b.pending[brotliBlockSize:])
+	}
+
+	if err := b.emit(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush comprime o que está pendente e fecha o byte com um bloco de metadados
+// vazio, assim o cliente consegue descomprimir tudo o que foi enviado até aqui.
+func (b *brotliWriter) Flush() error {
+	if b.closed {
+		return nil
+	}
+
+	if len(b.pending) > 0 {
+		b.compress(b.pending)
+		b.pending = nil
+	}
+
+	b.header()
+	// ISLAST 0, MNIBBLES 3 (metadados), bit reservado e MSKIPBYTES 0
+	b.bits.write(1, 0)
+	b.bits.write(2, 3)
+	b.bits.write(1, 0)
+	b.bits.write(2, 0)
+	b.bits.align()
+
+	return b.emit()
+}
+
+func (b *brotliWriter) Close() error {
+	if b.closed {
+		return nil
+	}
+
+	if len(b.pending) > 0 {
+		b.compress(b.pending)
+		b.pending = nil
+	}
+
+	b.header()
+	// ISLAST 1 e ISLASTEMPTY 1
+	b.bits.write(2, 3)
+	b.bits.align()
+	b.closed = true
+
+	return b.emit()
+}
+
+func (b *brotliWriter) header() {
+	if !b.started {
+		b.bits.write(1, 0)
+		b.started = true
+	}
+}
+
+func (b *brotliWriter) emit() error {
+	if len(b.bits.out) == 0 {
+		return nil
+	}
+
+	_, err := b.w.Write(b.bits.out)
+	b.bits.out = b.bits.out[:0]
+
+	return err
+}
+
+type brotliCommand struct {
+	literals []byte
+	copy     int
+	distance int
+}
+
+// compress grava o bloco como um meta-block comprimido, com um único tipo de bloco
+// e um código de Huffman para literais, um para comandos e um para distâncias.
+func (b *brotliWriter) compress(block []byte) {
+	b.header()
+
+	data := append(slices.Clone(b.history), block...)
+	commands := b.match(data, len(b.history))
+
+	literalCounts := make([]int, 256)
+	commandCounts := make([]int, 704)
+	distanceCounts := make([]int, 64)
+	for _, c := range commands {
+		for _, literal := range c.literals {
+			literalCounts[literal]++
+		}
+
+		commandCounts[brotliCommandCode(len(c.literals), c.copy)]++
+		if c.copy > 0 {
+			code, _, _ := brotliDistanceCode(c.distance)
+			distanceCounts[code]++
+		}
+	}
+
+	saved := brotliBits{out: slices.Clone(b.bits.out), acc: b.bits.acc, n: b.bits.n}
+	defer func() {
+		b.history = slices.Clone(data[max(0, len(data)-brotliMaxDistance):])
+
+		// dados que não comprimem, como imagens, vão num meta-block sem compressão
+		if len(b.bits.out)-len(saved.out) <= len(block)+4 {
+			return
+		}
+
+		b.bits = saved
+		b.bits.write(1, 0)
+		b.bits.write(2, 0)
+		b.bits.write(16, uint64(len(block)-1))
+		b.bits.write(1, 1)
+		b.bits.align()
+		b.bits.out = append(b.bits.out, block...)
+	}()
+
+	// ISLAST 0, MNIBBLES 0 (4 nibbles), MLEN - 1 e ISUNCOMPRESSED 0
+	b.bits.write(1, 0)
+	b.bits.write(2, 0)
+	b.bits.write(16, uint64(len(block)-1))
+	b.bits.write(1, 0)
+	// NBLTYPESL, NBLTYPESI e NBLTYPESD 1, NPOSTFIX 0, NDIRECT 0, contexto LSB6,
+	// NTREESL 1 e NTREESD 1
+	b.bits.write(3, 0)
+	b.bits.write(6, 0)
+	b.bits.write(2, 0)
+	b.bits.write(2, 0)
+
+	literalDepths, literalCodes := b.bits.prefixCode(literalCounts, 8)
+	commandDepths, commandCodes := b.bits.prefixCode(commandCounts, 10)
+	distanceDepths, distanceCodes := b.bits.prefixCode(distanceCounts, 6)
+
+	for _, c := range commands {
+		code := brotliCommandCode(len(c.literals), c.copy)
+		b.bits.write(uint(commandDepths[code]), uint64(commandCodes[code]))
+
+		insert := brotliLengthCode(brotliInsertBase, len(c.literals))
+		b.bits.write(brotliInsertExtra[insert], uint64(len(c.literals)-brotliInsertBase[insert]))
+		copyLength := max(c.copy, 2)
+		copyCode := brotliLengthCode(brotliCopyBase, copyLength)
+		b.bits.write(brotliCopyExtra[copyCode], uint64(copyLength-brotliCopyBase[copyCode]))
+
+		for _, literal := range c.literals {
+			b.bits.write(uint(literalDepths[literal]), uint64(literalCodes[literal]))
+		}
+
+		// o último comando pode ter só literais, o bloco termina antes da distância
+		if c.copy > 0 {
+			code, extraBits, extra := brotliDistanceCode(c.distance)
+			b.bits.write(uint(distanceDepths[code]), uint64(distanceCodes[code]))
+			b.bits.write(extraBits, uint64(extra))
+		}
+	}
+}
+
+// match procura repetições de data[start:] no próprio data, que começa com o
+// histórico dos blocos anteriores.
+func (b *brotliWriter) match(data []byte, start int) []brotliCommand {
+	head := make([]int32, 1<<brotliHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(data))
+
+	insert := func(i int) {
+		if i+brotliMinMatch > len(data) {
+			return
+		}
+
+		h := brotliHash(data[i:])
+		prev[i] = head[h]
+		head[h] = int32(i)
+	}
+
+	for i := 0; i < start; i++ {
+		insert(i)
+	}
+
+	var commands []brotliCommand
+	literals := start
+	for i := start; i+brotliMinMatch <= len(data); {
+		bestLength, bestDistance := 0, 0
+		candidate := head[brotliHash(data[i:])]
+		for tries := 0; candidate >= 0 && tries < b.chain && i-int(candidate) <= brotliMaxDistance; tries++ {
+			length := 0
+			for i+length < len(data) && data[int(candidate)+length] == data[i+length] {
+				length++
+			}
+
+			if length > bestLength {
+				bestLength, bestDistance = length, i-int(candidate)
+			}
+			candidate = prev[candidate]
+		}
+
+		if bestLength < brotliMinMatch {
+			insert(i)
+			i++
+			continue
+		}
+
+		commands = append(commands, brotliCommand{literals: data[literals:i], copy: bestLength, distance: bestDistance})
+		for end := i + bestLength; i < end; i++ {
+			insert(i)
+		}
+		literals = i
+	}
+
+	if literals < len(data) {
+		commands = append(commands, brotliCommand{literals: data[literals:]})
+	}
+
+	return commands
+}
+
+func brotliHash(b []byte) uint32 {
+	v := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	return (v * 0x1e35a7bd) >> (32 - brotliHashBits)
+}
+
+func brotliLengthCode(base []int, n int) int {
+	code := 0
+	for code+1 < len(base) && base[code+1] <= n {
+		code++
+	}
+
+	return code
+}
+
+// brotliCommandCode junta os códigos do insert e do copy num símbolo do alfabeto
+// de comandos, sempre numa das faixas que leem a distância do stream.
+func brotliCommandCode(insertLength, copyLength int) int {
+	insert := brotliLengthCode(brotliInsertBase, insertLength)
+	copyCode := brotliLengthCode(brotliCopyBase, max(copyLength, 2))
+
+	var base int
+	switch {
+	case insert < 8 && copyCode < 8:
+		base = 128
+	case insert < 8 && copyCode < 16:
+		base = 192
+	case insert < 8:
+		base = 384
+	case insert < 16 && copyCode < 8:
+		base = 256
+	case insert < 16 && copyCode < 16:
+		base = 320
+	case insert < 16:
+		base = 512
+	case copyCode < 8:
+		base = 448
+	case copyCode < 16:
+		base = 576
+	default:
+		base = 640
+	}
+
+	return base + (insert&7)<<3 | copyCode&7
+}
+
+// brotliDistanceCode devolve o símbolo e os bits extras de uma distância com
+// NPOSTFIX e NDIRECT 0.
+func brotliDistanceCode(distance int) (int, uint, int) {
+	d := distance + 3
+	bits := uint(0)
+	for d>>(bits+1) > 1 {
+		bits++
+	}
+
+	prefix := d >> bits & 1
+	return 16 + 2*(int(bits)-1) + prefix, bits, d - (2+prefix)<<bits
+}
+
+type brotliBits struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+func (b *brotliBits) write(n uint, v uint64) {
+	b.acc |= v << b.n
+	b.n += n
+	for b.n >= 8 {
+		b.out = append(b.out, byte(b.acc))
+		b.acc >>= 8
+		b.n -= 8
+	}
+}
+
+func (b *brotliBits) align() {
+	if b.n > 0 {
+		b.write(8-b.n, 0)
+	}
+}
+
+// prefixCode grava o código de Huffman das contagens e devolve o tamanho e o
+// código (já invertido para o stream) de cada símbolo.
+func (b *brotliBits) prefixCode(counts []int, alphabetBits uint) ([]uint8, []uint16) {
+	depths := brotliDepths(counts, 15)
+
+	var symbols []int
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	if len(symbols) == 0 {
+		symbols = []int{0}
+	}
+
+	if len(symbols) <= 4 {
+		// código simples: os símbolos em ordem de tamanho, e com quatro símbolos um
+		// bit diz se os tamanhos são 2, 2, 2, 2 ou 1, 2, 3, 3
+		slices.SortStableFunc(symbols, func(a, c int) int { return int(depths[a]) - int(depths[c]) })
+		b.write(2, 1)
+		b.write(2, uint64(len(symbols)-1))
+		for _, symbol := range symbols {
+			b.write(alphabetBits, uint64(symbol))
+		}
+		if len(symbols) == 4 {
+			if depths[symbols[0]] == 1 {
+				b.write(1, 1)
+			} else {
+				b.write(1, 0)
+			}
+		}
+
+		return depths, brotliCodes(depths)
+	}
+
+	last := symbols[len(symbols)-1]
+	tokens, extras := brotliCodeLengthTokens(depths[:last+1])
+
+	tokenCounts := make([]int, 18)
+	for _, token := range tokens {
+		tokenCounts[token]++
+	}
+	tokenDepths := brotliDepths(tokenCounts, 5)
+	tokenCodes := brotliCodes(tokenDepths)
+
+	used := 0
+	for _, count := range tokenCounts {
+		if count > 0 {
+			used++
+		}
+	}
+
+	// HSKIP 0
+	b.write(2, 0)
+	if used == 1 {
+		// com um único símbolo os 18 tamanhos são gravados e ele ocupa zero bits
+		for _, symbol := range brotliCodeLengthOrder {
+			if tokenCounts[symbol] > 0 {
+				tokenDepths[symbol] = 1
+			}
+			brotliWriteCodeLengthLength(b, tokenDepths[symbol])
+		}
+		tokenDepths = make([]uint8, 18)
+	} else {
+		space := 32
+		for _, symbol := range brotliCodeLengthOrder {
+			brotliWriteCodeLengthLength(b, tokenDepths[symbol])
+			if tokenDepths[symbol] != 0 {
+				space -= 32 >> tokenDepths[symbol]
+				if space == 0 {
+					break
+				}
+			}
+		}
+	}
+
+	for i, token := range tokens {
+		b.write(uint(tokenDepths[token]), uint64(tokenCodes[token]))
+		if token == 17 {
+			b.write(3, uint64(extras[i]))
+		}
+	}
+
+	return depths, brotliCodes(depths)
+}
+
+// brotliWriteCodeLengthLength grava o tamanho (0 a 5) de um símbolo do código dos
+// tamanhos, que tem um código fixo próprio.
+func brotliWriteCodeLengthLength(b *brotliBits, depth uint8) {
+	codes := [][2]uint64{{2, 0}, {4, 7}, {3, 3}, {2, 2}, {2, 1}, {4, 15}}
+	b.write(uint(codes[depth][0]), codes[depth][1])
+}
+
+// brotliCodeLengthTokens troca as sequências de zeros pelo símbolo 17, que repete
+// o zero de 3 a 10 vezes e, em sequência, multiplica a repetição anterior por 8.
+func brotliCodeLengthTokens(depths []uint8) ([]uint8, []uint8) {
+	var tokens, extras []uint8
+	for i := 0; i < len(depths); {
+		if depths[i] != 0 {
+			tokens, extras = append(tokens, depths[i]), append(extras, 0)
+			i++
+			continue
+		}
+
+		run := 0
+		for i+run < len(depths) && depths[i+run] == 0 {
+			run++
+		}
+		i += run
+
+		if run == 11 {
+			tokens, extras = append(tokens, 0), append(extras, 0)
+			run--
+		}
+
+		if run < 3 {
+			for ; run > 0; run-- {
+				tokens, extras = append(tokens, 0), append(extras, 0)
+			}
+			continue
+		}
+
+		var repeat, extra []uint8
+		for run -= 3; ; run-- {
+			repeat, extra = append(repeat, 17), append(extra, uint8(run&7))
+			run >>= 3
+			if run == 0 {
+				break
+			}
+		}
+		slices.Reverse(extra)
+		tokens, extras = append(tokens, repeat...), append(extras, extra...)
+	}
+
+	return tokens, extras
+}
+
+// brotliDepths calcula os tamanhos de um código de Huffman com no máximo limit
+// bits. Quando a árvore passa do limite, as contagens pequenas são aumentadas até
+// ela ficar mais baixa.
+func brotliDepths(counts []int, limit int) []uint8 {
+	depths := make([]uint8, len(counts))
+
+	type node struct {
+		count, left, right, symbol int
+	}
+
+	for minimum := 1; ; minimum *= 2 {
+		var nodes []node
+		for symbol, count := range counts {
+			if count > 0 {
+				nodes = append(nodes, node{count: max(count, minimum), left: -1, right: -1, symbol: symbol})
+			}
+		}
+
+		if len(nodes) < 2 {
+			return depths
+		}
+
+		slices.SortStableFunc(nodes, func(a, b node) int { return a.count - b.count })
+
+		// as folhas estão ordenadas e os nós internos são criados em ordem crescente,
+		// então o menor está sempre no começo de uma das duas filas
+		leaves, nextLeaf, nextInternal := len(nodes), 0, len(nodes)
+		smallest := func() int {
+			if nextLeaf < leaves && (nextInternal >= len(nodes) || nodes[nextLeaf].count <= nodes[nextInternal].count) {
+				nextLeaf++
+				return nextLeaf - 1
+			}
+			nextInternal++
+			return nextInternal - 1
+		}
+
+		for range leaves - 1 {
+			left := smallest()
+			right := smallest()
+			nodes = append(nodes, node{count: nodes[left].count + nodes[right].count, left: left, right: right, symbol: -1})
+		}
+
+		depth := make([]int, len(nodes))
+		tooDeep := false
+		for i := len(nodes) - 1; i >= 0; i-- {
+			if nodes[i].symbol >= 0 {
+				tooDeep = tooDeep || depth[i] > limit
+				continue
+			}
+			depth[nodes[i].left] = depth[i] + 1
+			depth[nodes[i].right] = depth[i] + 1
+		}
+
+		if tooDeep {
+			continue
+		}
+
+		for i := range leaves {
+			depths[nodes[i].symbol] = uint8(depth[i])
+		}
+
+		return depths
+	}
+}
+
+// brotliCodes monta o código canônico dos tamanhos, como no deflate, com os bits
+// invertidos porque o stream é lido a partir do bit menos significativo.
+func brotliCodes(depths []uint8) []uint16 {
+	var count, next [16]int
+	for _, depth := range depths {
+		if depth > 0 {
+			count[depth]++
+		}
+	}
+
+	code := 0
+	for bits := 1; bits < 16; bits++ {
+		code = (code + count[bits-1]) << 1
+		next[bits] = code
+	}
+
+	codes := make([]uint16, len(depths))
+	for symbol, depth := range depths {
+		if depth == 0 {
+			continue
+		}
+
+		reversed := 0
+		for i := range int(depth) {
+			reversed |= (next[depth] >> i & 1) << (int(depth) - 1 - i)
+		}
+		codes[symbol] = uint16(reversed)
+		next[depth]++
+	}
+
+	return codes
+}
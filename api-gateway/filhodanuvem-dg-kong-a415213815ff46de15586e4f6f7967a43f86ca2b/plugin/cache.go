@@ -0,0 +1,500 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+const (
+	CacheStatusHit    = "HIT"
+	CacheStatusMiss   = "MISS"
+	CacheStatusBypass = "BYPASS"
+)
+
+type cacheEntry struct {
+	key       string
+	status    int
+	header    http.Header
+	body      []byte
+	storedAt  time.Time
+	expiresAt time.Time
+}
+
+func (e *cacheEntry) size() int {
+	size := len(e.key) + len(e.body)
+	for k, values := range e.header {
+		size += len(k)
+		for _, v := range values {
+			size += len(v)
+		}
+	}
+
+	return size
+}
+
+// CacheStore é um cache LRU limitado pelo tamanho em bytes das respostas guardadas.
+type CacheStore struct {
+	mu         sync.Mutex
+	maxBytes   int
+	usedBytes  int
+	ll         *list.List
+	items      map[string]*list.Element
+	varyByBase map[string][]string
+}
+
+func NewCacheStore(maxBytes int) *CacheStore {
+	return &CacheStore{
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      map[string]*list.Element{},
+		varyByBase: map[string][]string{},
+	}
+}
+
+func (c *CacheStore) get(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if now.After(entry.expiresAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+
+	return entry, true
+}
+
+func (c *CacheStore) set(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := entry.size()
+	if size > c.maxBytes {
+		return
+	}
+
+	if elem, ok := c.items[entry.key]; ok {
+		c.removeElement(elem)
+	}
+
+	c.items[entry.key] = c.ll.PushFront(entry)
+	c.usedBytes += size
+
+	for c.usedBytes > c.maxBytes {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *CacheStore) varyHeaders(baseKey string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.varyByBase[baseKey]
+}
+
+func (c *CacheStore) setVaryHeaders(baseKey string, headers []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(headers) == 0 {
+		delete(c.varyByBase, baseKey)
+		return
+	}
+	c.varyByBase[baseKey] = headers
+}
+
+// Purge remove a entrada com a chave informada (o valor do header X-Cache-Key).
+func (c *CacheStore) Purge(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.removeElement(elem)
+
+	return true
+}
+
+func (c *CacheStore) PurgeAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	c.varyByBase = map[string][]string{}
+	c.usedBytes = 0
+}
+
+func (c *CacheStore) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *CacheStore) removeElement(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.usedBytes -= entry.size()
+}
+
+var (
+	cacheStoresMu sync.Mutex
+	cacheStores   = map[string]*CacheStore{}
+)
+
+// FindCacheStore devolve o cache com o nome informado, criando ele na primeira vez.
+// O tamanho só é usado na criação.
+func FindCacheStore(name string, maxBytes int) *CacheStore {
+	cacheStoresMu.Lock()
+	defer cacheStoresMu.Unlock()
+
+	cache, ok := cacheStores[name]
+	if !ok {
+		cache = NewCacheStore(maxBytes)
+		cacheStores[name] = cache
+	}
+
+	return cache
+}
+
+// PurgeCacheKey remove a chave de todos os caches e diz se ela existia em algum.
+func PurgeCacheKey(key string) bool {
+	cacheStoresMu.Lock()
+	defer cacheStoresMu.Unlock()
+
+	purged := false
+	for _, cache := range cacheStores {
+		if cache.Purge(key) {
+			purged = true
+		}
+	}
+
+	return purged
+}
+
+func PurgeAllCacheStores() {
+	cacheStoresMu.Lock()
+	defer cacheStoresMu.Unlock()
+
+	for _, cache := range cacheStores {
+		cache.PurgeAll()
+	}
+}
+
+type ProxyCacheConfig struct {
+	CacheName      string   `input:"cache_name" default:"default"`
+	MemorySize     int      `input:"memory_size" default:"10485760"`
+	TTL            int      `input:"ttl" default:"300"`
+	RequestMethods []string `input:"request_methods" default:"GET,HEAD"`
+	ResponseCodes  []int    `input:"response_codes" default:"200,301,404"`
+	VaryHeaders    []string `input:"vary_headers"`
+	// CacheAuthorized guarda também as respostas de requests com Authorization. Mesmo
+	// ligado, cada consumer tem as suas próprias entradas
+	CacheAuthorized bool `input:"cache_authorized"`
+}
+
+func (c *ProxyCacheConfig) Validate() error {
+	if c.MemorySize <= 0 || c.TTL <= 0 {
+		return errors.New("memory_size and ttl must be greater than zero")
+	}
+
+	return nil
+}
+
+func ProxyCache(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[ProxyCacheConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(settings.RequestMethods, r.Method) || requestBypassesCache(r) ||
+			(!settings.CacheAuthorized && r.Header.Get("Authorization") != "") {
+			w.Header().Set("X-Cache-Status", CacheStatusBypass)
+			f(w, r)
+			return
+		}
+
+		cache := FindCacheStore(settings.CacheName, settings.MemorySize)
+		baseKey := cacheBaseKey(r, settings.VaryHeaders)
+		key := cacheKey(baseKey, r, cache.varyHeaders(baseKey))
+		now := time.Now()
+
+		if entry, ok := cache.get(key, now); ok {
+			copyCachedHeader(w.Header(), entry.header)
+			w.Header().Set("Age", strconv.Itoa(int(now.Sub(entry.storedAt).Seconds())))
+			w.Header().Set("X-Cache-Key", key)
+			w.Header().Set("X-Cache-Status", CacheStatusHit)
+			w.WriteHeader(entry.status)
+			if r.Method != http.MethodHead {
+				w.Write(entry.body)
+			}
+			return
+		}
+
+		w.Header().Set("X-Cache-Key", key)
+		w.Header().Set("X-Cache-Status", CacheStatusMiss)
+		before := w.Header().Clone()
+
+		recorder := &cacheRecorder{ResponseWriter: w, limit: settings.MemorySize}
+		f(recorder, r)
+
+		if recorder.hijacked || recorder.overflow || !slices.Contains(settings.ResponseCodes, recorder.statusCode()) {
+			return
+		}
+
+		// um cookie é de um cliente só, como a sessão ou o sticky_cookie do split
+		if len(recorder.header.Values("Set-Cookie")) > 0 {
+			return
+		}
+
+		header := upstreamHeader(recorder.header, before)
+
+		expiresIn, cacheable := responseTTL(header, time.Duration(settings.TTL)*time.Second)
+		if !cacheable {
+			return
+		}
+
+		vary := responseVary(header)
+		if slices.Contains(vary, "*") {
+			return
+		}
+		cache.setVaryHeaders(baseKey, vary)
+
+		cache.set(&cacheEntry{
+			key:       cacheKey(baseKey, r, vary),
+			status:    recorder.statusCode(),
+			header:    header,
+			body:      recorder.body.Bytes(),
+			storedAt:  now,
+			expiresAt: now.Add(expiresIn),
+		})
+	}
+}
+
+// cacheBaseKey separa as entradas por host, rota, serviço de destino e consumer,
+// assim a resposta autenticada de um consumer nunca é entregue para outro e rotas
+// com o mesmo path em hosts ou serviços diferentes não se misturam.
+func cacheBaseKey(r *http.Request, varyHeaders []string) string {
+	var b strings.Builder
+	b.WriteString(r.Host)
+	b.WriteString("\n")
+
+	if match, ok := kong.RouteMatchFromContext(r.Context()); ok {
+		upstream := match.Service
+		if match.Upstream != nil {
+			upstream = match.Upstream
+		}
+		b.WriteString("route " + match.Service.Name + "/" + match.Route.Name + " -> " + upstream.Name + "\n")
+	}
+
+	if consumer, ok := kong.ConsumerFromContext(r.Context()); ok {
+		b.WriteString("consumer " + consumer.Name + "\n")
+	} else if authorization := r.Header.Get("Authorization"); authorization != "" {
+		// um jwt_auth sem consumers só tem o token para separar os clientes; a chave
+		// final é um hash, então o token não aparece no X-Cache-Key
+		b.WriteString("authorization " + authorization + "\n")
+	}
+
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(r.URL.Path)
+	b.WriteString("?")
+	b.WriteString(r.URL.Query().Encode())
+
+	for _, header := range varyHeaders {
+		b.WriteString("\n")
+		b.WriteString(http.CanonicalHeaderKey(header))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(header), ","))
+	}
+
+	return b.String()
+}
+
+// cacheKey junta a chave base com os headers do Vary da resposta e devolve um hash,
+// que é o que vai no header X-Cache-Key e é usado para o purge.
+func cacheKey(baseKey string, r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(baseKey)
+
+	for _, header := range vary {
+		b.WriteString("\nvary ")
+		b.WriteString(header)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(header), ","))
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestBypassesCache(r *http.Request) bool {
+	directives := cacheControlDirectives(r.Header)
+	_, noCache := directives["no-cache"]
+	_, noStore := directives["no-store"]
+
+	return noCache || noStore
+}
+
+// responseTTL respeita o Cache-Control do upstream: private, no-store e no-cache
+// impedem o cache e s-maxage/max-age substituem o ttl do plugin.
+func responseTTL(header http.Header, ttl time.Duration) (time.Duration, bool) {
+	directives := cacheControlDirectives(header)
+	for _, directive := range []string{"private", "no-store", "no-cache"} {
+		if _, ok := directives[directive]; ok {
+			return 0, false
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		value, ok := directives[directive]
+		if !ok {
+			continue
+		}
+
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	return ttl, true
+}
+
+func responseVary(header http.Header) []string {
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	return slices.Compact(vary)
+}
+
+func cacheControlDirectives(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+
+	return directives
+}
+
+// upstreamHeader devolve os headers da resposta sem os que já estavam na resposta
+// antes do cache, colocados por plugins como o rate_limiting. Eles valem para uma
+// request só e são colocados de novo a cada HIT.
+func upstreamHeader(header, before http.Header) http.Header {
+	stored := http.Header{}
+	for k, values := range header {
+		if !slices.Equal(values, before[k]) {
+			stored[k] = slices.Clone(values)
+		}
+	}
+
+	return stored
+}
+
+func copyCachedHeader(dst, src http.Header) {
+	for k, values := range src {
+		dst[k] = slices.Clone(values)
+	}
+}
+
+// cacheRecorder repassa a resposta para o cliente enquanto guarda uma cópia dela
+// para o cache. Se o body passar do limite, a cópia é descartada.
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+	hijacked bool
+}
+
+func (r *cacheRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+
+	return r.status
+}
+
+func (r *cacheRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	if !r.overflow {
+		if r.body.Len()+len(p) > r.limit {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(p)
+		}
+	}
+
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *cacheRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *cacheRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.hijacked = true
+
+	return hijacker.Hijack()
+}
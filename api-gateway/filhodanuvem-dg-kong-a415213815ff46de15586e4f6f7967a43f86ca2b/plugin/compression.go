@@ -0,0 +1,196 @@
+package plugin
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+// encodings suportados, na ordem de preferência quando o cliente aceita mais de um com o mesmo peso
+var compressionEncodings = []string{"br", "gzip", "deflate"}
+
+// CompressionConfig comprime as respostas para clientes que aceitam br, gzip ou deflate.
+type CompressionConfig struct {
+	Encodings []string `input:"encodings" default:"br,gzip,deflate"`
+	// ContentTypes aceita curinga no subtipo, como text/*
+	ContentTypes []string `input:"content_types" default:"text/*,application/json,application/javascript,application/xml,image/svg+xml"`
+	// MinSize em bytes; só vale quando o upstream informa o Content-Length, respostas
+	// em streaming são sempre comprimidas
+	MinSize int `input:"min_size" default:"1024"`
+	// Level vai de 1 (mais rápido) a 9 (menor resposta), -1 é o padrão do compress/flate
+	Level int `input:"level" default:"-1"`
+	// DecompressUpstream pede a resposta do upstream sem compressão, assim plugins
+	// como o response_transformer recebem o body puro e ele é comprimido só no fim
+	DecompressUpstream bool `input:"decompress_upstream"`
+}
+
+func (c *CompressionConfig) Validate() error {
+	var errs []error
+
+	if len(c.Encodings) == 0 {
+		errs = append(errs, errors.New("at least one encoding is required"))
+	}
+
+	for _, encoding := range c.Encodings {
+		if !slices.Contains(compressionEncodings, encoding) {
+			errs = append(errs, fmt.Errorf("unsupported encoding %q, expected br, gzip or deflate", encoding))
+		}
+	}
+
+	for _, contentType := range c.ContentTypes {
+		if _, _, err := mime.ParseMediaType(contentType); err != nil {
+			errs = append(errs, fmt.Errorf("invalid content type %q", contentType))
+		}
+	}
+
+	if c.MinSize < 0 {
+		errs = append(errs, fmt.Errorf("min_size can not be negative, got %d", c.MinSize))
+	}
+
+	if c.Level != flate.DefaultCompression && (c.Level < flate.BestSpeed || c.Level > flate.BestCompression) {
+		errs = append(errs, fmt.Errorf("level must be between 1 and 9 or -1, got %d", c.Level))
+	}
+
+	return errors.Join(errs...)
+}
+
+type compressionContextKey struct{}
+
+// Compression é a fase access do compression. O encoding é escolhido aqui, antes do
+// Accept-Encoding ser removido da request quando decompress_upstream está ligado.
+func Compression(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[CompressionConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Values("Accept-Encoding"), settings.Encodings)
+
+		if settings.DecompressUpstream {
+			// sem Accept-Encoding na request, o transport do gateway pede gzip ao
+			// upstream e descomprime a resposta enquanto ela chega
+			r.Header.Del("Accept-Encoding")
+		}
+
+		f(w, r.WithContext(context.WithValue(r.Context(), compressionContextKey{}, encoding)))
+	}
+}
+
+// CompressionBody é a fase body_filter do compression, que comprime a resposta
+// enquanto ela é enviada, sem esperar o body inteiro.
+func CompressionBody(p kong.Plugin, r *http.Request, res *ResponseHead, w io.Writer) io.WriteCloser {
+	settings, err := Settings[CompressionConfig](p)
+	if err != nil || !compressible(r, res, settings) {
+		return nil
+	}
+
+	res.Header.Add("Vary", "Accept-Encoding")
+
+	encoding, _ := r.Context().Value(compressionContextKey{}).(string)
+	if encoding == "" {
+		return nil
+	}
+
+	res.Header.Set("Content-Encoding", encoding)
+	res.Header.Del("Content-Length")
+	// o body comprimido é outro, então o ETag forte deixa de valer
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("ETag", "W/"+etag)
+	}
+
+	switch encoding {
+	case "br":
+		return newBrotliWriter(w, settings.Level)
+	case "deflate":
+		writer, _ := flate.NewWriter(w, settings.Level)
+		return writer
+	}
+
+	writer, _ := gzip.NewWriterLevel(w, settings.Level)
+	return writer
+}
+
+func compressible(r *http.Request, res *ResponseHead, settings *CompressionConfig) bool {
+	if r.Method == http.MethodHead || res.StatusCode < http.StatusOK ||
+		res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return false
+	}
+
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "" && encoding != "identity" {
+		return false
+	}
+
+	if strings.Contains(strings.ToLower(res.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+
+	if length, err := strconv.Atoi(res.Header.Get("Content-Length")); err == nil && length < settings.MinSize {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(settings.ContentTypes, func(contentType string) bool {
+		if prefix, ok := strings.CutSuffix(contentType, "/*"); ok {
+			return strings.HasPrefix(mediaType, prefix+"/")
+		}
+
+		return mediaType == contentType
+	})
+}
+
+// negotiateEncoding escolhe, entre os encodings configurados, o de maior peso (q) no
+// Accept-Encoding. Um encoding com q=0 é recusado, e * vale para os não listados.
+// leia: https://www.rfc-editor.org/rfc/rfc9110#section-12.5.3
+func negotiateEncoding(values []string, encodings []string) string {
+	weights := map[string]float64{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+
+			q := 1.0
+			if param, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if parsed, err := strconv.ParseFloat(param, 64); err == nil {
+					q = parsed
+				}
+			}
+			weights[name] = q
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, preferred := range compressionEncodings {
+		if !slices.Contains(encodings, preferred) {
+			continue
+		}
+
+		q, ok := weights[preferred]
+		if !ok {
+			q, ok = weights["*"]
+		}
+
+		if ok && q > bestWeight {
+			best, bestWeight = preferred, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+// CORSConfig aceita em origins a origem exata (https://app.example.com), subdomínios
+// com curinga (https://*.example.com) ou * para qualquer origem.
+type CORSConfig struct {
+	Origins []string `input:"origins" default:"*"`
+	Methods []string `input:"methods" default:"GET,HEAD,PUT,PATCH,POST,DELETE,OPTIONS"`
+	// Headers vazio aceita os headers que o browser pedir no preflight
+	Headers        []string `input:"headers"`
+	ExposedHeaders []string `input:"exposed_headers"`
+	Credentials    bool     `input:"credentials"`
+	// MaxAge é por quantos segundos o browser pode guardar o preflight
+	MaxAge int `input:"max_age"`
+	// PreflightContinue manda o preflight para o upstream em vez de responder no gateway
+	PreflightContinue bool `input:"preflight_continue"`
+}
+
+func (c *CORSConfig) Validate() error {
+	for _, origin := range c.Origins {
+		if origin == "*" {
+			continue
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "*.", "", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || strings.Count(origin, "*") > 1 {
+			return fmt.Errorf("invalid origin %q", origin)
+		}
+
+		if strings.Contains(origin, "*") && !strings.HasPrefix(origin, u.Scheme+"://*.") {
+			return fmt.Errorf("invalid origin %q, wildcard is only accepted as the first label of the host", origin)
+		}
+	}
+
+	if c.MaxAge < 0 {
+		return fmt.Errorf("max_age can not be negative, got %d", c.MaxAge)
+	}
+
+	return nil
+}
+
+// allowOrigin devolve o valor do Access-Control-Allow-Origin para a origem, ou
+// vazio quando ela não é aceita. Com credentials, o browser não aceita *, então
+// a própria origem é devolvida.
+func (c *CORSConfig) allowOrigin(origin string) string {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+
+	for _, pattern := range c.Origins {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), "/")
+
+		if pattern == "*" {
+			if c.Credentials {
+				return origin
+			}
+			return "*"
+		}
+
+		if prefix, suffix, ok := strings.Cut(pattern, "*"); ok {
+			sub, found := strings.CutPrefix(origin, prefix)
+			if found && strings.HasSuffix(sub, suffix) {
+				label := strings.TrimSuffix(sub, suffix)
+				if label != "" && !strings.ContainsAny(label, "/:@") {
+					return origin
+				}
+			}
+			continue
+		}
+
+		if origin == pattern {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// decorate coloca na resposta os headers que valem para preflights e requests.
+func (c *CORSConfig) decorate(header http.Header, allowed string) {
+	header.Set("Access-Control-Allow-Origin", allowed)
+	if c.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	// a resposta muda com a origem, então caches precisam separar por ela
+	if allowed != "*" && !slices.Contains(header.Values("Vary"), "Origin") {
+		header.Add("Vary", "Origin")
+	}
+}
+
+// CORS responde os preflights sem chamar o upstream.
+func CORS(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[CORSConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !kong.IsPreflight(r) || settings.PreflightContinue {
+			f(w, r)
+			return
+		}
+
+		allowed := settings.allowOrigin(r.Header.Get("Origin"))
+		method := r.Header.Get("Access-Control-Request-Method")
+		if allowed == "" || !slices.Contains(settings.Methods, method) {
+			// sem os headers de CORS o browser bloqueia a request
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		settings.decorate(w.Header(), allowed)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(settings.Methods, ", "))
+
+		headers := strings.Join(settings.Headers, ", ")
+		if len(settings.Headers) == 0 {
+			headers = r.Header.Get("Access-Control-Request-Headers")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+		if headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+
+		if settings.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(settings.MaxAge))
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CORSHeaders é a fase header_filter do cors, que libera a resposta das requests
+// cross-origin para o browser.
+func CORSHeaders(p kong.Plugin, r *http.Request, res *ResponseHead) {
+	settings, err := Settings[CORSConfig](p)
+	if err != nil {
+		return
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || (kong.IsPreflight(r) && !settings.PreflightContinue) {
+		return
+	}
+
+	allowed := settings.allowOrigin(origin)
+	if allowed == "" {
+		return
+	}
+
+	settings.decorate(res.Header, allowed)
+	if len(settings.ExposedHeaders) > 0 {
+		res.Header.Set("Access-Control-Expose-Headers", strings.Join(settings.ExposedHeaders, ", "))
+	}
+}
@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcCodes são os nomes dos status do gRPC, pelo número.
+// leia: https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+var grpcCodes = []string{
+	"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+const (
+	grpcUnknown          = 2
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+// GRPCStatus é o resultado de uma chamada gRPC como o cliente enxerga.
+type GRPCStatus struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message,omitempty"`
+}
+
+// IsGRPC diz se a request é uma chamada gRPC.
+func IsGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return contentType == "application/grpc" || strings.HasPrefix(contentType, "application/grpc+") || strings.HasPrefix(contentType, "application/grpc;")
+}
+
+// GRPCStatusOf devolve o status da chamada gRPC, que vem no grpc-status dos trailers
+// (ou dos headers, numa resposta sem body). Quando a resposta não tem grpc-status,
+// como um 401 de um plugin ou um 502 do gateway, o status HTTP é convertido como o
+// cliente gRPC faz. O ok é false quando a request não é gRPC.
+func GRPCStatusOf(r *http.Request, entry LogEntry) (GRPCStatus, bool) {
+	if !IsGRPC(r) {
+		return GRPCStatus{}, false
+	}
+
+	for _, h := range []http.Header{entry.ResponseTrailer, entry.ResponseHeader} {
+		value := h.Get("Grpc-Status")
+		if value == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(value)
+		if err != nil || code < 0 {
+			code = grpcUnknown
+		}
+
+		// o grpc-message vem percent-encoded
+		message, err := url.PathUnescape(h.Get("Grpc-Message"))
+		if err != nil {
+			message = h.Get("Grpc-Message")
+		}
+
+		return newGRPCStatus(code, message), true
+	}
+
+	return newGRPCStatus(grpcCodeFromHTTP(entry.Status), ""), true
+}
+
+func newGRPCStatus(code int, message string) GRPCStatus {
+	name := "UNKNOWN"
+	if code < len(grpcCodes) {
+		name = grpcCodes[code]
+	}
+
+	return GRPCStatus{Code: code, Name: name, Message: message}
+}
+
+// grpcCodeFromHTTP segue a tabela usada pelos clientes gRPC para respostas sem grpc-status.
+// leia: https://github.com/grpc/grpc/blob/master/doc/http-grpc-status-mapping.md
+func grpcCodeFromHTTP(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInternal
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcUnavailable
+	}
+
+	return grpcUnknown
+}
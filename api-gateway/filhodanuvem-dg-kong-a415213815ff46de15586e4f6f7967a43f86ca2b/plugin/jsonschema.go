@@ -0,0 +1,705 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// jsonSchema é um JSON Schema já compilado. Só um subconjunto da especificação é
+// suportado: type, enum, const, properties, required, additionalProperties,
+// min/maxProperties, items, min/maxItems, uniqueItems, min/maxLength, pattern,
+// format, minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf, allOf,
+// anyOf, oneOf, not e $ref local (#/definitions/x ou #/$defs/x). Palavras que só
+// descrevem o schema, como title e description, são ignoradas; as que mudariam a
+// validação e não são suportadas são recusadas na compilação.
+type jsonSchema struct {
+	// reject vem do schema false, que não aceita nada
+	reject bool
+
+	types    []string
+	enum     []any
+	constant any
+	hasConst bool
+
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties *jsonSchema
+	minProperties        *int
+	maxProperties        *int
+
+	items       *jsonSchema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+	format    string
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	allOf []*jsonSchema
+	anyOf []*jsonSchema
+	oneOf []*jsonSchema
+	not   *jsonSchema
+
+	// ref é resolvido depois da compilação, porque o schema pode apontar para ele mesmo
+	ref *jsonSchema
+}
+
+// SchemaViolation é um problema encontrado na validação. Field é o caminho do
+// valor com ponto para objetos e colchetes para listas, como items[0].amount.
+type SchemaViolation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+var jsonSchemaTypes = []string{"object", "array", "string", "number", "integer", "boolean", "null"}
+
+var unsupportedKeywords = []string{
+	"patternProperties", "propertyNames", "dependencies", "dependentRequired", "dependentSchemas",
+	"if", "then", "else", "contains", "prefixItems", "unevaluatedProperties", "unevaluatedItems",
+}
+
+var jsonSchemaFormats = map[string]func(string) bool{
+	"email": func(s string) bool {
+		addr, err := mail.ParseAddress(s)
+		return err == nil && addr.Address == s
+	},
+	"uuid": regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	},
+	"date": func(s string) bool {
+		_, err := time.Parse(time.DateOnly, s)
+		return err == nil
+	},
+	"uri": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	},
+	"ipv4": func(s string) bool {
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is4()
+	},
+	"ipv6": func(s string) bool {
+		addr, err := netip.ParseAddr(s)
+		return err == nil && addr.Is6()
+	},
+}
+
+// SchemaFormatSupported diz se o format é conferido pelo request_validator.
+func SchemaFormatSupported(name string) bool {
+	_, ok := jsonSchemaFormats[name]
+	return ok
+}
+
+type schemaCompiler struct {
+	root any
+	refs map[string]*jsonSchema
+}
+
+// compileJSONSchema compila document, que vem do YAML do config.yaml ou de um
+// arquivo JSON.
+func compileJSONSchema(document any) (*jsonSchema, error) {
+	c := &schemaCompiler{root: document, refs: map[string]*jsonSchema{}}
+	return c.compile("#", document)
+}
+
+func (c *schemaCompiler) compile(path string, document any) (*jsonSchema, error) {
+	s := &jsonSchema{}
+
+	switch document := document.(type) {
+	case bool:
+		s.reject = !document
+		return s, nil
+	case map[string]any:
+		for _, keyword := range unsupportedKeywords {
+			if _, ok := document[keyword]; ok {
+				return nil, fmt.Errorf("%s: keyword %q is not supported", path, keyword)
+			}
+		}
+
+		return s, c.compileObject(path, s, document)
+	}
+
+	return nil, fmt.Errorf("%s: schema must be an object or a boolean", path)
+}
+
+func (c *schemaCompiler) compileObject(path string, s *jsonSchema, document map[string]any) error {
+	var err error
+
+	if ref, ok := document["$ref"]; ok {
+		pointer, ok := ref.(string)
+		if !ok {
+			return fmt.Errorf("%s: $ref must be a string", path)
+		}
+
+		if s.ref, err = c.resolve(pointer); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	switch types := document["type"].(type) {
+	case nil:
+	case string:
+		s.types = []string{types}
+	case []any:
+		for _, t := range types {
+			name, ok := t.(string)
+			if !ok {
+				return fmt.Errorf("%s: type must be a string or a list of strings", path)
+			}
+			s.types = append(s.types, name)
+		}
+	default:
+		return fmt.Errorf("%s: type must be a string or a list of strings", path)
+	}
+	for _, t := range s.types {
+		if !slices.Contains(jsonSchemaTypes, t) {
+			return fmt.Errorf("%s: unknown type %q", path, t)
+		}
+	}
+
+	if enum, ok := document["enum"]; ok {
+		values, ok := enum.([]any)
+		if !ok {
+			return fmt.Errorf("%s: enum must be a list", path)
+		}
+		for _, value := range values {
+			s.enum = append(s.enum, normalizeJSON(value))
+		}
+	}
+
+	if constant, ok := document["const"]; ok {
+		s.constant, s.hasConst = normalizeJSON(constant), true
+	}
+
+	if properties, ok := document["properties"]; ok {
+		items, ok := properties.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: properties must be an object", path)
+		}
+
+		s.properties = map[string]*jsonSchema{}
+		for _, name := range sortedKeys(items) {
+			if s.properties[name], err = c.compile(path+"/properties/"+name, items[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if required, ok := document["required"]; ok {
+		names, ok := required.([]any)
+		if !ok {
+			return fmt.Errorf("%s: required must be a list of strings", path)
+		}
+		for _, name := range names {
+			name, ok := name.(string)
+			if !ok {
+				return fmt.Errorf("%s: required must be a list of strings", path)
+			}
+			s.required = append(s.required, name)
+		}
+	}
+
+	if additional, ok := document["additionalProperties"]; ok {
+		if s.additionalProperties, err = c.compile(path+"/additionalProperties", additional); err != nil {
+			return err
+		}
+	}
+
+	if items, ok := document["items"]; ok {
+		if s.items, err = c.compile(path+"/items", items); err != nil {
+			return err
+		}
+	}
+
+	if unique, ok := document["uniqueItems"]; ok {
+		if s.uniqueItems, ok = unique.(bool); !ok {
+			return fmt.Errorf("%s: uniqueItems must be a boolean", path)
+		}
+	}
+
+	for keyword, dst := range map[string]**int{
+		"minProperties": &s.minProperties,
+		"maxProperties": &s.maxProperties,
+		"minItems":      &s.minItems,
+		"maxItems":      &s.maxItems,
+		"minLength":     &s.minLength,
+		"maxLength":     &s.maxLength,
+	} {
+		value, ok := document[keyword]
+		if !ok {
+			continue
+		}
+
+		n, ok := jsonNumber(value)
+		if !ok || n < 0 || n != math.Trunc(n) {
+			return fmt.Errorf("%s: %s must be a non negative integer", path, keyword)
+		}
+		limit := int(n)
+		*dst = &limit
+	}
+
+	for keyword, dst := range map[string]**float64{
+		"minimum":          &s.minimum,
+		"maximum":          &s.maximum,
+		"exclusiveMinimum": &s.exclusiveMinimum,
+		"exclusiveMaximum": &s.exclusiveMaximum,
+		"multipleOf":       &s.multipleOf,
+	} {
+		value, ok := document[keyword]
+		if !ok {
+			continue
+		}
+
+		n, ok := jsonNumber(value)
+		if !ok {
+			return fmt.Errorf("%s: %s must be a number", path, keyword)
+		}
+		*dst = &n
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return fmt.Errorf("%s: multipleOf must be greater than 0", path)
+	}
+
+	if pattern, ok := document["pattern"]; ok {
+		expr, ok := pattern.(string)
+		if !ok {
+			return fmt.Errorf("%s: pattern must be a string", path)
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %w", path, expr, err)
+		}
+	}
+
+	if format, ok := document["format"]; ok {
+		name, ok := format.(string)
+		if _, known := jsonSchemaFormats[name]; !ok || !known {
+			return fmt.Errorf("%s: format %v is not supported", path, format)
+		}
+		s.format = name
+	}
+
+	for keyword, dst := range map[string]*[]*jsonSchema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		value, ok := document[keyword]
+		if !ok {
+			continue
+		}
+
+		schemas, ok := value.([]any)
+		if !ok || len(schemas) == 0 {
+			return fmt.Errorf("%s: %s must be a non empty list", path, keyword)
+		}
+
+		for i, schema := range schemas {
+			compiled, err := c.compile(fmt.Sprintf("%s/%s/%d", path, keyword, i), schema)
+			if err != nil {
+				return err
+			}
+			*dst = append(*dst, compiled)
+		}
+	}
+
+	if not, ok := document["not"]; ok {
+		if s.not, err = c.compile(path+"/not", not); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolve compila o alvo de um $ref local. O schema fica registrado antes de ser
+// compilado, assim um $ref recursivo encontra ele em vez de compilar para sempre.
+func (c *schemaCompiler) resolve(pointer string) (*jsonSchema, error) {
+	if s, ok := c.refs[pointer]; ok {
+		return s, nil
+	}
+
+	if pointer != "#" && !strings.HasPrefix(pointer, "#/") {
+		return nil, fmt.Errorf("only local $ref are supported, got %q", pointer)
+	}
+
+	target := c.root
+	for _, token := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(pointer, "#"), "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+
+		object, ok := target.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("$ref %q not found", pointer)
+		}
+		if target, ok = object[token]; !ok {
+			return nil, fmt.Errorf("$ref %q not found", pointer)
+		}
+	}
+
+	s := &jsonSchema{}
+	c.refs[pointer] = s
+
+	compiled, err := c.compile(pointer, target)
+	if err != nil {
+		return nil, err
+	}
+	*s = *compiled
+
+	return s, nil
+}
+
+// validate devolve as violações de value, que precisa ter vindo de um json.Decoder
+// com UseNumber ou do normalizeJSON.
+func (s *jsonSchema) validate(field string, value any) []SchemaViolation {
+	if s.reject {
+		return []SchemaViolation{{Field: field, Message: "is not allowed"}}
+	}
+
+	var violations []SchemaViolation
+	fail := func(format string, args ...any) {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.ref != nil {
+		violations = append(violations, s.ref.validate(field, value)...)
+	}
+
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return isJSONType(value, t) }) {
+		fail("must be of type %s, got %s", strings.Join(s.types, " or "), jsonTypeOf(value))
+		// as outras regras dependem do tipo
+		return violations
+	}
+
+	normalized := normalizeJSON(value)
+	if s.enum != nil && !slices.ContainsFunc(s.enum, func(v any) bool { return reflect.DeepEqual(v, normalized) }) {
+		fail("must be one of %s", formatJSON(s.enum))
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, normalized) {
+		fail("must be %s", formatJSON(s.constant))
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		violations = append(violations, s.validateObject(field, value)...)
+	case []any:
+		violations = append(violations, s.validateArray(field, value)...)
+	case string:
+		violations = append(violations, s.validateString(field, value)...)
+	default:
+		if n, ok := jsonNumber(value); ok {
+			violations = append(violations, s.validateNumber(field, n)...)
+		}
+	}
+
+	for _, schema := range s.allOf {
+		violations = append(violations, schema.validate(field, value)...)
+	}
+
+	if len(s.anyOf) > 0 && !slices.ContainsFunc(s.anyOf, func(schema *jsonSchema) bool { return len(schema.validate(field, value)) == 0 }) {
+		fail("must match at least one of the anyOf schemas")
+	}
+
+	if len(s.oneOf) > 0 {
+		matches := 0
+		for _, schema := range s.oneOf {
+			if len(schema.validate(field, value)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one of the oneOf schemas, matched %d", matches)
+		}
+	}
+
+	if s.not != nil && len(s.not.validate(field, value)) == 0 {
+		fail("must not match the not schema")
+	}
+
+	return violations
+}
+
+func (s *jsonSchema) validateObject(field string, object map[string]any) []SchemaViolation {
+	var violations []SchemaViolation
+
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			violations = append(violations, SchemaViolation{Field: joinField(field, name), Message: "is required"})
+		}
+	}
+
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf("must have at least %d properties", *s.minProperties)})
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf("must have at most %d properties", *s.maxProperties)})
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := s.properties[name]; ok {
+			violations = append(violations, property.validate(joinField(field, name), object[name])...)
+		} else if s.additionalProperties != nil {
+			violations = append(violations, s.additionalProperties.validate(joinField(field, name), object[name])...)
+		}
+	}
+
+	return violations
+}
+
+func (s *jsonSchema) validateArray(field string, items []any) []SchemaViolation {
+	var violations []SchemaViolation
+
+	if s.minItems != nil && len(items) < *s.minItems {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf("must have at least %d items", *s.minItems)})
+	}
+	if s.maxItems != nil && len(items) > *s.maxItems {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf("must have at most %d items", *s.maxItems)})
+	}
+
+	if s.uniqueItems {
+		normalized := normalizeJSON(items).([]any)
+		for i := range normalized {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(normalized[i], normalized[j]) {
+					violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf("must have unique items, %d and %d are equal", j, i)})
+				}
+			}
+		}
+	}
+
+	if s.items != nil {
+		for i, item := range items {
+			violations = append(violations, s.items.validate(fmt.Sprintf("%s[%d]", field, i), item)...)
+		}
+	}
+
+	return violations
+}
+
+func (s *jsonSchema) validateString(field, value string) []SchemaViolation {
+	var violations []SchemaViolation
+	fail := func(format string, args ...any) {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(value)
+	if s.minLength != nil && length < *s.minLength {
+		fail("must have at least %d characters", *s.minLength)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		fail("must have at most %d characters", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(value) {
+		fail("must match pattern %q", s.pattern.String())
+	}
+	if s.format != "" && !jsonSchemaFormats[s.format](value) {
+		fail("must be a valid %s", s.format)
+	}
+
+	return violations
+}
+
+func (s *jsonSchema) validateNumber(field string, n float64) []SchemaViolation {
+	var violations []SchemaViolation
+	fail := func(format string, args ...any) {
+		violations = append(violations, SchemaViolation{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.minimum != nil && n < *s.minimum {
+		fail("must be >= %s", formatNumber(*s.minimum))
+	}
+	if s.maximum != nil && n > *s.maximum {
+		fail("must be <= %s", formatNumber(*s.maximum))
+	}
+	if s.exclusiveMinimum != nil && n <= *s.exclusiveMinimum {
+		fail("must be > %s", formatNumber(*s.exclusiveMinimum))
+	}
+	if s.exclusiveMaximum != nil && n >= *s.exclusiveMaximum {
+		fail("must be < %s", formatNumber(*s.exclusiveMaximum))
+	}
+	if s.multipleOf != nil {
+		// tolerância para 0.3 ser múltiplo de 0.1 mesmo com ponto flutuante
+		quotient := n / *s.multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			fail("must be a multiple of %s", formatNumber(*s.multipleOf))
+		}
+	}
+
+	return violations
+}
+
+// coerce converte as strings da query e dos headers para o tipo que o schema
+// espera, já que lá tudo chega como texto. Valores que não convertem seguem como
+// string e a validação de tipo aponta o erro.
+func (s *jsonSchema) coerce(value any) any {
+	// um $ref sem type ao lado diz o tipo pelo schema apontado
+	for i := 0; s.ref != nil && len(s.types) == 0 && i < 8; i++ {
+		s = s.ref
+	}
+
+	if values, ok := value.([]any); ok {
+		if !slices.Contains(s.types, "array") && len(values) == 1 {
+			return s.coerce(values[0])
+		}
+
+		if s.items != nil {
+			for i := range values {
+				values[i] = s.items.coerce(values[i])
+			}
+		}
+		return values
+	}
+
+	text, ok := value.(string)
+	if !ok || slices.Contains(s.types, "string") {
+		return value
+	}
+
+	for _, t := range s.types {
+		switch t {
+		case "array":
+			var item any = text
+			if s.items != nil {
+				item = s.items.coerce(text)
+			}
+			return []any{item}
+		case "integer", "number":
+			if _, err := strconv.ParseFloat(text, 64); err == nil {
+				return json.Number(text)
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(text); err == nil {
+				return b
+			}
+		}
+	}
+
+	return value
+}
+
+func isJSONType(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		_, ok := jsonNumber(value)
+		return ok
+	case "integer":
+		n, ok := jsonNumber(value)
+		return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+	}
+
+	return false
+}
+
+func jsonTypeOf(value any) string {
+	for _, t := range []string{"object", "array", "string", "boolean", "null", "integer", "number"} {
+		if isJSONType(value, t) {
+			return t
+		}
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonNumber aceita os números do json.Decoder com UseNumber e os do YAML.
+func jsonNumber(value any) (float64, bool) {
+	switch n := value.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+
+	return 0, false
+}
+
+// normalizeJSON deixa todos os números como float64 para comparar valores com
+// reflect.DeepEqual no enum, const e uniqueItems.
+func normalizeJSON(value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(value))
+		for k, v := range value {
+			normalized[k] = normalizeJSON(v)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, len(value))
+		for i, v := range value {
+			normalized[i] = normalizeJSON(v)
+		}
+		return normalized
+	}
+
+	if n, ok := jsonNumber(value); ok {
+		return n
+	}
+
+	return value
+}
+
+func formatJSON(value any) string {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(b)
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
@@ -0,0 +1,490 @@
+package plugin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tempo mínimo entre duas buscas do JWKS quando aparece um kid desconhecido,
+// para um token forjado não virar uma request por request no servidor de chaves
+const jwksMinRefreshInterval = 10 * time.Second
+
+var (
+	ErrJWTKeyNotFound     = errors.New("no key found to verify the token")
+	ErrJWTClaimMissing    = errors.New("required claim is missing")
+	ErrJWTKeyNotSupported = errors.New("key type not supported")
+)
+
+var jwtHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+type JWTAuthConfig struct {
+	KeyInHeader     bool              `input:"key_in_header"`
+	KeyInQuery      bool              `input:"key_in_query"`
+	KeyName         string            `input:"key_name,required"`
+	Secret          string            `input:"secret"`
+	Algorithms      []string          `input:"algorithms" oneof:"HS256|HS384|HS512|RS256|RS384|RS512|PS256|PS384|PS512|ES256|ES384|ES512"`
+	PublicKey       string            `input:"public_key"`
+	PublicKeyFile   string            `input:"public_key_file"`
+	JWKSFile        string            `input:"jwks_file"`
+	JWKSURL         string            `input:"jwks_url"`
+	JWKSCacheTTL    int               `input:"jwks_cache_ttl" default:"300"`
+	RequiredClaims  []string          `input:"required_claims"`
+	ClockSkew       int               `input:"clock_skew"`
+	Issuer          string            `input:"issuer"`
+	Audience        string            `input:"audience"`
+	ClaimsToHeaders map[string]string `input:"claims_to_headers"`
+
+	publicKey crypto.PublicKey
+}
+
+func (c *JWTAuthConfig) Validate() error {
+	if !c.KeyInHeader && !c.KeyInQuery {
+		return errors.New("one of the key_in_header or key_in_query must be true")
+	}
+
+	if c.Secret == "" && c.PublicKey == "" && c.PublicKeyFile == "" && c.jwksSource() == "" {
+		return errors.New("one of secret, public_key, public_key_file, jwks_file or jwks_url is required")
+	}
+
+	var err error
+	c.publicKey, err = c.loadPublicKey()
+	return err
+}
+
+func getToken(settings *JWTAuthConfig, r *http.Request) (string, error) {
+	if settings.KeyInHeader {
+		// Expected header value in format "Bearer <token>""
+		header := r.Header.Get(settings.KeyName)
+		parts := strings.Split(header, " ")
+		if len(parts) < 2 {
+			return "", errors.New("invalid header format")
+		}
+
+		return parts[1], nil
+	}
+
+	return r.URL.Query().Get(settings.KeyName), nil
+}
+
+// JWTAuth valida o token com o secret (HS*), com uma chave pública em PEM ou com
+// as chaves de um JWKS (RS*, PS* e ES*). Só os algoritmos de algorithms são aceitos.
+func JWTAuth(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[JWTAuthConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString, err := getToken(settings, r)
+		if err != nil {
+			slog.Error("could not get token", slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		claims := jwt.MapClaims{}
+		_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			return settings.verificationKey(token, settings.publicKey)
+		}, settings.parserOptions(settings.publicKey)...)
+
+		if err == nil {
+			err = settings.checkRequiredClaims(claims)
+		}
+
+		if err != nil {
+			slog.Error("could not parse token", slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		settings.forwardClaims(r, claims)
+
+		f(w, r.WithContext(kong.WithClaims(r.Context(), claims)))
+	}
+}
+
+func (c *JWTAuthConfig) parserOptions(publicKey crypto.PublicKey) []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(c.algorithms(publicKey)),
+		jwt.WithLeeway(time.Duration(c.ClockSkew) * time.Second),
+	}
+
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+
+	return options
+}
+
+// algorithms devolve a lista de algoritmos aceitos. Sem algorithms no input,
+// aceitamos o mais comum de cada tipo de chave configurada.
+func (c *JWTAuthConfig) algorithms(publicKey crypto.PublicKey) []string {
+	if len(c.Algorithms) > 0 {
+		return c.Algorithms
+	}
+
+	var algorithms []string
+	if c.Secret != "" {
+		algorithms = append(algorithms, jwt.SigningMethodHS256.Alg())
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey:
+		algorithms = append(algorithms, jwt.SigningMethodRS256.Alg())
+	case *ecdsa.PublicKey:
+		algorithms = append(algorithms, jwt.SigningMethodES256.Alg())
+	}
+
+	if c.jwksSource() != "" {
+		algorithms = append(algorithms, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg())
+	}
+
+	return algorithms
+}
+
+func (c *JWTAuthConfig) verificationKey(token *jwt.Token, publicKey crypto.PublicKey) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if c.Secret == "" {
+			return nil, ErrJWTKeyNotFound
+		}
+
+		return []byte(c.Secret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	// com kid o token diz qual chave do JWKS usar, sem kid vale a chave em PEM
+	if kid == "" && publicKey != nil {
+		return publicKey, nil
+	}
+
+	source := c.jwksSource()
+	if source == "" {
+		if publicKey != nil {
+			return publicKey, nil
+		}
+
+		return nil, ErrJWTKeyNotFound
+	}
+
+	return jwks.key(source, kid, token.Method.Alg(), time.Duration(c.JWKSCacheTTL)*time.Second)
+}
+
+func (c *JWTAuthConfig) jwksSource() string {
+	if c.JWKSURL != "" {
+		return c.JWKSURL
+	}
+
+	return c.JWKSFile
+}
+
+func (c *JWTAuthConfig) checkRequiredClaims(claims jwt.MapClaims) error {
+	for _, name := range c.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrJWTClaimMissing, name)
+		}
+	}
+
+	return nil
+}
+
+// forwardClaims repassa claims do token para o upstream nos headers de claims_to_headers.
+// O header que veio do cliente é sempre removido, senão ele poderia se passar por outro usuário.
+func (c *JWTAuthConfig) forwardClaims(r *http.Request, claims jwt.MapClaims) {
+	for claim, headerName := range c.ClaimsToHeaders {
+		r.Header.Del(headerName)
+
+		value, ok := claims[claim]
+		if !ok || value == nil {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			r.Header.Set(headerName, v)
+		case float64:
+			r.Header.Set(headerName, big.NewFloat(v).Text('f', -1))
+		default:
+			data, _ := json.Marshal(v)
+			r.Header.Set(headerName, string(data))
+		}
+	}
+}
+
+// loadPublicKey lê a chave de public_key (PEM no próprio config) ou de public_key_file.
+// Roda no Validate, então o arquivo é lido de novo a cada reload da configuração.
+func (c *JWTAuthConfig) loadPublicKey() (crypto.PublicKey, error) {
+	data := []byte(c.PublicKey)
+	switch {
+	case c.PublicKey != "":
+	case c.PublicKeyFile != "":
+		var err error
+		if data, err = os.ReadFile(c.PublicKeyFile); err != nil {
+			return nil, fmt.Errorf("public_key_file: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+
+	return parsePublicKeyPEM(data)
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	if key, err := jwt.ParseECPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: public key must be a RSA or ECDSA key in PEM format", ErrJWTKeyNotSupported)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwksEntry struct {
+	keys      []jsonWebKey
+	fetchedAt time.Time
+	// failedAt é a última busca que falhou; até jwksMinRefreshInterval depois dela as
+	// chaves guardadas continuam valendo, mesmo vencidas
+	failedAt time.Time
+}
+
+// jwksCall é uma busca em andamento, que as requests da mesma fonte esperam em vez
+// de buscar de novo.
+type jwksCall struct {
+	done  chan struct{}
+	entry *jwksEntry
+	err   error
+}
+
+// jwksCache guarda os JWKS já buscados, por url ou arquivo. A busca é feita fora do
+// lock, então uma fonte lenta só atrasa as requests que dependem dela.
+type jwksCache struct {
+	mu      sync.Mutex
+	entries map[string]*jwksEntry
+	calls   map[string]*jwksCall
+	now     func() time.Time
+}
+
+var jwks = &jwksCache{entries: map[string]*jwksEntry{}, calls: map[string]*jwksCall{}, now: time.Now}
+
+// PurgeJWKSCache descarta os JWKS guardados, a próxima request busca as chaves de novo.
+func PurgeJWKSCache() {
+	jwks.mu.Lock()
+	defer jwks.mu.Unlock()
+
+	jwks.entries = map[string]*jwksEntry{}
+}
+
+func (c *jwksCache) key(source, kid, alg string, ttl time.Duration) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	entry := c.entries[source]
+	c.mu.Unlock()
+
+	now := c.now()
+	if entry == nil || (now.Sub(entry.fetchedAt) > ttl && now.Sub(entry.failedAt) >= jwksMinRefreshInterval) {
+		fresh, err := c.refresh(source)
+		switch {
+		case err == nil:
+			entry = fresh
+		case entry == nil:
+			return nil, err
+		default:
+			// o emissor fora do ar não derruba a autenticação, as chaves antigas seguem valendo
+			slog.Warn("could not refresh jwks, using the cached keys", slog.String("source", source), slog.String("error", err.Error()))
+		}
+	}
+
+	if key, ok := findJSONWebKey(entry.keys, kid, alg); ok {
+		return key.publicKey()
+	}
+
+	// kid desconhecido pode ser uma chave nova, o emissor rotacionou as chaves
+	if now.Sub(entry.fetchedAt) < jwksMinRefreshInterval || now.Sub(entry.failedAt) < jwksMinRefreshInterval {
+		return nil, fmt.Errorf("%w: kid %q", ErrJWTKeyNotFound, kid)
+	}
+
+	entry, err := c.refresh(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := findJSONWebKey(entry.keys, kid, alg); ok {
+		return key.publicKey()
+	}
+
+	return nil, fmt.Errorf("%w: kid %q", ErrJWTKeyNotFound, kid)
+}
+
+// refresh busca o JWKS da fonte, ou espera a busca que já está em andamento.
+func (c *jwksCache) refresh(source string) (*jwksEntry, error) {
+	c.mu.Lock()
+	if call, ok := c.calls[source]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.entry, call.err
+	}
+
+	call := &jwksCall{done: make(chan struct{})}
+	c.calls[source] = call
+	c.mu.Unlock()
+
+	call.entry, call.err = c.fetch(source)
+
+	c.mu.Lock()
+	delete(c.calls, source)
+	if call.err == nil {
+		c.entries[source] = call.entry
+	} else if stale, ok := c.entries[source]; ok {
+		c.entries[source] = &jwksEntry{keys: stale.keys, fetchedAt: stale.fetchedAt, failedAt: c.now()}
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.entry, call.err
+}
+
+func (c *jwksCache) fetch(source string) (*jwksEntry, error) {
+	data, err := readJWKS(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid jwks %s: %w", source, err)
+	}
+
+	return &jwksEntry{keys: set.Keys, fetchedAt: c.now()}, nil
+}
+
+func readJWKS(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	res, err := jwtHTTPClient.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch jwks %s: status %d", source, res.StatusCode)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func findJSONWebKey(keys []jsonWebKey, kid, alg string) (jsonWebKey, bool) {
+	for _, key := range keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		if key.Alg != "" && key.Alg != alg {
+			continue
+		}
+
+		if kid != "" && key.Kid != kid {
+			continue
+		}
+
+		if !keyTypeSupportsAlgorithm(key.Kty, alg) {
+			continue
+		}
+
+		return key, true
+	}
+
+	return jsonWebKey{}, false
+}
+
+func keyTypeSupportsAlgorithm(kty, alg string) bool {
+	switch kty {
+	case "RSA":
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case "EC":
+		return strings.HasPrefix(alg, "ES")
+	}
+
+	return false
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", ErrJWTKeyNotSupported, k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+
+	return nil, fmt.Errorf("%w: kty %q", ErrJWTKeyNotSupported, k.Kty)
+}
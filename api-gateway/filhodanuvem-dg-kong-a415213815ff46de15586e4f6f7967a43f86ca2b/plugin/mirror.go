@@ -0,0 +1,300 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+// RequestMirrorConfig copia parte das requests para URL e descarta a resposta. As
+// cópias saem depois da resposta do upstream, por um grupo de workers com uma fila
+// limitada: quando ela enche, a cópia é descartada e a request original não espera.
+type RequestMirrorConfig struct {
+	URL string `input:"url,required"`
+	// Percentage é a porcentagem das requests que são copiadas
+	Percentage float64 `input:"percentage" default:"100"`
+	Workers    int     `input:"workers" default:"4"`
+	QueueSize  int     `input:"queue_size" default:"100"`
+	// Timeout da request de cópia, em milissegundos
+	Timeout int `input:"timeout" default:"5000"`
+	// MaxBodySize é o maior body copiado, em bytes; requests maiores não são copiadas
+	MaxBodySize int `input:"max_body_size" default:"1048576"`
+}
+
+func (c *RequestMirrorConfig) Validate() error {
+	var errs []error
+
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("invalid url %q", c.URL))
+	}
+
+	if c.Percentage < 0 || c.Percentage > 100 {
+		errs = append(errs, fmt.Errorf("percentage must be between 0 and 100, got %v", c.Percentage))
+	}
+
+	for _, option := range []struct {
+		name  string
+		value int
+	}{{"workers", c.Workers}, {"queue_size", c.QueueSize}, {"timeout", c.Timeout}} {
+		if option.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be greater than 0, got %d", option.name, option.value))
+		}
+	}
+
+	if c.MaxBodySize < 0 {
+		errs = append(errs, fmt.Errorf("max_body_size can not be negative, got %d", c.MaxBodySize))
+	}
+
+	return errors.Join(errs...)
+}
+
+// poolKey identifica os workers de cada destino. Como no queueKey dos plugins de log,
+// uma configuração alterada ganha um grupo novo e o antigo é fechado por ReleaseUnused.
+func (c *RequestMirrorConfig) poolKey() string {
+	return fmt.Sprintf("%s %d %d %d", c.URL, c.Workers, c.QueueSize, c.Timeout)
+}
+
+type mirrorContextKey struct{}
+
+// mirrorJob é a cópia de uma request. Ela é montada na fase access, enquanto o body
+// ainda pode ser lido, e enviada na fase log, quando o resultado do upstream já é
+// conhecido para comparar com o da cópia.
+type mirrorJob struct {
+	method  string
+	url     string
+	header  http.Header
+	body    []byte
+	service string
+	route   string
+
+	primaryStatus  int
+	primaryLatency time.Duration
+}
+
+// RequestMirror é a fase access do request_mirror.
+func RequestMirror(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[RequestMirrorConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rand.Float64()*100 >= settings.Percentage {
+			f(w, r)
+			return
+		}
+
+		service, route := routeLabels(r)
+
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, int64(settings.MaxBodySize)+1))
+			if err != nil {
+				slog.Error("could not read request body", slog.String("error", err.Error()))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// o que já foi lido volta na frente do resto, e o upstream recebe o body inteiro
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+			if len(body) > settings.MaxBodySize {
+				metrics.add("kong_mirror_dropped_total", labels{"service", service, "route", route, "reason", "body_too_large"}, 1)
+				f(w, r)
+				return
+			}
+		}
+
+		path := r.URL.Path
+		if match, ok := kong.RouteMatchFromContext(r.Context()); ok {
+			path = match.UpstreamPath
+		}
+
+		header := r.Header.Clone()
+		for _, name := range []string{"Connection", "Proxy-Connection", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade"} {
+			header.Del(name)
+		}
+
+		job := &mirrorJob{
+			method:  r.Method,
+			url:     mirrorURL(settings.URL, path, r.URL.RawQuery),
+			header:  header,
+			body:    body,
+			service: service,
+			route:   route,
+		}
+
+		f(w, r.WithContext(context.WithValue(r.Context(), mirrorContextKey{}, job)))
+	}
+}
+
+// MirrorLog é a fase log do request_mirror, que entrega a cópia para os workers.
+func MirrorLog(p kong.Plugin, r *http.Request, entry LogEntry) {
+	job, ok := r.Context().Value(mirrorContextKey{}).(*mirrorJob)
+	if !ok {
+		return
+	}
+
+	settings, err := Settings[RequestMirrorConfig](p)
+	if err != nil {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		return
+	}
+
+	job.primaryStatus = entry.Status
+	job.primaryLatency = entry.UpstreamLatency
+
+	findMirrorPool(settings.poolKey(), settings).push(job)
+}
+
+func mirrorURL(base, path, rawQuery string) string {
+	u, _ := url.Parse(base)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+	u.RawPath = ""
+	u.RawQuery = rawQuery
+
+	return u.String()
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// mirrorPool envia as cópias de um destino com um número fixo de workers.
+type mirrorPool struct {
+	jobs   chan *mirrorJob
+	stop   chan struct{}
+	client *http.Client
+}
+
+func newMirrorPool(settings *RequestMirrorConfig) *mirrorPool {
+	pool := &mirrorPool{
+		jobs: make(chan *mirrorJob, settings.QueueSize),
+		stop: make(chan struct{}),
+		client: &http.Client{
+			Timeout: time.Duration(settings.Timeout) * time.Millisecond,
+			// o redirect faz parte da resposta da cópia, que é descartada
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+
+	for i := 0; i < settings.Workers; i++ {
+		go pool.run()
+	}
+
+	return pool
+}
+
+func (p *mirrorPool) push(job *mirrorJob) {
+	select {
+	case p.jobs <- job:
+	default:
+		metrics.add("kong_mirror_dropped_total", labels{"service", job.service, "route", job.route, "reason", "queue_full"}, 1)
+	}
+}
+
+func (p *mirrorPool) run() {
+	for {
+		select {
+		case job := <-p.jobs:
+			p.send(job)
+		case <-p.stop:
+			// as cópias que já estavam na fila ainda são enviadas
+			for {
+				select {
+				case job := <-p.jobs:
+					p.send(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// close encerra os workers. O channel de jobs continua aberto, porque uma request
+// da configuração antiga ainda pode chegar na fase log depois disso.
+func (p *mirrorPool) close() {
+	close(p.stop)
+}
+
+func (p *mirrorPool) send(job *mirrorJob) {
+	shadowStatus := "error"
+
+	startedAt := time.Now()
+	req, err := http.NewRequest(job.method, job.url, bytes.NewReader(job.body))
+	if err == nil {
+		req.Header = job.header
+
+		var res *http.Response
+		if res, err = p.client.Do(req); err == nil {
+			shadowStatus = strconv.Itoa(res.StatusCode)
+			// ler o body até o fim deixa a conexão livre para a próxima cópia
+			io.Copy(io.Discard, io.LimitReader(res.Body, 1<<20))
+			res.Body.Close()
+		}
+	}
+	latency := time.Since(startedAt)
+
+	if err != nil {
+		slog.Warn("could not mirror request", slog.String("url", job.url), slog.String("error", err.Error()))
+	}
+
+	metrics.add("kong_mirror_requests_total", labels{
+		"service", job.service, "route", job.route,
+		"primary_code", strconv.Itoa(job.primaryStatus), "shadow_code", shadowStatus,
+	}, 1)
+
+	if job.primaryLatency > 0 {
+		metrics.observe("kong_mirror_latency_ms", labels{"service", job.service, "route", job.route, "target", "primary"}, milliseconds(job.primaryLatency))
+	}
+	if err == nil {
+		metrics.observe("kong_mirror_latency_ms", labels{"service", job.service, "route", job.route, "target", "shadow"}, milliseconds(latency))
+	}
+}
+
+var (
+	mirrorPoolsMu sync.Mutex
+	mirrorPools   = map[string]*mirrorPool{}
+)
+
+// findMirrorPool devolve os workers do destino, criando eles na primeira vez.
+func findMirrorPool(key string, settings *RequestMirrorConfig) *mirrorPool {
+	mirrorPoolsMu.Lock()
+	defer mirrorPoolsMu.Unlock()
+
+	pool, ok := mirrorPools[key]
+	if !ok {
+		pool = newMirrorPool(settings)
+		mirrorPools[key] = pool
+	}
+
+	return pool
+}
+
+// closeMirrorPools fecha os workers cuja chave não está em keys.
+func closeMirrorPools(keys map[string]bool) {
+	mirrorPoolsMu.Lock()
+	defer mirrorPoolsMu.Unlock()
+
+	for key, pool := range mirrorPools {
+		if !keys[key] {
+			pool.close()
+			delete(mirrorPools, key)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+// Prioridades dos plugins que vêm com o gateway. Na fase access, quem tem a maior
+// prioridade roda primeiro; autenticação precisa rodar antes de quem depende do consumer,
+// e o request_size_limiting antes de quem lê o body inteiro, como o request_validator.
+const (
+	PriorityBotDetection        = 2500
+	PriorityCORS                = 2000
+	PriorityJWTAuth             = 1450
+	PriorityKeyAuth             = 1250
+	PriorityBasicAuth           = 1100
+	PriorityRequestSizeLimit    = 1000
+	PriorityRequestValidator    = 999
+	PriorityIPRestriction       = 990
+	PriorityACL                 = 950
+	PriorityRateLimit           = 910
+	PriorityRequestTransformer  = 801
+	PriorityAddHeader           = 801
+	PriorityResponseTransformer = 800
+	PriorityRequestMirror       = 700
+	PriorityCompression         = 600
+	PriorityProxyCache          = 100
+	PriorityPrometheus          = 13
+	PriorityHTTPLog             = 12
+	PriorityFileLog             = 9
+	PriorityTCPLog              = 7
+)
+
+// Definition descreve um plugin. Cada fase é opcional:
+//   - Access roda antes do upstream e pode responder no lugar dele.
+//   - HeaderFilter roda quando o upstream responde, antes do status e dos headers irem para o cliente.
+//   - BodyFilter transforma o body enquanto ele é enviado para o cliente.
+//   - Log roda depois que a resposta foi enviada.
+//
+// Schema é uma struct vazia com a configuração do plugin (veja Decode). Sem schema,
+// o input não é conferido. Preflight indica que a fase access responde os preflights
+// de CORS, então eles chegam no plugin mesmo em rotas que não declaram OPTIONS.
+type Definition struct {
+	Priority     int
+	Schema       any
+	Access       Middleware
+	HeaderFilter HeaderFilter
+	BodyFilter   BodyFilter
+	Log          LogHandler
+	Preflight    bool
+}
+
+// ResponseHead é o que o HeaderFilter pode alterar na resposta.
+type ResponseHead struct {
+	StatusCode int
+	Header     http.Header
+}
+
+type HeaderFilter func(p kong.Plugin, r *http.Request, res *ResponseHead)
+
+// BodyFilter recebe o writer que leva o body em direção ao cliente e devolve outro
+// que transforma o que é escrito nele. Close é chamado no fim da resposta, para
+// o filtro escrever o que ainda estiver guardado. Os headers de res ainda podem ser
+// alterados; quando o filtro não se aplica a essa resposta, ele devolve nil.
+type BodyFilter func(p kong.Plugin, r *http.Request, res *ResponseHead, w io.Writer) io.WriteCloser
+
+// LogEntry resume a request atendida. Latency é o tempo total e UpstreamLatency o
+// tempo entre enviar a request para o upstream e receber os headers da resposta,
+// zero quando a request não chegou no upstream.
+type LogEntry struct {
+	StartedAt       time.Time
+	Latency         time.Duration
+	UpstreamLatency time.Duration
+	Status          int
+	// Bytes é o tamanho do body enviado ao cliente e RequestBytes o do body recebido dele
+	Bytes        int64
+	RequestBytes int64
+	// ResponseHeader são os headers enviados ao cliente e ResponseTrailer os que foram
+	// depois do body, como o grpc-status
+	ResponseHeader  http.Header
+	ResponseTrailer http.Header
+}
+
+type LogHandler func(p kong.Plugin, r *http.Request, entry LogEntry)
+
+// Definições dos plugins que vêm com o gateway, prontas para o RegisterPlugin.
+var (
+	HTTPLogPlugin             = Definition{Priority: PriorityHTTPLog, Schema: HTTPLogConfig{}, Log: Log}
+	FileLogPlugin             = Definition{Priority: PriorityFileLog, Schema: FileLogConfig{}, Log: FileLog}
+	TCPLogPlugin              = Definition{Priority: PriorityTCPLog, Schema: TCPLogConfig{}, Log: TCPLog}
+	PrometheusPlugin          = Definition{Priority: PriorityPrometheus, Schema: PrometheusConfig{}, Log: Prometheus}
+	AddHeaderPlugin           = Definition{Priority: PriorityAddHeader, Schema: AddHeaderConfig{}, Access: AddHeader}
+	JWTAuthPlugin             = Definition{Priority: PriorityJWTAuth, Schema: JWTAuthConfig{}, Access: JWTAuth}
+	KeyAuthPlugin             = Definition{Priority: PriorityKeyAuth, Schema: KeyAuthConfig{}, Access: KeyAuth}
+	BasicAuthPlugin           = Definition{Priority: PriorityBasicAuth, Schema: BasicAuthConfig{}, Access: BasicAuth}
+	ACLPlugin                 = Definition{Priority: PriorityACL, Schema: ACLConfig{}, Access: ACL}
+	RequestSizeLimitPlugin    = Definition{Priority: PriorityRequestSizeLimit, Schema: RequestSizeLimitConfig{}, Access: RequestSizeLimit}
+	RateLimitPlugin           = Definition{Priority: PriorityRateLimit, Schema: RateLimitConfig{}, Access: RateLimit}
+	ProxyCachePlugin          = Definition{Priority: PriorityProxyCache, Schema: ProxyCacheConfig{}, Access: ProxyCache}
+	RequestTransformerPlugin  = Definition{Priority: PriorityRequestTransformer, Schema: RequestTransformerConfig{}, Access: RequestTransformer}
+	ResponseTransformerPlugin = Definition{Priority: PriorityResponseTransformer, Schema: ResponseTransformerConfig{}, HeaderFilter: ResponseTransformer, BodyFilter: ResponseBodyTransformer}
+	CORSPlugin                = Definition{Priority: PriorityCORS, Schema: CORSConfig{}, Access: CORS, HeaderFilter: CORSHeaders, Preflight: true}
+	IPRestrictionPlugin       = Definition{Priority: PriorityIPRestriction, Schema: IPRestrictionConfig{}, Access: IPRestriction}
+	BotDetectionPlugin        = Definition{Priority: PriorityBotDetection, Schema: BotDetectionConfig{}, Access: BotDetection}
+	RequestValidatorPlugin    = Definition{Priority: PriorityRequestValidator, Schema: RequestValidatorConfig{}, Access: RequestValidator}
+	RequestMirrorPlugin       = Definition{Priority: PriorityRequestMirror, Schema: RequestMirrorConfig{}, Access: RequestMirror, Log: MirrorLog}
+	CompressionPlugin         = Definition{Priority: PriorityCompression, Schema: CompressionConfig{}, Access: Compression, BodyFilter: CompressionBody}
+)
@@ -2,61 +2,138 @@ package plugin
 
 import (
 	"bytes"
-	"errors"
-	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	"strings"
+	"slices"
+	"sync"
 
 	"github.com/devgymbr/kong"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-var availablePlugins = map[string]Middleware{}
+var (
+	// os plugins são lidos também pelas goroutines de ReleaseUnused
+	availablePluginsMu sync.RWMutex
+	availablePlugins   = map[string]Definition{}
+)
 
 type Middleware func(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc
 
+// Register cadastra um plugin que só tem a fase access, com prioridade 0.
 func Register(pluginName string, middleware Middleware) {
-	availablePlugins[pluginName] = middleware
+	RegisterPlugin(pluginName, Definition{Access: middleware})
+}
+
+func RegisterPlugin(pluginName string, definition Definition) {
+	availablePluginsMu.Lock()
+	defer availablePluginsMu.Unlock()
+
+	availablePlugins[pluginName] = definition
+}
+
+func FindPlugin(pluginName string) (Definition, error) {
+	availablePluginsMu.RLock()
+	defer availablePluginsMu.RUnlock()
+
+	if definition, ok := availablePlugins[pluginName]; ok {
+		return definition, nil
+	}
+
+	return Definition{}, kong.ErrPluginNotFound
 }
 
+// FindMiddleware devolve a fase access do plugin. Plugins sem essa fase
+// recebem um middleware que só repassa a request.
 func FindMiddleware(pluginName string) (Middleware, error) {
-	if middleware, ok := availablePlugins[pluginName]; ok {
-		return middleware, nil
+	definition, err := FindPlugin(pluginName)
+	if err != nil {
+		return nil, err
+	}
+
+	if definition.Access == nil {
+		return func(_ kong.Plugin, f http.HandlerFunc) http.HandlerFunc { return f }, nil
 	}
 
-	return nil, kong.ErrPluginNotFound
+	return definition.Access, nil
 }
 
-func Log(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		slog.Info("request received",
-			slog.String("method", r.Method),
-			slog.String("url", r.URL.Path),
-		)
-		f(w, r)
+// ReleaseUnused fecha as filas e goroutines criadas para opções de plugins que a
+// configuração não usa mais, como depois de um reload que mudou um plugin de log ou
+// o destino de um request_mirror.
+// O gateway tem uma configuração ativa só, então tudo que ela não referencia é fechado.
+func ReleaseUnused(config *kong.Config) {
+	plugins := slices.Clone(config.Plugins)
+	for _, service := range config.Services {
+		plugins = append(plugins, service.Plugins...)
+		for _, route := range service.Routes {
+			plugins = append(plugins, route.Plugins...)
+		}
 	}
+
+	queues, pools := map[string]bool{}, map[string]bool{}
+	for _, p := range plugins {
+		settings := p.Settings
+		if settings == nil {
+			settings, _ = Decode(p)
+		}
+
+		if queue, ok := settings.(interface{ queueKey() string }); ok {
+			queues[queue.queueKey()] = true
+		}
+		if pool, ok := settings.(interface{ poolKey() string }); ok {
+			pools[pool.poolKey()] = true
+		}
+	}
+
+	closeAccessLogQueues(queues)
+	closeMirrorPools(pools)
 }
 
+// invalidSettings responde 500 quando o input do plugin é inválido. Com a
+// configuração validada no carregamento isso só acontece com plugins montados no código.
+func invalidSettings(err error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// AddHeaderConfig é o header e o valor de cada um que vai ser adicionado na request.
+type AddHeaderConfig map[string]string
+
 func AddHeader(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[AddHeaderConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		for header, value := range p.Input {
-			r.Header.Add(header, value.(string))
+		for header, value := range *settings {
+			r.Header.Add(header, value)
 		}
 
 		f(w, r)
 	}
 }
 
+type RequestSizeLimitConfig struct {
+	AllowedPayloadSize int `input:"allowed_payload_size,required"`
+}
+
 func RequestSizeLimit(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[RequestSizeLimitConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		body, err := io.ReadAll(r.Body)
+		// não precisa ler mais que o limite para saber que passou dele
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(settings.AllowedPayloadSize)))
 		if err != nil {
 			slog.Error("could not read request body", slog.String("error", err.Error()))
 		}
 
-		if p.Input["allowed_payload_size"] == nil || len(body) >= p.Input["allowed_payload_size"].(int) {
+		if len(body) >= settings.AllowedPayloadSize {
 			w.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
 		}
@@ -72,47 +149,3 @@ func RequestSizeLimit(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
 		f(w, r)
 	}
 }
-
-func getToken(p kong.Plugin, r *http.Request) (string, error) {
-	keyName := fmt.Sprintf("%s", p.Input["key_name"])
-	if p.Input["key_in_header"] != nil && p.Input["key_in_header"].(bool) {
-		// Expected header value in format "Bearer <token>""
-		header := r.Header.Get(keyName)
-		parts := strings.Split(header, " ")
-		if len(parts) < 2 {
-			return "", errors.New("invalid header format")
-		}
-
-		return parts[1], nil
-	}
-
-	if p.Input["key_in_query"] != nil && p.Input["key_in_query"].(bool) {
-		return r.URL.Query().Get(keyName), nil
-	}
-
-	return "", errors.New("one of the key_in_header or key_in_query must be true")
-}
-
-func JWTAuth(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tokenString, err := getToken(p, r)
-		if err != nil {
-			slog.Error("could not get token", slog.String("error", err.Error()))
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
-		_, err = jwt.Parse(tokenString, func(_ *jwt.Token) (interface{}, error) {
-			value := fmt.Sprintf("%s", p.Input["secret"])
-			return []byte(value), nil
-		})
-
-		if err != nil {
-			slog.Error("could not parse token", slog.String("error", err.Error()))
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		}
-
-		f(w, r)
-	}
-}
@@ -0,0 +1,227 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+type PrometheusConfig struct {
+	LatencyMetrics   bool `input:"latency_metrics" default:"true"`
+	BandwidthMetrics bool `input:"bandwidth_metrics" default:"true"`
+}
+
+// limites dos buckets dos histogramas de latência, em milissegundos
+var latencyBuckets = []float64{1, 2, 5, 7, 10, 15, 20, 30, 50, 75, 100, 200, 500, 750, 1000, 2000, 5000, 10000, 30000, 60000}
+
+// metrics guarda as métricas de todos os plugins prometheus. Elas são do processo,
+// então continuam acumulando depois de um reload da configuração.
+var metrics = newMetricsRegistry()
+
+// Prometheus é a fase log do plugin prometheus. As métricas são expostas no
+// /metrics da Admin API.
+func Prometheus(p kong.Plugin, r *http.Request, entry LogEntry) {
+	settings, err := Settings[PrometheusConfig](p)
+	if err != nil {
+		return
+	}
+
+	service, route := routeLabels(r)
+	code := strconv.Itoa(entry.Status)
+
+	metrics.add("kong_http_requests_total", labels{"service", service, "route", route, "code", code}, 1)
+
+	if settings.BandwidthMetrics {
+		metrics.add("kong_bandwidth_bytes", labels{"service", service, "route", route, "code", code, "direction", "egress"}, float64(entry.Bytes))
+		metrics.add("kong_bandwidth_bytes", labels{"service", service, "route", route, "code", code, "direction", "ingress"}, float64(entry.RequestBytes))
+	}
+
+	if settings.LatencyMetrics {
+		l := labels{"service", service, "route", route}
+		metrics.observe("kong_request_latency_ms", l, milliseconds(entry.Latency))
+		metrics.observe("kong_gateway_latency_ms", l, milliseconds(entry.Latency-entry.UpstreamLatency))
+		if entry.UpstreamLatency > 0 {
+			metrics.observe("kong_upstream_latency_ms", l, milliseconds(entry.UpstreamLatency))
+		}
+	}
+}
+
+// routeLabels devolve o serviço e a rota da request para as métricas. Num canary
+// a métrica fica com o serviço que atendeu, para comparar as versões.
+func routeLabels(r *http.Request) (service, route string) {
+	if match, ok := kong.RouteMatchFromContext(r.Context()); ok {
+		service, route = match.Service.Name, match.Route.Name
+		if match.Upstream != nil {
+			service = match.Upstream.Name
+		}
+	}
+
+	return service, route
+}
+
+// WriteMetrics escreve as métricas no formato texto do Prometheus.
+func WriteMetrics(w io.Writer) error {
+	return metrics.write(w)
+}
+
+// ResetMetrics zera todas as métricas.
+func ResetMetrics() {
+	metrics.reset()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels são pares nome, valor na ordem em que aparecem na métrica.
+type labels []string
+
+func (l labels) String() string {
+	var b strings.Builder
+	for i := 0; i+1 < len(l); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", l[i], labelEscaper.Replace(l[i+1]))
+	}
+
+	return b.String()
+}
+
+type metricFamily struct {
+	kind string
+	help string
+}
+
+var metricFamilies = map[string]metricFamily{
+	"kong_http_requests_total":   {"counter", "HTTP requests served, by service, route and status code."},
+	"kong_bandwidth_bytes":       {"counter", "Body bytes received (ingress) and sent (egress), by service, route and status code."},
+	"kong_request_latency_ms":    {"histogram", "Total time to serve the request, in milliseconds."},
+	"kong_upstream_latency_ms":   {"histogram", "Time until the upstream sent the response headers, in milliseconds."},
+	"kong_gateway_latency_ms":    {"histogram", "Time spent in the gateway outside the upstream, in milliseconds."},
+	"kong_mirror_requests_total": {"counter", "Mirrored requests, by service, route and the status codes of the primary and shadow upstreams."},
+	"kong_mirror_latency_ms":     {"histogram", "Time until the primary or shadow upstream sent the response headers, in milliseconds."},
+	"kong_mirror_dropped_total":  {"counter", "Requests that were not mirrored, by service, route and reason."},
+}
+
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+type metricsRegistry struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*histogram{},
+	}
+}
+
+func (m *metricsRegistry) add(name string, l labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.counters[name] == nil {
+		m.counters[name] = map[string]float64{}
+	}
+	m.counters[name][l.String()] += value
+}
+
+func (m *metricsRegistry) observe(name string, l labels, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.histograms[name] == nil {
+		m.histograms[name] = map[string]*histogram{}
+	}
+
+	h, ok := m.histograms[name][l.String()]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.histograms[name][l.String()] = h
+	}
+
+	for i, le := range latencyBuckets {
+		if value <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (m *metricsRegistry) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters = map[string]map[string]float64{}
+	m.histograms = map[string]map[string]*histogram{}
+}
+
+func (m *metricsRegistry) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(metricFamilies))
+	for name := range metricFamilies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		family := metricFamilies[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.kind)
+
+		for _, series := range sortedKeys(m.counters[name]) {
+			fmt.Fprintf(&b, "%s{%s} %s\n", name, series, formatValue(m.counters[name][series]))
+		}
+
+		for _, series := range sortedKeys(m.histograms[name]) {
+			h := m.histograms[name][series]
+			for i, le := range latencyBuckets {
+				fmt.Fprintf(&b, "%s_bucket{%s,le=%q} %d\n", name, series, formatValue(le), h.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, series, h.count)
+			fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, series, formatValue(h.sum))
+			fmt.Fprintf(&b, "%s_count{%s} %d\n", name, series, h.count)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatInt(int64(v), 10)
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
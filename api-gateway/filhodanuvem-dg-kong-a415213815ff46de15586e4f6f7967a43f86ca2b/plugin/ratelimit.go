@@ -0,0 +1,404 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/devgymbr/kong"
+)
+
+const (
+	PolicyFixedWindow = "fixed_window"
+	PolicyTokenBucket = "token_bucket"
+)
+
+var ErrRateLimitStoreNotFound = errors.New("rate limit store not found")
+
+// RateLimitStore guarda os contadores do rate_limiting. A implementação em memória
+// atende uma instância só; para várias instâncias do gateway dividirem os mesmos
+// contadores basta registrar um store compartilhado (redis, por exemplo) com RegisterRateLimitStore.
+type RateLimitStore interface {
+	// Increment soma um hit ao contador de key e retorna o total atual.
+	// O contador pode ser descartado depois de expiresAt.
+	Increment(key string, expiresAt time.Time) (int64, error)
+	// TakeToken tenta consumir um token do bucket de key, que comporta capacity tokens
+	// e é totalmente reabastecido a cada period. Retorna os tokens que sobraram.
+	TakeToken(key string, capacity int64, period time.Duration) (float64, bool, error)
+}
+
+var rateLimitStores = map[string]RateLimitStore{
+	"memory": NewMemoryRateLimitStore(),
+}
+
+func RegisterRateLimitStore(name string, store RateLimitStore) {
+	rateLimitStores[name] = store
+}
+
+func FindRateLimitStore(name string) (RateLimitStore, error) {
+	if store, ok := rateLimitStores[name]; ok {
+		return store, nil
+	}
+
+	return nil, ErrRateLimitStoreNotFound
+}
+
+type rateLimitPeriod struct {
+	name     string
+	duration time.Duration
+}
+
+var rateLimitPeriods = []rateLimitPeriod{
+	{name: "second", duration: time.Second},
+	{name: "minute", duration: time.Minute},
+	{name: "hour", duration: time.Hour},
+}
+
+type rateLimitResult struct {
+	limit     int64
+	remaining int64
+	reset     time.Duration
+	allowed   bool
+}
+
+type RateLimitConfig struct {
+	Second     int    `input:"second"`
+	Minute     int    `input:"minute"`
+	Hour       int    `input:"hour"`
+	Policy     string `input:"policy" default:"fixed_window" oneof:"fixed_window|token_bucket"`
+	Store      string `input:"store" default:"memory"`
+	LimitBy    string `input:"limit_by" default:"ip" oneof:"ip|header|jwt_claim|path_param|route|service|consumer"`
+	HeaderName string `input:"header_name"`
+	ClaimName  string `input:"claim_name"`
+	ParamName  string `input:"param_name"`
+}
+
+func (c *RateLimitConfig) Validate() error {
+	if c.Second < 0 || c.Minute < 0 || c.Hour < 0 {
+		return errors.New("second, minute and hour can not be negative")
+	}
+
+	if c.Second == 0 && c.Minute == 0 && c.Hour == 0 {
+		return errors.New("at least one of second, minute or hour is required")
+	}
+
+	switch {
+	case c.LimitBy == "header" && c.HeaderName == "":
+		return errors.New("header_name is required when limit_by is header")
+	case c.LimitBy == "jwt_claim" && c.ClaimName == "":
+		return errors.New("claim_name is required when limit_by is jwt_claim")
+	case c.LimitBy == "path_param" && c.ParamName == "":
+		return errors.New("param_name is required when limit_by is path_param")
+	}
+
+	return nil
+}
+
+func (c *RateLimitConfig) limit(period rateLimitPeriod) int {
+	switch period.name {
+	case "second":
+		return c.Second
+	case "minute":
+		return c.Minute
+	case "hour":
+		return c.Hour
+	}
+
+	return 0
+}
+
+func RateLimit(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[RateLimitConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		store, err := FindRateLimitStore(settings.Store)
+		if err != nil {
+			slog.Error("could not find rate limit store",
+				slog.String("store", settings.Store),
+				slog.String("error", err.Error()),
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		policy := settings.Policy
+
+		identifier := rateLimitIdentifier(p, settings, r)
+		now := time.Now()
+
+		var current *rateLimitResult
+		for _, period := range rateLimitPeriods {
+			limit := settings.limit(period)
+			if limit <= 0 {
+				continue
+			}
+
+			result, err := takeRateLimit(store, policy, identifier, period, int64(limit), now)
+			if err != nil {
+				slog.Error("could not apply rate limit",
+					slog.String("policy", policy),
+					slog.String("error", err.Error()),
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			// com mais de um limite configurado os headers refletem o mais restritivo
+			if current == nil || !result.allowed || (current.allowed && result.remaining < current.remaining) {
+				current = result
+			}
+
+			if !result.allowed {
+				break
+			}
+		}
+
+		if current == nil {
+			f(w, r)
+			return
+		}
+
+		reset := strconv.FormatInt(int64(math.Ceil(current.reset.Seconds())), 10)
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(current.limit, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(current.remaining, 10))
+		w.Header().Set("X-RateLimit-Reset", reset)
+
+		if !current.allowed {
+			w.Header().Set("Retry-After", reset)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+func takeRateLimit(store RateLimitStore, policy, identifier string, period rateLimitPeriod, limit int64, now time.Time) (*rateLimitResult, error) {
+	switch policy {
+	case PolicyFixedWindow:
+		windowStart := now.Truncate(period.duration)
+		windowEnd := windowStart.Add(period.duration)
+		key := fmt.Sprintf("ratelimit:%s:%s:%d", identifier, period.name, windowStart.Unix())
+
+		count, err := store.Increment(key, windowEnd)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rateLimitResult{
+			limit:     limit,
+			remaining: max(limit-count, 0),
+			reset:     windowEnd.Sub(now),
+			allowed:   count <= limit,
+		}, nil
+	case PolicyTokenBucket:
+		key := fmt.Sprintf("ratelimit:%s:%s:bucket", identifier, period.name)
+
+		tokens, allowed, err := store.TakeToken(key, limit, period.duration)
+		if err != nil {
+			return nil, err
+		}
+
+		// tempo até o bucket encher de novo
+		missing := float64(limit) - tokens
+		reset := time.Duration(missing * float64(period.duration) / float64(limit))
+
+		return &rateLimitResult{
+			limit:     limit,
+			remaining: int64(tokens),
+			reset:     reset,
+			allowed:   allowed,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rate limit policy %q", policy)
+}
+
+// rateLimitIdentifier define quem está sendo limitado. Se o consumer, o header, a claim
+// ou o parâmetro configurados não vierem na request, voltamos para o IP do cliente.
+// Os contadores são separados por serviço e pelo lugar onde o plugin foi declarado:
+// um plugin global ou de serviço conta as requests do serviço inteiro e um de rota
+// só as da rota, assim cada rota tem o seu limite.
+func rateLimitIdentifier(p kong.Plugin, settings *RateLimitConfig, r *http.Request) string {
+	return rateLimitScope(p, r) + ":" + rateLimitSubject(settings, r)
+}
+
+// rateLimitScope diz onde o plugin foi declarado. Um plugin de mesmo nome num nível
+// mais específico substitui o dos outros (veja kong.Config.PluginsFor), então o que
+// está rodando é o do nível mais específico que declara o nome.
+func rateLimitScope(p kong.Plugin, r *http.Request) string {
+	declares := func(plugins []kong.Plugin) bool {
+		return slices.ContainsFunc(plugins, func(other kong.Plugin) bool { return other.Name == p.Name })
+	}
+
+	service, ok := kong.ServiceFromContext(r.Context())
+	if !ok {
+		return "global"
+	}
+
+	if route, ok := kong.RouteFromContext(r.Context()); ok && route != nil && declares(route.Plugins) {
+		return "service:" + service.Name + ":route:" + route.Name
+	}
+
+	if declares(service.Plugins) {
+		return "service:" + service.Name
+	}
+
+	return "global:service:" + service.Name
+}
+
+func rateLimitSubject(settings *RateLimitConfig, r *http.Request) string {
+	switch settings.LimitBy {
+	case "service":
+		return "service"
+	case "consumer":
+		if consumer, ok := kong.ConsumerFromContext(r.Context()); ok {
+			return "consumer:" + consumer.Name
+		}
+	case "route":
+		if route, ok := kong.RouteFromContext(r.Context()); ok {
+			return "route:" + route.Name
+		}
+	case "path_param":
+		if value := kong.PathParam(r, settings.ParamName); value != "" {
+			return "param:" + settings.ParamName + "=" + value
+		}
+	case "header":
+		if value := r.Header.Get(settings.HeaderName); value != "" {
+			return "header:" + value
+		}
+	case "jwt_claim":
+		if value, ok := verifiedClaim(r, settings.ClaimName); ok {
+			return "claim:" + value
+		}
+	}
+
+	return "ip:" + clientIP(r)
+}
+
+// verifiedClaim lê a claim que o jwt_auth validou. Sem jwt_auth antes na request
+// não há claims, e o limite cai para o IP, assim um token forjado não vira um
+// contador novo a cada request.
+func verifiedClaim(r *http.Request, claimName string) (string, bool) {
+	claims, ok := kong.ClaimsFromContext(r.Context())
+	if !ok {
+		return "", false
+	}
+
+	value, ok := claims[claimName]
+	if !ok || value == nil {
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", value), true
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+type memoryCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryCounter
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		counters: map[string]*memoryCounter{},
+		buckets:  map[string]*memoryBucket{},
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryRateLimitStore) Increment(key string, expiresAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+
+	counter, ok := s.counters[key]
+	if !ok {
+		counter = &memoryCounter{expiresAt: expiresAt}
+		s.counters[key] = counter
+	}
+	counter.count++
+
+	return counter.count, nil
+}
+
+func (s *MemoryRateLimitStore) TakeToken(key string, capacity int64, period time.Duration) (float64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+
+	now := s.now()
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: float64(capacity), updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	refill := float64(capacity) * float64(now.Sub(bucket.updatedAt)) / float64(period)
+	bucket.tokens = math.Min(float64(capacity), bucket.tokens+refill)
+	bucket.updatedAt = now
+	// depois de um period parado o bucket está cheio de novo e pode ser descartado
+	bucket.expiresAt = now.Add(period)
+
+	if bucket.tokens < 1 {
+		return bucket.tokens, false, nil
+	}
+	bucket.tokens--
+
+	return bucket.tokens, true, nil
+}
+
+// sweep remove as entradas expiradas, no máximo uma vez por segundo.
+func (s *MemoryRateLimitStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < time.Second {
+		return
+	}
+	s.lastSweep = now
+
+	for key, counter := range s.counters {
+		if now.After(counter.expiresAt) {
+			delete(s.counters, key)
+		}
+	}
+
+	for key, bucket := range s.buckets {
+		if now.After(bucket.expiresAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+// IPRestrictionConfig aceita IPs (10.0.0.1) ou blocos CIDR (10.0.0.0/8) nas listas.
+// Um IP em deny é sempre bloqueado, e com allow preenchido só os IPs dele passam.
+type IPRestrictionConfig struct {
+	Allow []string `input:"allow"`
+	Deny  []string `input:"deny"`
+	// TrustedProxies são os proxies na frente do gateway. Só quando a conexão vem de
+	// um deles o X-Forwarded-For é usado para descobrir o IP do cliente.
+	TrustedProxies []string `input:"trusted_proxies"`
+
+	allow, deny, trusted []netip.Prefix
+}
+
+func (c *IPRestrictionConfig) Validate() error {
+	if len(c.Allow) == 0 && len(c.Deny) == 0 {
+		return errors.New("at least one of allow or deny is required")
+	}
+
+	var errs []error
+	c.allow, errs = parsePrefixes("allow", c.Allow, errs)
+	c.deny, errs = parsePrefixes("deny", c.Deny, errs)
+	c.trusted, errs = parsePrefixes("trusted_proxies", c.TrustedProxies, errs)
+
+	return errors.Join(errs...)
+}
+
+func parsePrefixes(field string, values []string, errs []error) ([]netip.Prefix, []error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		if addr, err := netip.ParseAddr(value); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid ip or cidr %q", field, value))
+			continue
+		}
+
+		// 10.0.0.1/8 vira 10.0.0.0/8
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, errs
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IPRestriction libera ou bloqueia a request pelo IP do cliente.
+func IPRestriction(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[IPRestrictionConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, ok := resolveClientIP(r, settings.trusted)
+		if !ok {
+			slog.Info("could not resolve client ip", slog.String("remote_addr", r.RemoteAddr))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if containsAddr(settings.deny, ip) || (len(settings.allow) > 0 && !containsAddr(settings.allow, ip)) {
+			slog.Info("ip not allowed", slog.String("ip", ip.String()))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+// resolveClientIP devolve o IP de quem abriu a conexão. Se for um proxy confiável,
+// o X-Forwarded-For é lido da direita para a esquerda e o primeiro endereço que não
+// é de um proxy confiável é o cliente. Os da esquerda dele podem ter sido inventados
+// pelo próprio cliente, então não são considerados.
+func resolveClientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && containsAddr(trusted, ip); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// um endereço inválido encerra a cadeia, o último proxy é quem responde por ele
+			break
+		}
+		ip = hop.Unmap()
+	}
+
+	return ip, true
+}
+
+// knownBots são user agents de scanners de vulnerabilidade e crawlers agressivos,
+// bloqueados quando known_bots está ligado.
+var knownBots = regexp.MustCompile(`(?i)sqlmap|nikto|nmap|masscan|zgrab|nuclei|dirbuster|gobuster|wpscan|acunetix|netsparker|scrapy|ahrefsbot|semrushbot|mj12bot|dotbot|petalbot`)
+
+// BotDetectionConfig compara o User-Agent com as expressões regulares das listas.
+// Um user agent em allow sempre passa, mesmo que também esteja em deny ou na lista
+// de bots conhecidos.
+type BotDetectionConfig struct {
+	Allow     []string `input:"allow"`
+	Deny      []string `input:"deny"`
+	KnownBots bool     `input:"known_bots" default:"true"`
+
+	allow, deny []*regexp.Regexp
+}
+
+func (c *BotDetectionConfig) Validate() error {
+	var errs []error
+	c.allow, errs = compilePatterns("allow", c.Allow, errs)
+	c.deny, errs = compilePatterns("deny", c.Deny, errs)
+
+	return errors.Join(errs...)
+}
+
+func compilePatterns(field string, values []string, errs []error) ([]*regexp.Regexp, []error) {
+	patterns := make([]*regexp.Regexp, 0, len(values))
+
+	for _, value := range values {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid regex %q: %w", field, value, err))
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, errs
+}
+
+func matchesAny(patterns []*regexp.Regexp, value string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BotDetection bloqueia a request pelo User-Agent.
+func BotDetection(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[BotDetectionConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userAgent := r.UserAgent()
+
+		if !matchesAny(settings.allow, userAgent) &&
+			(matchesAny(settings.deny, userAgent) || (settings.KnownBots && knownBots.MatchString(userAgent))) {
+			slog.Info("bot detected", slog.String("user_agent", userAgent))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		f(w, r)
+	}
+}
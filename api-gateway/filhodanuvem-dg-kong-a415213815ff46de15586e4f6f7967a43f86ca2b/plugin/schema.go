@@ -0,0 +1,309 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+var ErrInvalidInput = errors.New("invalid input")
+
+// O schema de um plugin é uma struct com as tags:
+//
+//	input:"nome"            nome do campo no config.yaml
+//	input:"nome,required"   o campo precisa estar no input
+//	default:"valor"         valor usado quando o campo não vem, listas separadas por vírgula
+//	oneof:"a|b"             valores aceitos, em listas vale para cada item
+//
+// Campos string, int, float64, bool, listas e mapas com chave string são aceitos.
+// Os campos de uma struct embutida sem a tag input ficam no mesmo nível do input.
+// Se o schema tiver o método Validate() error, ele é chamado depois da decodificação
+// para as regras que envolvem mais de um campo.
+
+// Decode confere o input do plugin com o schema registrado e devolve a configuração
+// tipada. Plugins sem schema devolvem nil.
+func Decode(p kong.Plugin) (any, error) {
+	definition, err := FindPlugin(p.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if definition.Schema == nil {
+		return nil, nil
+	}
+
+	return decodeInput(reflect.TypeOf(definition.Schema), p.Input)
+}
+
+// Settings devolve a configuração tipada do plugin. Ela é decodificada uma vez só
+// quando a configuração é validada; plugins montados direto no código, que não
+// passaram pela validação, são decodificados na hora.
+func Settings[T any](p kong.Plugin) (*T, error) {
+	if settings, ok := p.Settings.(*T); ok {
+		return settings, nil
+	}
+
+	settings, err := decodeInput(reflect.TypeOf((*T)(nil)).Elem(), p.Input)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %q: %w", p.Name, err)
+	}
+
+	return settings.(*T), nil
+}
+
+func decodeInput(t reflect.Type, input map[string]any) (any, error) {
+	v := reflect.New(t)
+
+	var value any = input
+	if input == nil {
+		value = map[string]any{}
+	}
+
+	// os erros ficam numa lista só, um por campo, em vez de aninhados
+	if errs := decodeValue("input", v.Elem(), value); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if validator, ok := v.Interface().(interface{ Validate() error }); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidInput, err)
+		}
+	}
+
+	return v.Interface(), nil
+}
+
+func decodeValue(path string, dst reflect.Value, value any) []error {
+	switch dst.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return typeError(path, "a string", value)
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int64:
+		i, ok := toInt(value)
+		if !ok {
+			return typeError(path, "an int", value)
+		}
+		dst.SetInt(i)
+	case reflect.Float64:
+		switch n := value.(type) {
+		case float64:
+			dst.SetFloat(n)
+		case int:
+			dst.SetFloat(float64(n))
+		default:
+			return typeError(path, "a number", value)
+		}
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return typeError(path, "a bool", value)
+		}
+		dst.SetBool(b)
+	case reflect.Slice:
+		items, ok := value.([]any)
+		if !ok {
+			return typeError(path, "a list", value)
+		}
+
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		var errs []error
+		for i, item := range items {
+			errs = append(errs, decodeValue(fmt.Sprintf("%s[%d]", path, i), slice.Index(i), item)...)
+		}
+		dst.Set(slice)
+
+		return errs
+	case reflect.Map:
+		items, ok := value.(map[string]any)
+		if !ok {
+			return typeError(path, "a map", value)
+		}
+
+		m := reflect.MakeMapWithSize(dst.Type(), len(items))
+		var errs []error
+		for key, item := range items {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if elemErrs := decodeValue(fmt.Sprintf("%s.%s", path, key), elem, item); len(elemErrs) > 0 {
+				errs = append(errs, elemErrs...)
+				continue
+			}
+			m.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		dst.Set(m)
+
+		return errs
+	case reflect.Struct:
+		items, ok := value.(map[string]any)
+		if !ok {
+			return typeError(path, "a map", value)
+		}
+
+		return decodeStruct(path, dst, items)
+	case reflect.Interface:
+		if value != nil {
+			dst.Set(reflect.ValueOf(value))
+		}
+	default:
+		return []error{fmt.Errorf("%s: unsupported schema type %s", path, dst.Type())}
+	}
+
+	return nil
+}
+
+func decodeStruct(path string, dst reflect.Value, items map[string]any) []error {
+	known := map[string]bool{}
+	errs := decodeFields(path, dst, items, known)
+
+	// campos que o plugin não conhece quase sempre são erros de digitação
+	for name := range items {
+		if !known[name] {
+			errs = append(errs, fmt.Errorf("%w: %s.%s is not a known field", ErrInvalidInput, path, name))
+		}
+	}
+
+	return errs
+}
+
+// decodeFields preenche os campos da struct. Structs embutidas sem a tag input
+// têm os campos lidos do mesmo nível, assim schemas podem dividir opções comuns.
+func decodeFields(path string, dst reflect.Value, items map[string]any, known map[string]bool) []error {
+	var errs []error
+
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("input")
+		if !ok {
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				errs = append(errs, decodeFields(path, dst.Field(i), items, known)...)
+			}
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		known[name] = true
+		fieldPath := path + "." + name
+
+		value, ok := items[name]
+		if !ok || value == nil {
+			if options == "required" {
+				errs = append(errs, fmt.Errorf("%w: %s is required", ErrInvalidInput, fieldPath))
+				continue
+			}
+
+			if def, ok := field.Tag.Lookup("default"); ok {
+				for _, err := range setDefault(dst.Field(i), def) {
+					errs = append(errs, fmt.Errorf("%s: invalid default: %w", fieldPath, err))
+				}
+			}
+			continue
+		}
+
+		if fieldErrs := decodeValue(fieldPath, dst.Field(i), value); len(fieldErrs) > 0 {
+			errs = append(errs, fieldErrs...)
+			continue
+		}
+
+		if oneOf, ok := field.Tag.Lookup("oneof"); ok {
+			if err := checkOneOf(fieldPath, dst.Field(i), strings.Split(oneOf, "|")); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+func checkOneOf(path string, v reflect.Value, allowed []string) error {
+	values := []string{fmt.Sprintf("%v", v.Interface())}
+	if v.Kind() == reflect.Slice {
+		values = nil
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, fmt.Sprintf("%v", v.Index(i).Interface()))
+		}
+	}
+
+	for _, value := range values {
+		if !slices.Contains(allowed, value) {
+			return fmt.Errorf("%w: %s must be one of %s, got %q", ErrInvalidInput, path, strings.Join(allowed, ", "), value)
+		}
+	}
+
+	return nil
+}
+
+func setDefault(dst reflect.Value, def string) []error {
+	if dst.Kind() != reflect.Slice {
+		return decodeValue("default", dst, parseDefault(dst.Kind(), def))
+	}
+
+	var items []any
+	for _, item := range strings.Split(def, ",") {
+		items = append(items, parseDefault(dst.Type().Elem().Kind(), item))
+	}
+
+	return decodeValue("default", dst, items)
+}
+
+func parseDefault(kind reflect.Kind, def string) any {
+	switch kind {
+	case reflect.Int, reflect.Int64:
+		if i, err := strconv.Atoi(def); err == nil {
+			return i
+		}
+	case reflect.Float64:
+		if f, err := strconv.ParseFloat(def, 64); err == nil {
+			return f
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(def); err == nil {
+			return b
+		}
+	}
+
+	return def
+}
+
+func toInt(value any) (int64, bool) {
+	switch n := value.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		// números vindos de JSON chegam como float64
+		if n == math.Trunc(n) {
+			return int64(n), true
+		}
+	}
+
+	return 0, false
+}
+
+func typeError(path, expected string, value any) []error {
+	return []error{fmt.Errorf("%w: %s must be %s, got %s", ErrInvalidInput, path, expected, describe(value))}
+}
+
+func describe(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+
+	return fmt.Sprintf("%T %v", value, value)
+}
@@ -0,0 +1,384 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/devgymbr/kong"
+)
+
+// Os valores de replace e add aceitam templates no formato $(origem.nome):
+//
+//	$(path_params.id)      parâmetro do path da rota
+//	$(headers.X-Tenant)    header da request
+//	$(query_params.page)   parâmetro da query string
+//	$(claims.sub)          claim do token validado pelo jwt_auth
+//
+// Quando o valor não existe na request, o template vira uma string vazia.
+// Campos do body JSON podem ser aninhados com ponto, como customer.document.
+var templatePattern = regexp.MustCompile(`\$\(([^)]*)\)`)
+
+var templateSources = []string{"path_params", "headers", "query_params", "claims"}
+
+// TransformerNames são os nomes que o remove apaga.
+type TransformerNames struct {
+	Headers     []string `input:"headers"`
+	Querystring []string `input:"querystring"`
+	Body        []string `input:"body"`
+}
+
+// TransformerValues são os valores por nome do rename, replace e add. No rename
+// o valor é o novo nome.
+type TransformerValues struct {
+	Headers     map[string]string `input:"headers"`
+	Querystring map[string]string `input:"querystring"`
+	Body        map[string]string `input:"body"`
+}
+
+// RequestTransformerConfig altera a request antes dela ir para o upstream. As
+// operações rodam na ordem remove, rename, replace (só o que já existe) e add
+// (só o que ainda não existe).
+type RequestTransformerConfig struct {
+	Remove  TransformerNames  `input:"remove"`
+	Rename  TransformerValues `input:"rename"`
+	Replace TransformerValues `input:"replace"`
+	Add     TransformerValues `input:"add"`
+}
+
+func (c *RequestTransformerConfig) Validate() error {
+	return validateTemplates(c.Replace.Headers, c.Replace.Querystring, c.Replace.Body, c.Add.Headers, c.Add.Querystring, c.Add.Body)
+}
+
+func (c *RequestTransformerConfig) transformsBody() bool {
+	return len(c.Remove.Body) > 0 || len(c.Rename.Body) > 0 || len(c.Replace.Body) > 0 || len(c.Add.Body) > 0
+}
+
+type ResponseTransformerNames struct {
+	Headers []string `input:"headers"`
+	JSON    []string `input:"json"`
+}
+
+type ResponseTransformerValues struct {
+	Headers map[string]string `input:"headers"`
+	JSON    map[string]string `input:"json"`
+}
+
+// ResponseTransformerConfig altera os headers e o body JSON da resposta do upstream,
+// na mesma ordem do RequestTransformerConfig.
+type ResponseTransformerConfig struct {
+	Remove  ResponseTransformerNames  `input:"remove"`
+	Rename  ResponseTransformerValues `input:"rename"`
+	Replace ResponseTransformerValues `input:"replace"`
+	Add     ResponseTransformerValues `input:"add"`
+}
+
+func (c *ResponseTransformerConfig) Validate() error {
+	return validateTemplates(c.Replace.Headers, c.Replace.JSON, c.Add.Headers, c.Add.JSON)
+}
+
+func (c *ResponseTransformerConfig) transformsJSON() bool {
+	return len(c.Remove.JSON) > 0 || len(c.Rename.JSON) > 0 || len(c.Replace.JSON) > 0 || len(c.Add.JSON) > 0
+}
+
+func RequestTransformer(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[RequestTransformerConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if settings.transformsBody() && isJSON(r.Header) {
+			if err := transformRequestBody(settings, r); err != nil {
+				slog.Info("could not transform request body", slog.String("error", err.Error()))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		// o body é transformado antes porque os templates leem os headers originais
+		transformHeaders(r, r.Header, settings.Remove.Headers, settings.Rename.Headers, settings.Replace.Headers, settings.Add.Headers)
+
+		query := r.URL.Query()
+		for _, name := range settings.Remove.Querystring {
+			query.Del(name)
+		}
+		for name, newName := range settings.Rename.Querystring {
+			if values, ok := query[name]; ok {
+				query.Del(name)
+				query[newName] = values
+			}
+		}
+		for name, value := range settings.Replace.Querystring {
+			if query.Has(name) {
+				query.Set(name, render(value, r))
+			}
+		}
+		for name, value := range settings.Add.Querystring {
+			if !query.Has(name) {
+				query.Set(name, render(value, r))
+			}
+		}
+		r.URL.RawQuery = query.Encode()
+
+		f(w, r)
+	}
+}
+
+func transformRequestBody(settings *RequestTransformerConfig, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	document := map[string]any{}
+	if len(bytes.TrimSpace(body)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return fmt.Errorf("body must be a json object: %w", err)
+		}
+	}
+
+	transformJSON(r, document, settings.Remove.Body, settings.Rename.Body, settings.Replace.Body, settings.Add.Body)
+
+	if body, err = json.Marshal(document); err != nil {
+		return err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+// ResponseTransformer é a fase header_filter do response_transformer.
+func ResponseTransformer(p kong.Plugin, r *http.Request, res *ResponseHead) {
+	settings, err := Settings[ResponseTransformerConfig](p)
+	if err != nil {
+		slog.Error("invalid plugin input", slog.String("error", err.Error()))
+		return
+	}
+
+	transformHeaders(r, res.Header, settings.Remove.Headers, settings.Rename.Headers, settings.Replace.Headers, settings.Add.Headers)
+}
+
+// ResponseBodyTransformer é a fase body_filter do response_transformer. Só respostas
+// JSON sem Content-Encoding são alteradas, e para isso o body inteiro fica em memória.
+func ResponseBodyTransformer(p kong.Plugin, r *http.Request, res *ResponseHead, w io.Writer) io.WriteCloser {
+	settings, err := Settings[ResponseTransformerConfig](p)
+	if err != nil || !settings.transformsJSON() {
+		return nil
+	}
+
+	if !isJSON(res.Header) || (res.Header.Get("Content-Encoding") != "" && res.Header.Get("Content-Encoding") != "identity") {
+		return nil
+	}
+
+	return &jsonBodyFilter{w: w, r: r, settings: settings}
+}
+
+type jsonBodyFilter struct {
+	w        io.Writer
+	r        *http.Request
+	settings *ResponseTransformerConfig
+	buf      bytes.Buffer
+}
+
+func (f *jsonBodyFilter) Write(b []byte) (int, error) {
+	return f.buf.Write(b)
+}
+
+func (f *jsonBodyFilter) Close() error {
+	if f.buf.Len() == 0 {
+		return nil
+	}
+
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(f.buf.Bytes()))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		// o upstream disse que era JSON mas não é, então a resposta segue como veio
+		slog.Error("could not transform response body", slog.String("error", err.Error()))
+		_, err = f.w.Write(f.buf.Bytes())
+		return err
+	}
+
+	// em listas, cada objeto é transformado
+	objects := []any{document}
+	if items, ok := document.([]any); ok {
+		objects = items
+	}
+
+	settings := f.settings
+	for _, item := range objects {
+		if object, ok := item.(map[string]any); ok {
+			transformJSON(f.r, object, settings.Remove.JSON, settings.Rename.JSON, settings.Replace.JSON, settings.Add.JSON)
+		}
+	}
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.w.Write(body)
+	return err
+}
+
+func transformHeaders(r *http.Request, header http.Header, remove []string, rename, replace, add map[string]string) {
+	for _, name := range remove {
+		header.Del(name)
+	}
+
+	for name, newName := range rename {
+		if values := header.Values(name); len(values) > 0 {
+			values = append([]string(nil), values...)
+			header.Del(name)
+			header[http.CanonicalHeaderKey(newName)] = values
+		}
+	}
+
+	for name, value := range replace {
+		if header.Get(name) != "" {
+			header.Set(name, render(value, r))
+		}
+	}
+
+	for name, value := range add {
+		if header.Get(name) == "" {
+			header.Set(name, render(value, r))
+		}
+	}
+}
+
+func transformJSON(r *http.Request, document map[string]any, remove []string, rename, replace, add map[string]string) {
+	for _, path := range remove {
+		if parent, key, ok := jsonField(document, path, false); ok {
+			delete(parent, key)
+		}
+	}
+
+	for path, newPath := range rename {
+		parent, key, ok := jsonField(document, path, false)
+		if !ok {
+			continue
+		}
+
+		value, exists := parent[key]
+		if !exists {
+			continue
+		}
+		delete(parent, key)
+
+		if newParent, newKey, ok := jsonField(document, newPath, true); ok {
+			newParent[newKey] = value
+		}
+	}
+
+	for path, value := range replace {
+		if parent, key, ok := jsonField(document, path, false); ok {
+			if _, exists := parent[key]; exists {
+				parent[key] = render(value, r)
+			}
+		}
+	}
+
+	for path, value := range add {
+		if parent, key, ok := jsonField(document, path, true); ok {
+			if _, exists := parent[key]; !exists {
+				parent[key] = render(value, r)
+			}
+		}
+	}
+}
+
+// jsonField devolve o objeto que guarda o último campo do path. Com create, os
+// objetos intermediários que faltam são criados.
+func jsonField(document map[string]any, path string, create bool) (map[string]any, string, bool) {
+	names := strings.Split(path, ".")
+	current := document
+
+	for _, name := range names[:len(names)-1] {
+		next, ok := current[name].(map[string]any)
+		if !ok {
+			if _, exists := current[name]; exists || !create {
+				return nil, "", false
+			}
+
+			next = map[string]any{}
+			current[name] = next
+		}
+		current = next
+	}
+
+	return current, names[len(names)-1], true
+}
+
+func render(value string, r *http.Request) string {
+	return templatePattern.ReplaceAllStringFunc(value, func(template string) string {
+		source, name, _ := strings.Cut(template[2:len(template)-1], ".")
+
+		switch source {
+		case "path_params":
+			return kong.PathParam(r, name)
+		case "headers":
+			return r.Header.Get(name)
+		case "query_params":
+			return r.URL.Query().Get(name)
+		case "claims":
+			claims, _ := kong.ClaimsFromContext(r.Context())
+			claim, ok := claims[name]
+			if !ok {
+				return ""
+			}
+
+			if s, ok := claim.(string); ok {
+				return s
+			}
+
+			// números e listas saem como JSON, 1700000000 e não 1.7e+09
+			b, _ := json.Marshal(claim)
+			return string(b)
+		}
+
+		return ""
+	})
+}
+
+func validateTemplates(values ...map[string]string) error {
+	var errs []error
+
+	for _, fields := range values {
+		for _, value := range fields {
+			for _, match := range templatePattern.FindAllStringSubmatch(value, -1) {
+				source, name, _ := strings.Cut(match[1], ".")
+				if name == "" || !slices.Contains(templateSources, source) {
+					errs = append(errs, fmt.Errorf("invalid template %q, expected $(%s.name)", match[0], strings.Join(templateSources, "|")))
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func isJSON(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
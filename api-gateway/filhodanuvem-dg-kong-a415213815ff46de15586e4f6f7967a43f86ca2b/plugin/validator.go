@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/devgymbr/kong"
+)
+
+// RequestValidatorConfig tem um JSON Schema para o body, outro para a query string
+// e outro para os headers. Cada um pode ficar no próprio config.yaml ou num arquivo
+// JSON, que é lido quando a configuração é carregada.
+type RequestValidatorConfig struct {
+	BodySchema       any    `input:"body_schema"`
+	BodySchemaFile   string `input:"body_schema_file"`
+	QuerySchema      any    `input:"query_schema"`
+	QuerySchemaFile  string `input:"query_schema_file"`
+	HeaderSchema     any    `input:"header_schema"`
+	HeaderSchemaFile string `input:"header_schema_file"`
+	// MaxBodySize é o maior body lido para a validação, em bytes; maiores recebem 413
+	MaxBodySize int `input:"max_body_size" default:"1048576"`
+
+	body, query, header *jsonSchema
+}
+
+func (c *RequestValidatorConfig) Validate() error {
+	var errs []error
+	var err error
+
+	if c.body, err = loadJSONSchema("body_schema", c.BodySchema, c.BodySchemaFile); err != nil {
+		errs = append(errs, err)
+	}
+	if c.query, err = loadJSONSchema("query_schema", c.QuerySchema, c.QuerySchemaFile); err != nil {
+		errs = append(errs, err)
+	}
+	if c.header, err = loadJSONSchema("header_schema", c.HeaderSchema, c.HeaderSchemaFile); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.MaxBodySize <= 0 {
+		errs = append(errs, fmt.Errorf("max_body_size must be greater than 0, got %d", c.MaxBodySize))
+	}
+
+	if len(errs) == 0 && c.body == nil && c.query == nil && c.header == nil {
+		return errors.New("at least one of body_schema, query_schema or header_schema is required")
+	}
+
+	return errors.Join(errs...)
+}
+
+func loadJSONSchema(field string, inline any, fileName string) (*jsonSchema, error) {
+	if inline != nil && fileName != "" {
+		return nil, fmt.Errorf("only one of %s or %s_file can be set", field, field)
+	}
+
+	if fileName != "" {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("%s_file: %w", field, err)
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err = decoder.Decode(&inline); err != nil {
+			return nil, fmt.Errorf("%s_file: invalid json: %w", field, err)
+		}
+	}
+
+	if inline == nil {
+		return nil, nil
+	}
+
+	schema, err := compileJSONSchema(inline)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+
+	return schema, nil
+}
+
+// RequestViolation diz em que parte da request (body, query ou header) está o problema.
+type RequestViolation struct {
+	In string `json:"in"`
+	SchemaViolation
+}
+
+type requestValidationResponse struct {
+	Message string             `json:"message"`
+	Errors  []RequestViolation `json:"errors"`
+}
+
+// RequestValidator responde 400 com a lista de violações quando a request não
+// segue os schemas, sem chamar o upstream.
+func RequestValidator(p kong.Plugin, f http.HandlerFunc) http.HandlerFunc {
+	settings, err := Settings[RequestValidatorConfig](p)
+	if err != nil {
+		return invalidSettings(err)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var violations []RequestViolation
+		add := func(in string, found []SchemaViolation) {
+			for _, v := range found {
+				violations = append(violations, RequestViolation{In: in, SchemaViolation: v})
+			}
+		}
+
+		if settings.header != nil {
+			add("header", settings.header.validate("", settings.header.coerceParams(headerParams(r.Header, settings.header))))
+		}
+
+		if settings.query != nil {
+			add("query", settings.query.validate("", settings.query.coerceParams(r.URL.Query())))
+		}
+
+		if settings.body != nil {
+			body, err := io.ReadAll(io.LimitReader(r.Body, int64(settings.MaxBodySize)+1))
+			if err != nil {
+				slog.Error("could not read request body", slog.String("error", err.Error()))
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if len(body) > settings.MaxBodySize {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			// o body volta para o início para o upstream receber ele inteiro, como no RequestSizeLimit
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+			add("body", validateJSONBody(settings.body, r.Header, body))
+		}
+
+		if len(violations) > 0 {
+			slog.Info("request validation failed", slog.Int("violations", len(violations)))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+
+			if err := json.NewEncoder(w).Encode(requestValidationResponse{Message: "request validation failed", Errors: violations}); err != nil {
+				slog.Error("could not encode validation response", slog.String("error", err.Error()))
+			}
+			return
+		}
+
+		f(w, r)
+	}
+}
+
+func validateJSONBody(schema *jsonSchema, header http.Header, body []byte) []SchemaViolation {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return []SchemaViolation{{Message: "is required"}}
+	}
+
+	if !isJSON(header) {
+		return []SchemaViolation{{Message: "content type must be application/json"}}
+	}
+
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return []SchemaViolation{{Message: "must be valid json: " + err.Error()}}
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return []SchemaViolation{{Message: "must be valid json: unexpected data after the document"}}
+	}
+
+	return schema.validate("", document)
+}
+
+// headerParams só olha os headers que o schema cita, nos outros o cliente e os
+// proxies do caminho colocam o que quiserem.
+func headerParams(header http.Header, schema *jsonSchema) map[string][]string {
+	params := map[string][]string{}
+
+	names := append([]string(nil), schema.required...)
+	for name := range schema.properties {
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		if values := header.Values(name); len(values) > 0 {
+			params[name] = values
+		}
+	}
+
+	return params
+}
+
+// coerceParams monta o objeto validado a partir de parâmetros em texto. Um parâmetro
+// repetido vira lista.
+func (s *jsonSchema) coerceParams(params map[string][]string) map[string]any {
+	object := make(map[string]any, len(params))
+
+	for name, values := range params {
+		items := make([]any, len(values))
+		for i, value := range values {
+			items[i] = value
+		}
+
+		var value any = items
+		if len(items) == 1 {
+			value = items[0]
+		}
+
+		switch {
+		case s.properties[name] != nil:
+			value = s.properties[name].coerce(value)
+		case s.additionalProperties != nil:
+			value = s.additionalProperties.coerce(value)
+		}
+		object[name] = value
+	}
+
+	return object
+}
@@ -1,13 +1,63 @@
 package routes
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 )
 
+// paramTypes são os tipos aceitos em {nome:tipo}. Sem tipo, o parâmetro é alfanumérico.
+var paramTypes = map[string]string{
+	"":      `[a-zA-Z0-9]+`,
+	"alnum": `[a-zA-Z0-9]+`,
+	"alpha": `[a-zA-Z]+`,
+	"int":   `[0-9]+`,
+	"slug":  `[a-zA-Z0-9_-]+`,
+	"uuid":  `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	// segment aceita o segmento inteiro, como report.pdf ou user@example.com
+	"segment": `[^/]+`,
+}
+
+var paramRegexp = regexp.MustCompile(`{([a-zA-Z0-9_]+)(?::([a-zA-Z0-9_]*))?}`)
+
 func Parse(rawRoute string) (*regexp.Regexp, error) {
-	r, _ := regexp.Compile("{[a-zA-Z0-9]+}")
+	expr, err := translate(rawRoute)
+	if err != nil {
+		return nil, err
+	}
+
+	return regexp.Compile("^" + expr + "$")
+}
+
+// ParamNames devolve os nomes dos parâmetros de rawRoute na ordem em que aparecem.
+func ParamNames(rawRoute string) []string {
+	var names []string
+	for _, m := range paramRegexp.FindAllStringSubmatch(rawRoute, -1) {
+		names = append(names, m[1])
+	}
 
-	replaced := r.ReplaceAll([]byte(rawRoute), []byte("([a-zA-Z0-9]+)"))
+	return names
+}
+
+// translate troca cada {nome:tipo} por um grupo nomeado. O resto do path continua
+// sendo interpretado como expressão regular, como sempre foi.
+func translate(rawRoute string) (string, error) {
+	var err error
+	expr := paramRegexp.ReplaceAllStringFunc(rawRoute, func(param string) string {
+		m := paramRegexp.FindStringSubmatch(param)
+		typeExpr, ok := paramTypes[m[2]]
+		if !ok {
+			err = fmt.Errorf("unknown type %q for param %q", m[2], m[1])
+			return param
+		}
+
+		return "(?P<" + m[1] + ">" + typeExpr + ")"
+	})
+
+	return expr, err
+}
 
-	return regexp.Compile("^" + string(replaced) + "$")
+// segments quebra um path em segmentos. "/" vira um único segmento vazio.
+func segments(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
 }
@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"maps"
+	"regexp"
+	"strings"
+)
+
+// Router é uma árvore de prefixos em que cada aresta é um segmento do path.
+// Segmentos fixos são encontrados por mapa, então o custo de uma busca depende
+// da profundidade do path e não da quantidade de rotas cadastradas.
+type Router[T any] struct {
+	root *node[T]
+}
+
+// Match é uma rota encontrada pelo Lookup. Depth é quantos segmentos do path
+// a rota consumiu, o que sobra depois disso é o resto de uma rota de prefixo.
+type Match[T any] struct {
+	Value  T
+	Params map[string]string
+	Depth  int
+	Exact  bool
+}
+
+type node[T any] struct {
+	static  map[string]*node[T]
+	dynamic []*dynamicEdge[T]
+	exact   []T
+	prefix  []T
+}
+
+// dynamicEdge é um segmento com parâmetro ou expressão regular.
+type dynamicEdge[T any] struct {
+	raw   string
+	re    *regexp.Regexp
+	child *node[T]
+}
+
+func NewRouter[T any]() *Router[T] {
+	return &Router[T]{root: newNode[T]()}
+}
+
+func newNode[T any]() *node[T] {
+	return &node[T]{static: map[string]*node[T]{}}
+}
+
+// Add cadastra value no pattern. Com prefix, o pattern também casa com qualquer
+// path que comece pelos mesmos segmentos.
+func (rt *Router[T]) Add(pattern string, prefix bool, value T) error {
+	segs := segments(pattern)
+	if prefix && segs[len(segs)-1] == "" {
+		// "/api/" como prefixo é o mesmo que "/api" e "/" casa com tudo
+		segs = segs[:len(segs)-1]
+	}
+
+	n := rt.root
+	for _, seg := range segs {
+		if !isDynamic(seg) {
+			child, ok := n.static[seg]
+			if !ok {
+				child = newNode[T]()
+				n.static[seg] = child
+			}
+			n = child
+			continue
+		}
+
+		edge, err := n.dynamicEdge(seg)
+		if err != nil {
+			return err
+		}
+		n = edge.child
+	}
+
+	if prefix {
+		n.prefix = append(n.prefix, value)
+	} else {
+		n.exact = append(n.exact, value)
+	}
+
+	return nil
+}
+
+// Lookup devolve todas as rotas que casam com path. Mais de uma pode casar
+// (hosts e headers diferentes, por exemplo), quem chama decide a prioridade.
+func (rt *Router[T]) Lookup(path string) []Match[T] {
+	var matches []Match[T]
+	rt.root.lookup(segments(path), 0, nil, &matches)
+
+	return matches
+}
+
+func (n *node[T]) dynamicEdge(seg string) (*dynamicEdge[T], error) {
+	for _, edge := range n.dynamic {
+		if edge.raw == seg {
+			return edge, nil
+		}
+	}
+
+	re, err := Parse(seg)
+	if err != nil {
+		return nil, err
+	}
+
+	edge := &dynamicEdge[T]{raw: seg, re: re, child: newNode[T]()}
+	n.dynamic = append(n.dynamic, edge)
+
+	return edge, nil
+}
+
+func (n *node[T]) lookup(segs []string, depth int, params map[string]string, matches *[]Match[T]) {
+	for _, value := range n.prefix {
+		*matches = append(*matches, Match[T]{Value: value, Params: params, Depth: depth})
+	}
+
+	if depth == len(segs) {
+		for _, value := range n.exact {
+			*matches = append(*matches, Match[T]{Value: value, Params: params, Depth: depth, Exact: true})
+		}
+		return
+	}
+
+	seg := segs[depth]
+	if child, ok := n.static[seg]; ok {
+		child.lookup(segs, depth+1, params, matches)
+	}
+
+	for _, edge := range n.dynamic {
+		m := edge.re.FindStringSubmatch(seg)
+		if m == nil {
+			continue
+		}
+
+		// cada caminho da árvore tem sua cópia dos parâmetros
+		captured := maps.Clone(params)
+		if captured == nil {
+			captured = map[string]string{}
+		}
+		for i, name := range edge.re.SubexpNames() {
+			if name != "" {
+				captured[name] = m[i]
+			}
+		}
+
+		edge.child.lookup(segs, depth+1, captured, matches)
+	}
+}
+
+// Remainder devolve o que sobrou de path depois dos depth primeiros segmentos.
+func Remainder(path string, depth int) string {
+	segs := segments(path)
+	if depth >= len(segs) {
+		return ""
+	}
+
+	return strings.Join(segs[depth:], "/")
+}
+
+// StaticSegments conta os segmentos sem parâmetro, quanto mais, mais específica é a rota.
+func StaticSegments(pattern string) int {
+	count := 0
+	for _, seg := range segments(pattern) {
+		if seg != "" && !isDynamic(seg) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func isDynamic(seg string) bool {
+	return regexp.QuoteMeta(seg) != seg
+}
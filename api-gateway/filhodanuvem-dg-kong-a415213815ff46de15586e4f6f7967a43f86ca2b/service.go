@@ -2,26 +2,118 @@ package kong
 
 import (
 	"errors"
+	"maps"
 	"regexp"
+	"slices"
 )
 
 type Service struct {
-	Name    string   `yaml:"name"`
-	URL     string   `yaml:"url"`
-	Plugins []Plugin `yaml:"plugins"`
-	Routes  []Route  `yaml:"routes"`
+	Name           string         `yaml:"name" json:"name"`
+	URL            string         `yaml:"url" json:"url"`
+	Protocol       string         `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	Timeouts       Timeouts       `yaml:"timeouts,omitempty" json:"timeouts,omitempty"`
+	Retries        RetryPolicy    `yaml:"retries,omitempty" json:"retries,omitempty"`
+	ConnectionPool ConnectionPool `yaml:"connection_pool,omitempty" json:"connection_pool,omitempty"`
+	TLS            UpstreamTLS    `yaml:"tls,omitempty" json:"tls,omitempty"`
+	Plugins        []Plugin       `yaml:"plugins" json:"plugins"`
+	Routes         []Route        `yaml:"routes" json:"routes"`
+}
+
+func (s Service) Clone() Service {
+	clone := s
+	clone.Retries.StatusCodes = slices.Clone(s.Retries.StatusCodes)
+	clone.Plugins = clonePlugins(s.Plugins)
+
+	clone.Routes = make([]Route, len(s.Routes))
+	for i, route := range s.Routes {
+		clone.Routes[i] = route
+		clone.Routes[i].Paths = slices.Clone(route.Paths)
+		clone.Routes[i].Methods = slices.Clone(route.Methods)
+		clone.Routes[i].Hosts = slices.Clone(route.Hosts)
+		clone.Routes[i].Headers = maps.Clone(route.Headers)
+		clone.Routes[i].Plugins = clonePlugins(route.Plugins)
+		clone.Routes[i].Split = route.Split.Clone()
+	}
+
+	return clone
+}
+
+func clonePlugins(plugins []Plugin) []Plugin {
+	if plugins == nil {
+		return nil
+	}
+
+	clone := make([]Plugin, len(plugins))
+	for i, plugin := range plugins {
+		clone[i] = Plugin{
+			Name:      plugin.Name,
+			Input:     maps.Clone(plugin.Input),
+			Consumers: slices.Clone(plugin.Consumers),
+			Groups:    slices.Clone(plugin.Groups),
+			Settings:  plugin.Settings,
+		}
+	}
+
+	return clone
 }
 
 type Plugin struct {
-	Name  string         `yaml:"name"`
-	Input map[string]any `yaml:"input,omitempty"`
+	Name  string         `yaml:"name" json:"name"`
+	Input map[string]any `yaml:"input,omitempty" json:"input,omitempty"`
+	// Consumers e Groups limitam o plugin a esses consumers ou grupos de consumers.
+	// Vazios, o plugin vale para todas as requests.
+	Consumers []string `yaml:"consumers,omitempty" json:"consumers,omitempty"`
+	Groups    []string `yaml:"groups,omitempty" json:"groups,omitempty"`
+	// Settings é o Input já conferido e convertido para a struct do plugin,
+	// preenchido quando a configuração é validada.
+	Settings any `yaml:"-" json:"-"`
 }
 
+func (p Plugin) Scoped() bool {
+	return len(p.Consumers) > 0 || len(p.Groups) > 0
+}
+
+// AppliesTo diz se o plugin deve rodar para o consumer, que é nil quando a request
+// não foi autenticada por nenhum plugin.
+func (p Plugin) AppliesTo(consumer *Consumer) bool {
+	if !p.Scoped() {
+		return true
+	}
+
+	if consumer == nil {
+		return false
+	}
+
+	return slices.Contains(p.Consumers, consumer.Name) || consumer.InGroup(p.Groups...)
+}
+
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+)
+
 type Route struct {
-	Name       string   `yaml:"name"`
-	Paths      []string `yaml:"paths"`
-	PathRegexp *regexp.Regexp
-	Methods    []string `yaml:"methods"`
+	Name  string   `yaml:"name" json:"name"`
+	Paths []string `yaml:"paths" json:"paths"`
+	// PathRegexp é o primeiro path compilado, só é usado quando a configuração
+	// não passou pelo Compile (configurações montadas direto no código).
+	PathRegexp *regexp.Regexp `yaml:"-" json:"-"`
+	Methods    []string       `yaml:"methods" json:"methods"`
+	// Hosts aceita curingas no começo, como *.example.com
+	Hosts   []string            `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	Headers map[string][]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Match é exact (padrão) ou prefix
+	Match string `yaml:"match,omitempty" json:"match,omitempty"`
+	// StripPath remove do path enviado ao upstream a parte que casou com a rota
+	StripPath bool `yaml:"strip_path,omitempty" json:"strip_path,omitempty"`
+	// RewritePath substitui a parte que casou com a rota, aceitando os parâmetros capturados: /v2/orders/{id}
+	RewritePath string `yaml:"rewrite_path,omitempty" json:"rewrite_path,omitempty"`
+	// Priority desempata rotas que casam com a mesma request, a maior vence
+	Priority int `yaml:"priority,omitempty" json:"priority,omitempty"`
+	// Plugins da rota substituem os de mesmo nome do serviço e os globais
+	Plugins []Plugin `yaml:"plugins,omitempty" json:"plugins,omitempty"`
+	// Split manda parte das requests da rota para outros serviços (canary)
+	Split TrafficSplit `yaml:"split,omitempty" json:"split,omitempty"`
 }
 
 var ErrPluginNotFound = errors.New("plugin not found")
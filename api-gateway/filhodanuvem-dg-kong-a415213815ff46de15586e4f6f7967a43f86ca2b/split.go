@@ -0,0 +1,148 @@
+package kong
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	mathrand "math/rand"
+	"net/http"
+	"slices"
+)
+
+// TrafficSplit desvia parte do tráfego de uma rota para outros serviços, como uma
+// versão nova do upstream. O que não vai para nenhum destino continua no serviço
+// da rota. Os plugins continuam sendo os da rota: só o upstream muda.
+type TrafficSplit struct {
+	Destinations []SplitDestination `yaml:"destinations,omitempty" json:"destinations,omitempty"`
+	// StickyCookie é o cookie que mantém o cliente no mesmo destino entre requests.
+	// Sem ele, cada request é sorteada de novo.
+	StickyCookie string `yaml:"sticky_cookie,omitempty" json:"sticky_cookie,omitempty"`
+}
+
+// SplitDestination recebe as requests que têm os headers e cookies listados, ou
+// Weight por cento do tráfego quando não tem nenhum deles. Weight 0 pausa o destino
+// sem tirar ele da configuração.
+type SplitDestination struct {
+	Service string              `yaml:"service" json:"service"`
+	Weight  int                 `yaml:"weight,omitempty" json:"weight,omitempty"`
+	Headers map[string][]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Cookies map[string][]string `yaml:"cookies,omitempty" json:"cookies,omitempty"`
+}
+
+func (s TrafficSplit) Clone() TrafficSplit {
+	clone := s
+	clone.Destinations = slices.Clone(s.Destinations)
+	for i, destination := range s.Destinations {
+		clone.Destinations[i].Headers = cloneValues(destination.Headers)
+		clone.Destinations[i].Cookies = cloneValues(destination.Cookies)
+	}
+
+	return clone
+}
+
+func cloneValues(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+
+	clone := make(map[string][]string, len(m))
+	for k, v := range m {
+		clone[k] = slices.Clone(v)
+	}
+
+	return clone
+}
+
+// Matched diz se o destino é escolhido pelos headers e cookies e não pelo peso.
+func (d SplitDestination) Matched() bool {
+	return len(d.Headers) > 0 || len(d.Cookies) > 0
+}
+
+func (d SplitDestination) matchesRequest(r *http.Request) bool {
+	for name, values := range d.Headers {
+		if !slices.Contains(values, r.Header.Get(name)) {
+			return false
+		}
+	}
+
+	for name, values := range d.Cookies {
+		cookie, err := r.Cookie(name)
+		if err != nil || !slices.Contains(values, cookie.Value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Upstream escolhe o serviço que recebe a request da rota. Os destinos por header
+// ou cookie são conferidos primeiro, na ordem em que aparecem; depois a request cai
+// numa faixa de 0 a 99, que com sticky_cookie vem do hash do valor do cookie. O
+// cookie devolvido, quando não é nil, precisa ir na resposta para o cliente manter
+// o mesmo destino nas próximas requests.
+func (c *Config) Upstream(match *RouteMatch, r *http.Request) (*Service, *http.Cookie) {
+	split := match.Route.Split
+	if len(split.Destinations) == 0 {
+		return match.Service, nil
+	}
+
+	for _, destination := range split.Destinations {
+		if destination.Matched() && destination.matchesRequest(r) {
+			if service := c.findService(destination.Service); service != nil {
+				return service, nil
+			}
+		}
+	}
+
+	var cookie *http.Cookie
+	var bucket int
+	if split.StickyCookie != "" {
+		value := ""
+		if existing, err := r.Cookie(split.StickyCookie); err == nil && existing.Value != "" {
+			value = existing.Value
+		} else {
+			value = newStickyValue()
+			cookie = &http.Cookie{Name: split.StickyCookie, Value: value, Path: "/", HttpOnly: true}
+		}
+
+		h := fnv.New32a()
+		h.Write([]byte(value))
+		bucket = int(h.Sum32() % 100)
+	} else {
+		bucket = mathrand.Intn(100)
+	}
+
+	total := 0
+	for _, destination := range split.Destinations {
+		if destination.Matched() {
+			continue
+		}
+
+		total += destination.Weight
+		if bucket < total {
+			if service := c.findService(destination.Service); service != nil {
+				return service, cookie
+			}
+			break
+		}
+	}
+
+	return match.Service, cookie
+}
+
+func (c *Config) findService(name string) *Service {
+	for i := range c.Services {
+		if c.Services[i].Name == name {
+			return &c.Services[i]
+		}
+	}
+
+	return nil
+}
+
+func newStickyValue() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
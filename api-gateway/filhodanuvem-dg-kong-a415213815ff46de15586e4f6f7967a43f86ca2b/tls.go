@@ -0,0 +1,164 @@
+package kong
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var ErrCertificateNotFound = errors.New("certificate not found")
+
+// TLSConfig são os certificados do listener HTTPS. Eles são lidos de novo a cada
+// reload da configuração, então um certificado renovado vale depois de um kill -HUP.
+type TLSConfig struct {
+	Certificates []Certificate `yaml:"certificates,omitempty" json:"certificates,omitempty"`
+	// RedirectHTTP responde 308 com o endereço https para requests que chegaram sem TLS
+	RedirectHTTP bool `yaml:"redirect_http,omitempty" json:"redirect_http,omitempty"`
+	// HTTPSPort é a porta usada no endereço do redirect, 443 quando vazia
+	HTTPSPort int `yaml:"https_port,omitempty" json:"https_port,omitempty"`
+}
+
+// Certificate é usado quando o SNI do cliente é um dos hosts, que aceitam curinga
+// no primeiro nível (*.example.com). Um certificado sem hosts é o padrão para
+// clientes sem SNI ou com um host que nenhum outro atende.
+type Certificate struct {
+	Hosts    []string `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+	CertFile string   `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string   `yaml:"key_file" json:"key_file"`
+}
+
+func (c TLSConfig) Clone() TLSConfig {
+	clone := c
+	clone.Certificates = make([]Certificate, len(c.Certificates))
+	for i, certificate := range c.Certificates {
+		clone.Certificates[i] = certificate
+		clone.Certificates[i].Hosts = slices.Clone(certificate.Hosts)
+	}
+
+	return clone
+}
+
+// certificateIndex guarda os certificados já carregados por host.
+type certificateIndex struct {
+	byHost   map[string]*tls.Certificate
+	fallback *tls.Certificate
+}
+
+func loadCertificates(certificates []Certificate) (*certificateIndex, error) {
+	index := &certificateIndex{byHost: map[string]*tls.Certificate{}}
+
+	var first *tls.Certificate
+	for i, certificate := range certificates {
+		pair, err := tls.LoadX509KeyPair(certificate.CertFile, certificate.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls.certificates[%d]: %w", i, err)
+		}
+
+		if first == nil {
+			first = &pair
+		}
+
+		if len(certificate.Hosts) == 0 && index.fallback == nil {
+			index.fallback = &pair
+		}
+
+		for _, host := range certificate.Hosts {
+			host = strings.ToLower(host)
+			if _, ok := index.byHost[host]; !ok {
+				index.byHost[host] = &pair
+			}
+		}
+	}
+
+	// sem um certificado padrão, o primeiro da lista atende quem não tem SNI
+	if index.fallback == nil {
+		index.fallback = first
+	}
+
+	return index, nil
+}
+
+// Certificate escolhe o certificado pelo SNI do cliente, no formato do
+// GetCertificate do crypto/tls.
+func (c *Config) Certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if c.certificates == nil {
+		return nil, ErrCertificateNotFound
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if certificate, ok := c.certificates.byHost[host]; ok {
+		return certificate, nil
+	}
+
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		if certificate, ok := c.certificates.byHost["*."+parent]; ok {
+			return certificate, nil
+		}
+	}
+
+	if c.certificates.fallback == nil {
+		return nil, ErrCertificateNotFound
+	}
+
+	return c.certificates.fallback, nil
+}
+
+// UpstreamTLS configura as conexões HTTPS com o upstream: um bundle de CAs no
+// lugar das do sistema e o certificado do cliente para upstreams que exigem mTLS.
+// Como os certificados do listener, os arquivos são lidos de novo a cada reload.
+type UpstreamTLS struct {
+	CACertFile     string `yaml:"ca_cert_file,omitempty" json:"ca_cert_file,omitempty"`
+	ClientCertFile string `yaml:"client_cert_file,omitempty" json:"client_cert_file,omitempty"`
+	ClientKeyFile  string `yaml:"client_key_file,omitempty" json:"client_key_file,omitempty"`
+	// ServerName troca o host usado no SNI e na verificação do certificado do upstream
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+
+	// loaded é o Config lido no Compile da configuração
+	loaded *tls.Config
+}
+
+// Config monta o tls.Config das conexões com o upstream. Sem nenhuma opção, devolve nil
+// e o padrão do net/http é usado.
+func (u UpstreamTLS) Config() (*tls.Config, error) {
+	if u.CACertFile == "" && u.ClientCertFile == "" && u.ClientKeyFile == "" && u.ServerName == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{ServerName: u.ServerName, MinVersion: tls.VersionTLS12}
+
+	if u.CACertFile != "" {
+		data, err := os.ReadFile(u.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", u.CACertFile)
+		}
+	}
+
+	if u.ClientCertFile != "" || u.ClientKeyFile != "" {
+		pair, err := tls.LoadX509KeyPair(u.ClientCertFile, u.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
+
+// ClientConfig devolve o tls.Config lido no Compile. Um serviço montado no código,
+// que não passou pelo Compile, tem os arquivos lidos agora.
+func (u UpstreamTLS) ClientConfig() (*tls.Config, error) {
+	if u.loaded != nil {
+		return u.loaded, nil
+	}
+
+	return u.Config()
+}
@@ -0,0 +1,115 @@
+package kong
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Valores usados quando o serviço não define os seus, em milissegundos.
+const (
+	DefaultConnectTimeout = 60000
+	DefaultReadTimeout    = 60000
+	DefaultWriteTimeout   = 60000
+)
+
+// Timeouts da conexão com o upstream, em milissegundos. Read e write valem entre
+// duas leituras ou escritas seguidas, não para a resposta inteira, assim downloads
+// grandes e server-sent events continuam funcionando. Um WebSocket parado por mais
+// que o read timeout é fechado.
+type Timeouts struct {
+	Connect int `yaml:"connect,omitempty" json:"connect,omitempty"`
+	Read    int `yaml:"read,omitempty" json:"read,omitempty"`
+	Write   int `yaml:"write,omitempty" json:"write,omitempty"`
+}
+
+func (t Timeouts) ConnectTimeout() time.Duration {
+	return millisecondsOr(t.Connect, DefaultConnectTimeout)
+}
+
+func (t Timeouts) ReadTimeout() time.Duration {
+	return millisecondsOr(t.Read, DefaultReadTimeout)
+}
+
+func (t Timeouts) WriteTimeout() time.Duration {
+	return millisecondsOr(t.Write, DefaultWriteTimeout)
+}
+
+// RetryPolicy define quando uma request que falhou é enviada de novo para o upstream.
+// Só métodos idempotentes sem body são repetidos, em erros de conexão ou quando o
+// upstream responde um dos status de StatusCodes.
+type RetryPolicy struct {
+	// Attempts é quantas vezes a request pode ser repetida depois da primeira tentativa
+	Attempts    int   `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+	StatusCodes []int `yaml:"status_codes,omitempty" json:"status_codes,omitempty"`
+	// Backoff é a espera antes da primeira repetição, em milissegundos; ela dobra a cada tentativa até MaxBackoff
+	Backoff    int `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	MaxBackoff int `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+}
+
+// Retryable diz se a request pode ser enviada de novo.
+func (p RetryPolicy) Retryable(r *http.Request) bool {
+	if p.Attempts <= 0 || (r.Body != nil && r.Body != http.NoBody) {
+		return false
+	}
+
+	return slices.Contains([]string{
+		http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodPut, http.MethodDelete, http.MethodTrace,
+	}, r.Method)
+}
+
+// Delay é a espera antes da repetição attempt (1 para a primeira).
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := time.Duration(p.Backoff) * time.Millisecond
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= time.Duration(p.MaxBackoff)*time.Millisecond {
+			break
+		}
+	}
+
+	if p.MaxBackoff > 0 {
+		delay = min(delay, time.Duration(p.MaxBackoff)*time.Millisecond)
+	}
+
+	return delay
+}
+
+// Protocolos falados com o upstream. Com http1, upstreams https ainda podem
+// negociar HTTP/2 no handshake TLS. Com http2 a conexão é sempre HTTP/2: upstreams
+// http recebem HTTP/2 sem TLS (h2c), como os servidores gRPC costumam aceitar.
+const (
+	ProtocolHTTP1 = "http1"
+	ProtocolHTTP2 = "http2"
+)
+
+// ConnectionPool ajusta as conexões keep-alive mantidas com o upstream.
+// Zero usa o padrão do net/http.
+type ConnectionPool struct {
+	MaxIdleConns        int `yaml:"max_idle_conns,omitempty" json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost int `yaml:"max_idle_conns_per_host,omitempty" json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     int `yaml:"max_conns_per_host,omitempty" json:"max_conns_per_host,omitempty"`
+	// IdleConnTimeout em milissegundos
+	IdleConnTimeout int `yaml:"idle_conn_timeout,omitempty" json:"idle_conn_timeout,omitempty"`
+}
+
+func millisecondsOr(value, def int) time.Duration {
+	if value <= 0 {
+		value = def
+	}
+
+	return time.Duration(value) * time.Millisecond
+}
+
+// UnhealthyAfter é quantas falhas seguidas deixam um upstream como unhealthy.
+const UnhealthyAfter = 3
+
+// UpstreamHealth é a saúde de um upstream vista pelas requests que passaram por
+// ele: erros de conexão, timeouts e respostas 5xx contam como falha.
+type UpstreamHealth struct {
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastSeen            time.Time `json:"last_seen"`
+}